@@ -72,6 +72,20 @@ func (b *BasePathFs) Chmod(name string, mode os.FileMode) (err error) {
 	return b.source.Chmod(name, mode)
 }
 
+func (b *BasePathFs) Chown(name string, uid, gid int) (err error) {
+	if name, err = b.RealPath(name); err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	return Chown(b.source, name, uid, gid)
+}
+
+func (b *BasePathFs) Lchown(name string, uid, gid int) (err error) {
+	if name, err = b.RealPath(name); err != nil {
+		return &os.PathError{Op: "lchown", Path: name, Err: err}
+	}
+	return Lchown(b.source, name, uid, gid)
+}
+
 func (b *BasePathFs) Name() string {
 	return "BasePathFs"
 }
@@ -142,4 +156,92 @@ func (b *BasePathFs) Create(name string) (f File, err error) {
 	return b.source.Create(name)
 }
 
+func (b *BasePathFs) Lstat(name string) (fi os.FileInfo, err error) {
+	if name, err = b.RealPath(name); err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return Lstat(b.source, name)
+}
+
+// Symlink creates a symbolic link in the base path. Absolute link targets
+// are rewritten to point below the base path, relative ones are kept as
+// they are but must not lead out of the base path from the directory of
+// the link.
+func (b *BasePathFs) Symlink(oldname, newname string) (err error) {
+	if newname, err = b.RealPath(newname); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if filepath.IsAbs(oldname) {
+		if oldname, err = b.RealPath(oldname); err != nil {
+			return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+		}
+	} else {
+		bpath := filepath.Clean(b.path)
+		target := filepath.Join(filepath.Dir(newname), oldname)
+		if bpath != FilePathSeparator && target != bpath && !strings.HasPrefix(target, bpath+FilePathSeparator) {
+			return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrPermission}
+		}
+	}
+	return Symlink(b.source, oldname, newname)
+}
+
+// Readlink returns the destination of the named symbolic link. Absolute
+// link targets below the base path are returned relative to the base path,
+// so they can be used with this Fs again.
+func (b *BasePathFs) Readlink(name string) (link string, err error) {
+	if name, err = b.RealPath(name); err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if link, err = Readlink(b.source, name); err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(link) {
+		return link, nil
+	}
+	bpath := filepath.Clean(b.path)
+	if link == bpath {
+		return FilePathSeparator, nil
+	}
+	if strings.HasPrefix(link, bpath+FilePathSeparator) {
+		return strings.TrimPrefix(link, bpath), nil
+	}
+	return link, nil
+}
+
+// Watch watches the named file in the base path, the events are reported
+// with names relative to the base path.
+func (b *BasePathFs) Watch(name string, recursive bool) (*WatchHandle, error) {
+	realName, err := b.RealPath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	w, err := Watch(b.source, realName, recursive)
+	if err != nil {
+		return nil, err
+	}
+	bpath := filepath.Clean(b.path)
+	return mapWatch(w, func(ev WatchEvent) (WatchEvent, bool) {
+		switch {
+		case bpath == FilePathSeparator:
+		case ev.Name == bpath:
+			ev.Name = FilePathSeparator
+		case strings.HasPrefix(ev.Name, bpath+FilePathSeparator):
+			ev.Name = strings.TrimPrefix(ev.Name, bpath)
+		default:
+			return ev, false
+		}
+		return ev, true
+	}), nil
+}
+
+func (b *BasePathFs) Link(oldname, newname string) (err error) {
+	if oldname, err = b.RealPath(oldname); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	if newname, err = b.RealPath(newname); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	return Link(b.source, oldname, newname)
+}
+
 // vim: ts=4 sw=4 noexpandtab nolist syn=go
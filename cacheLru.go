@@ -0,0 +1,139 @@
+package afero
+
+import (
+	"container/list"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CacheStats are the statistics of a CacheOnReadFs.
+type CacheStats struct {
+	Hits      uint64 // files served from the layer
+	Misses    uint64 // files copied from the base to the layer
+	Evictions uint64 // files removed from the layer to stay within the limits
+	Size      int64  // bytes of the files tracked in the layer
+	Entries   int    // number of files tracked in the layer
+}
+
+// cacheLRU tracks the files a CacheOnReadFs put into its layer in least
+// recently used order. The zero value is an unlimited cache.
+type cacheLRU struct {
+	mu         sync.Mutex
+	maxSize    int64
+	maxEntries int
+	ll         list.List
+	items      map[string]*list.Element
+	stats      CacheStats
+}
+
+type lruEntry struct {
+	name string
+	size int64
+}
+
+func (c *cacheLRU) hit() {
+	c.mu.Lock()
+	c.stats.Hits++
+	c.mu.Unlock()
+}
+
+func (c *cacheLRU) miss() {
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+}
+
+// touch marks name as most recently used with the given size and returns the
+// names which have to be evicted from the layer. The touched file itself is
+// never evicted, even if it exceeds the limits on its own.
+func (c *cacheLRU) touch(name string, size int64) (evict []string) {
+	name = filepath.Clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.items == nil {
+		c.items = make(map[string]*list.Element)
+	}
+	if e, ok := c.items[name]; ok {
+		c.ll.MoveToFront(e)
+		entry := e.Value.(*lruEntry)
+		c.stats.Size += size - entry.size
+		entry.size = size
+	} else {
+		c.items[name] = c.ll.PushFront(&lruEntry{name: name, size: size})
+		c.stats.Size += size
+		c.stats.Entries++
+	}
+
+	for c.ll.Len() > 1 && c.overLimit() {
+		entry := c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+		evict = append(evict, entry.name)
+	}
+	return evict
+}
+
+func (c *cacheLRU) overLimit() bool {
+	return (c.maxSize > 0 && c.stats.Size > c.maxSize) ||
+		(c.maxEntries > 0 && c.stats.Entries > c.maxEntries)
+}
+
+func (c *cacheLRU) removeElement(e *list.Element) *lruEntry {
+	entry := c.ll.Remove(e).(*lruEntry)
+	delete(c.items, entry.name)
+	c.stats.Size -= entry.size
+	c.stats.Entries--
+	return entry
+}
+
+// remove forgets name, and with all set everything below it
+func (c *cacheLRU) remove(name string, all bool) {
+	name = filepath.Clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[name]; ok {
+		c.removeElement(e)
+	}
+	if !all {
+		return
+	}
+	prefix := name + FilePathSeparator
+	for e := c.ll.Front(); e != nil; {
+		next := e.Next()
+		if strings.HasPrefix(e.Value.(*lruEntry).name, prefix) {
+			c.removeElement(e)
+		}
+		e = next
+	}
+}
+
+// rename moves the entries of oldname and everything below it to newname
+func (c *cacheLRU) rename(oldname, newname string) {
+	oldname = filepath.Clean(oldname)
+	newname = filepath.Clean(newname)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := oldname + FilePathSeparator
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*lruEntry)
+		if entry.name != oldname && !strings.HasPrefix(entry.name, prefix) {
+			continue
+		}
+		delete(c.items, entry.name)
+		entry.name = newname + strings.TrimPrefix(entry.name, oldname)
+		if old, ok := c.items[entry.name]; ok {
+			// renamed over a tracked file
+			c.removeElement(old)
+		}
+		c.items[entry.name] = e
+	}
+}
+
+func (c *cacheLRU) getStats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
@@ -2,6 +2,7 @@ package afero
 
 import (
 	"os"
+	"path/filepath"
 	"syscall"
 	"time"
 )
@@ -19,16 +20,37 @@ import (
 // system first. To prevent writing to the base Fs, wrap it in a read-only
 // filter - Note: this will also make the overlay read-only, for writing files
 // in the overlay, use the overlay Fs directly, not via the union Fs.
+//
+// The size of the layer can be limited (see NewLimitedCacheOnReadFs), the
+// least recently used files are removed from the layer first. Only files put
+// into the layer by this union count against the limits.
 type CacheOnReadFs struct {
 	base      Fs
 	layer     Fs
 	cacheTime time.Duration
+	lru       cacheLRU
 }
 
 func NewCacheOnReadFs(base Fs, layer Fs, cacheTime time.Duration) Fs {
 	return &CacheOnReadFs{base: base, layer: layer, cacheTime: cacheTime}
 }
 
+// NewLimitedCacheOnReadFs returns a CacheOnReadFs keeping at most maxSize
+// bytes in at most maxEntries files in the layer. A limit of 0 means
+// unlimited.
+func NewLimitedCacheOnReadFs(base Fs, layer Fs, cacheTime time.Duration, maxSize int64, maxEntries int) Fs {
+	u := &CacheOnReadFs{base: base, layer: layer, cacheTime: cacheTime}
+	u.lru.maxSize = maxSize
+	u.lru.maxEntries = maxEntries
+	return u
+}
+
+// Stats returns the hit, miss and eviction counters and the current size of
+// the cache.
+func (u *CacheOnReadFs) Stats() CacheStats {
+	return u.lru.getStats()
+}
+
 type cacheState int
 
 const (
@@ -65,20 +87,36 @@ func (u *CacheOnReadFs) cacheStatus(name string) (state cacheState, fi os.FileIn
 		return cacheHit, lfi, nil
 	}
 
-	if err == syscall.ENOENT {
+	if err == syscall.ENOENT || os.IsNotExist(err) {
 		return cacheMiss, nil, nil
 	}
-	var ok bool
-	if err, ok = err.(*os.PathError); ok {
-		if err == os.ErrNotExist {
-			return cacheMiss, nil, nil
-		}
-	}
 	return cacheMiss, nil, err
 }
 
 func (u *CacheOnReadFs) copyToLayer(name string) error {
-	return copyToLayer(u.base, u.layer, name)
+	if err := copyToLayer(u.base, u.layer, name); err != nil {
+		return err
+	}
+	u.touch(name)
+	return nil
+}
+
+// touch marks the file in the layer as recently used and evicts other files
+// if the cache grew too large.
+func (u *CacheOnReadFs) touch(name string) {
+	fi, err := u.layer.Stat(name)
+	if err != nil || fi.IsDir() {
+		return
+	}
+	u.account(name, fi.Size())
+}
+
+// account records the size of the file name in the layer and evicts other
+// files if the cache grew too large.
+func (u *CacheOnReadFs) account(name string, size int64) {
+	for _, evict := range u.lru.touch(name, size) {
+		u.layer.Remove(evict)
+	}
 }
 
 func (u *CacheOnReadFs) Chtimes(name string, atime, mtime time.Time) error {
@@ -123,6 +161,47 @@ func (u *CacheOnReadFs) Chmod(name string, mode os.FileMode) error {
 	return u.layer.Chmod(name, mode)
 }
 
+func (u *CacheOnReadFs) Chown(name string, uid, gid int) error {
+	st, _, err := u.cacheStatus(name)
+	if err != nil {
+		return err
+	}
+	switch st {
+	case cacheLocal:
+	case cacheHit:
+		err = Chown(u.base, name, uid, gid)
+	case cacheStale, cacheMiss:
+		if err := u.copyToLayer(name); err != nil {
+			return err
+		}
+		err = Chown(u.base, name, uid, gid)
+	}
+	if err != nil {
+		return err
+	}
+	return Chown(u.layer, name, uid, gid)
+}
+
+// Lchown changes the owner of a symbolic link in the base and, if it is
+// there, in the layer.
+func (u *CacheOnReadFs) Lchown(name string, uid, gid int) error {
+	fi, err := u.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return u.Chown(name, uid, gid)
+	}
+	err = Lchown(u.base, name, uid, gid)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, lerr := Lstat(u.layer, name); lerr != nil {
+		return err
+	}
+	return Lchown(u.layer, name, uid, gid)
+}
+
 func (u *CacheOnReadFs) Stat(name string) (os.FileInfo, error) {
 	st, fi, err := u.cacheStatus(name)
 	if err != nil {
@@ -136,6 +215,35 @@ func (u *CacheOnReadFs) Stat(name string) (os.FileInfo, error) {
 	}
 }
 
+func (u *CacheOnReadFs) Lstat(name string) (os.FileInfo, error) {
+	fi, err := Lstat(u.layer, name)
+	if err != nil {
+		return Lstat(u.base, name)
+	}
+	return fi, nil
+}
+
+// Symlink creates the link in the base and, like all other writes, also in
+// the layer.
+func (u *CacheOnReadFs) Symlink(oldname, newname string) error {
+	if err := Symlink(u.base, oldname, newname); err != nil {
+		return err
+	}
+	if err := u.layer.MkdirAll(filepath.Dir(newname), 0777); err != nil {
+		return err
+	}
+	return Symlink(u.layer, oldname, newname)
+}
+
+func (u *CacheOnReadFs) Readlink(name string) (string, error) {
+	link, err := Readlink(u.base, name)
+	if os.IsNotExist(err) {
+		// not (yet) written to the base, see cacheLocal
+		return Readlink(u.layer, name)
+	}
+	return link, err
+}
+
 func (u *CacheOnReadFs) Rename(oldname, newname string) error {
 	st, _, err := u.cacheStatus(oldname)
 	if err != nil {
@@ -154,7 +262,11 @@ func (u *CacheOnReadFs) Rename(oldname, newname string) error {
 	if err != nil {
 		return err
 	}
-	return u.layer.Rename(oldname, newname)
+	if err = u.layer.Rename(oldname, newname); err != nil {
+		return err
+	}
+	u.lru.rename(oldname, newname)
+	return nil
 }
 
 func (u *CacheOnReadFs) Remove(name string) error {
@@ -170,6 +282,7 @@ func (u *CacheOnReadFs) Remove(name string) error {
 	if err != nil {
 		return err
 	}
+	u.lru.remove(name, false)
 	return u.layer.Remove(name)
 }
 
@@ -186,6 +299,7 @@ func (u *CacheOnReadFs) RemoveAll(name string) error {
 	if err != nil {
 		return err
 	}
+	u.lru.remove(name, true)
 	return u.layer.RemoveAll(name)
 }
 
@@ -195,10 +309,17 @@ func (u *CacheOnReadFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 		return nil, err
 	}
 	switch st {
-	case cacheLocal, cacheHit:
+	case cacheLocal:
+	case cacheHit:
+		u.lru.hit()
+		u.touch(name)
 	default:
+		u.lru.miss()
 		if err := u.copyToLayer(name); err != nil {
-			return nil, err
+			if !os.IsNotExist(err) || flag&os.O_CREATE == 0 {
+				return nil, err
+			}
+			// a new file, created in both below
 		}
 	}
 	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
@@ -211,7 +332,11 @@ func (u *CacheOnReadFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 			bfi.Close() // oops, what if O_TRUNC was set and file opening in the layer failed...?
 			return nil, err
 		}
-		return &UnionFile{base: bfi, layer: lfi}, nil
+		f := &UnionFile{base: bfi, layer: lfi}
+		if st == cacheLocal {
+			return f, nil
+		}
+		return &cacheFile{UnionFile: f, fs: u, name: name}, nil
 	}
 	return u.layer.OpenFile(name, flag, perm)
 }
@@ -234,6 +359,7 @@ func (u *CacheOnReadFs) Open(name string) (File, error) {
 		if bfi.IsDir() {
 			return u.base.Open(name)
 		}
+		u.lru.miss()
 		if err := u.copyToLayer(name); err != nil {
 			return nil, err
 		}
@@ -241,6 +367,7 @@ func (u *CacheOnReadFs) Open(name string) (File, error) {
 
 	case cacheStale:
 		if !fi.IsDir() {
+			u.lru.miss()
 			if err := u.copyToLayer(name); err != nil {
 				return nil, err
 			}
@@ -248,6 +375,8 @@ func (u *CacheOnReadFs) Open(name string) (File, error) {
 		}
 	case cacheHit:
 		if !fi.IsDir() {
+			u.lru.hit()
+			u.touch(name)
 			return u.layer.Open(name)
 		}
 	}
@@ -292,5 +421,49 @@ func (u *CacheOnReadFs) Create(name string) (File, error) {
 		bfh.Close()
 		return nil, err
 	}
-	return &UnionFile{base: bfh, layer: lfh}, nil
+	u.touch(name)
+	return &cacheFile{UnionFile: &UnionFile{base: bfh, layer: lfh}, fs: u, name: name}, nil
+}
+
+// cacheFile is a file written through a CacheOnReadFs, it keeps the size
+// accounted for its copy in the layer up to date, so the limits also hold
+// for the files written through the union.
+type cacheFile struct {
+	*UnionFile
+	fs   *CacheOnReadFs
+	name string
+}
+
+// update accounts the current size of the layer file.
+func (f *cacheFile) update() {
+	if fi, err := f.layer.Stat(); err == nil {
+		f.fs.account(f.name, fi.Size())
+	}
+}
+
+func (f *cacheFile) Write(s []byte) (int, error) {
+	n, err := f.UnionFile.Write(s)
+	f.update()
+	return n, err
+}
+
+func (f *cacheFile) WriteAt(s []byte, o int64) (int, error) {
+	n, err := f.UnionFile.WriteAt(s, o)
+	f.update()
+	return n, err
+}
+
+func (f *cacheFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *cacheFile) Truncate(size int64) error {
+	err := f.UnionFile.Truncate(size)
+	f.update()
+	return err
+}
+
+func (f *cacheFile) Close() error {
+	f.update()
+	return f.UnionFile.Close()
 }
@@ -0,0 +1,46 @@
+package afero
+
+import (
+	"errors"
+	"os"
+)
+
+// Chowner is an optional interface in Afero. It is only implemented by the
+// filesystems which keep track of the owners of their files.
+type Chowner interface {
+	// Chown changes the numeric uid and gid of the named file, following
+	// symbolic links. A uid or gid of -1 means to not change that value.
+	Chown(name string, uid, gid int) error
+
+	// Lchown changes the numeric uid and gid of the named file. If the
+	// file is a symbolic link, it changes the owner of the link itself.
+	Lchown(name string, uid, gid int) error
+}
+
+var ErrNoChown = errors.New("changing the owner not supported by filesystem")
+
+// Chown changes the numeric uid and gid of the named file. It returns
+// ErrNoChown if the filesystem is not a Chowner.
+func (a Afero) Chown(name string, uid, gid int) error {
+	return Chown(a.Fs, name, uid, gid)
+}
+
+func Chown(fs Fs, name string, uid, gid int) error {
+	if c, ok := fs.(Chowner); ok {
+		return c.Chown(name, uid, gid)
+	}
+	return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+}
+
+// Lchown changes the numeric uid and gid of the named file or symbolic
+// link. It returns ErrNoChown if the filesystem is not a Chowner.
+func (a Afero) Lchown(name string, uid, gid int) error {
+	return Lchown(a.Fs, name, uid, gid)
+}
+
+func Lchown(fs Fs, name string, uid, gid int) error {
+	if c, ok := fs.(Chowner); ok {
+		return c.Lchown(name, uid, gid)
+	}
+	return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+}
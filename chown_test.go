@@ -0,0 +1,99 @@
+package afero
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero/mem"
+)
+
+func TestChown(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no owners on windows")
+	}
+	defer removeAllTestFiles(t)
+	for _, fs := range Fss {
+		tmp := testDir(fs)
+		name := filepath.Join(tmp, "file")
+		link := filepath.Join(tmp, "link")
+		WriteFile(fs, name, nil, 0644)
+		Symlink(fs, name, link)
+
+		// giving the file to ourselves works without privileges
+		if err := Chown(fs, name, os.Getuid(), os.Getgid()); err != nil {
+			t.Errorf("%v: Chown: %v", fs.Name(), err)
+		}
+		if err := Lchown(fs, link, -1, -1); err != nil {
+			t.Errorf("%v: Lchown: %v", fs.Name(), err)
+		}
+		if err := Chown(fs, filepath.Join(tmp, "missing"), -1, -1); !os.IsNotExist(err) {
+			t.Errorf("%v: Chown of a missing file: expected ErrNotExist, got %v", fs.Name(), err)
+		}
+	}
+}
+
+func TestChownWrappers(t *testing.T) {
+	owner := func(fs Fs, name string) (int, int) {
+		fi, err := Lstat(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*mem.Stat)
+		return st.Uid, st.Gid
+	}
+
+	base := &MemMapFs{}
+	base.MkdirAll("/base/dir", 0755)
+	WriteFile(base, "/base/dir/file", nil, 0644)
+	base.Symlink("file", "/base/dir/link")
+	bp := NewBasePathFs(base, "/base")
+	if err := Chown(bp, "/dir/file", 1000, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := Lchown(bp, "/dir/link", 1001, 101); err != nil {
+		t.Fatal(err)
+	}
+	if uid, gid := owner(base, "/base/dir/file"); uid != 1000 || gid != 100 {
+		t.Errorf("BasePathFs: Chown gave the file to %d:%d", uid, gid)
+	}
+	if uid, gid := owner(base, "/base/dir/link"); uid != 1001 || gid != 101 {
+		t.Errorf("BasePathFs: Lchown gave the link to %d:%d", uid, gid)
+	}
+	if uid, _ := owner(base, "/base/dir/file"); uid != 1000 {
+		t.Error("BasePathFs: Lchown followed the link")
+	}
+
+	layer := &MemMapFs{}
+	ufs := NewCopyOnWriteFs(NewReadOnlyFs(base), layer)
+	if err := Chown(ufs, "/base/dir/file", 2000, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Lchown(ufs, "/base/dir/link", 2001, -1); err != nil {
+		t.Fatal(err)
+	}
+	if uid, _ := owner(ufs, "/base/dir/file"); uid != 2000 {
+		t.Errorf("CopyOnWriteFs: Chown gave the file to %d", uid)
+	}
+	if uid, _ := owner(ufs, "/base/dir/link"); uid != 2001 {
+		t.Errorf("CopyOnWriteFs: Lchown gave the link to %d", uid)
+	}
+	if uid, _ := owner(base, "/base/dir/file"); uid != 1000 {
+		t.Error("CopyOnWriteFs: the base was modified")
+	}
+
+	if err := Chown(NewReadOnlyFs(base), "/base/dir/file", 0, 0); err != syscall.EPERM {
+		t.Errorf("ReadOnlyFs: expected EPERM, got %v", err)
+	}
+	tfs, err := NewTarFs(bytes.NewReader(newTestTar(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Chown(tfs, "/usr", 0, 0); !errors.Is(err, ErrNoChown) {
+		t.Errorf("TarFs: expected ErrNoChown, got %v", err)
+	}
+}
@@ -366,3 +366,107 @@ func TestUnionCacheExpire(t *testing.T) {
 		t.Errorf("cache time failed: <%s>", data)
 	}
 }
+
+func TestUnionCacheEviction(t *testing.T) {
+	base := &MemMapFs{}
+	layer := &MemMapFs{}
+	ufs := NewLimitedCacheOnReadFs(base, layer, 0, 25, 0).(*CacheOnReadFs)
+
+	base.Mkdir("/data", 0777)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		WriteFile(base, "/data/"+name, []byte("0123456789"), 0644)
+	}
+
+	for _, name := range []string{"a", "b", "a", "c"} {
+		if _, err := ReadFile(ufs, "/data/"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// "b" was the least recently used file when "c" was cached
+	for name, cached := range map[string]bool{"a": true, "b": false, "c": true, "d": false} {
+		if ok, _ := Exists(layer, "/data/"+name); ok != cached {
+			t.Errorf("%s: expected cached %v, got %v", name, cached, ok)
+		}
+	}
+
+	stats := ufs.Stats()
+	expected := CacheStats{Hits: 1, Misses: 3, Evictions: 1, Size: 20, Entries: 2}
+	if stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+
+	if err := ufs.Remove("/data/a"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := ufs.Stats(); stats.Entries != 1 || stats.Size != 10 {
+		t.Errorf("removed file still counted: %+v", stats)
+	}
+}
+
+func TestUnionCacheEvictionWrite(t *testing.T) {
+	base := &MemMapFs{}
+	layer := &MemMapFs{}
+	ufs := NewLimitedCacheOnReadFs(base, layer, 0, 100, 0).(*CacheOnReadFs)
+
+	data := make([]byte, 1000)
+	for _, name := range []string{"/a", "/b", "/c"} {
+		f, err := ufs.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only the file written last stays, even though it exceeds the limit
+	expected := CacheStats{Evictions: 2, Size: 1000, Entries: 1}
+	if stats := ufs.Stats(); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+	for name, cached := range map[string]bool{"/a": false, "/b": false, "/c": true} {
+		if ok, _ := Exists(layer, name); ok != cached {
+			t.Errorf("%s: expected cached %v, got %v", name, cached, ok)
+		}
+	}
+
+	f, err := ufs.OpenFile("/c", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Truncate(10)
+	if stats := ufs.Stats(); stats.Size != 10 {
+		t.Errorf("truncated file accounted with %d bytes", stats.Size)
+	}
+	f.Close()
+	if data, err := ReadFile(ufs, "/a"); err != nil || len(data) != 1000 {
+		t.Errorf("reading an evicted file failed: %d bytes, %v", len(data), err)
+	}
+}
+
+func TestUnionCacheMaxEntries(t *testing.T) {
+	base := &MemMapFs{}
+	layer := &MemMapFs{}
+	ufs := NewLimitedCacheOnReadFs(base, layer, 0, 0, 2).(*CacheOnReadFs)
+
+	base.Mkdir("/data", 0777)
+	for _, name := range []string{"a", "b", "c"} {
+		WriteFile(base, "/data/"+name, []byte(name), 0644)
+		if _, err := ReadFile(ufs, "/data/"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ok, _ := Exists(layer, "/data/a"); ok {
+		t.Error("least recently used file was not evicted")
+	}
+	if stats := ufs.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if data, err := ReadFile(ufs, "/data/a"); err != nil || string(data) != "a" {
+		t.Errorf("reading an evicted file failed: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,132 @@
+package afero_test
+
+import (
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/fstest"
+)
+
+// memMapFsSkip are the known deviations of MemMapFs from the OS, they are
+// inherited by the filesystems wrapping a MemMapFs.
+var memMapFsSkip = []string{
+	"Mkdir/NoParent",
+	"Mkdir/AllOverFile",
+	"Remove/NotEmpty",
+}
+
+func skip(lists ...[]string) []string {
+	var s []string
+	for _, l := range lists {
+		s = append(s, l...)
+	}
+	return s
+}
+
+func TestConformanceOsFs(t *testing.T) {
+	fstest.Run(t, fstest.Config{
+		NewFs: func(t *testing.T) afero.Fs {
+			return afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+		},
+		// files opened through a BasePathFs have their real name
+		Skip: []string{"Create/Name"},
+	})
+}
+
+func TestConformanceMemMapFs(t *testing.T) {
+	fstest.Run(t, fstest.Config{
+		NewFs: func(t *testing.T) afero.Fs {
+			return afero.NewMemMapFs()
+		},
+		Skip: memMapFsSkip,
+	})
+}
+
+func TestConformanceBasePathFs(t *testing.T) {
+	fstest.Run(t, fstest.Config{
+		NewFs: func(t *testing.T) afero.Fs {
+			base := afero.NewMemMapFs()
+			base.MkdirAll("/base", 0777)
+			return afero.NewBasePathFs(base, "/base")
+		},
+		Skip: skip(memMapFsSkip, []string{"Create/Name"}),
+	})
+}
+
+func TestConformanceCopyOnWriteFs(t *testing.T) {
+	fstest.Run(t, fstest.Config{
+		NewFs: func(t *testing.T) afero.Fs {
+			return afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(afero.NewMemMapFs()), afero.NewMemMapFs())
+		},
+		Skip: memMapFsSkip,
+	})
+}
+
+func TestConformanceCacheOnReadFs(t *testing.T) {
+	fstest.Run(t, fstest.Config{
+		NewFs: func(t *testing.T) afero.Fs {
+			return afero.NewCacheOnReadFs(afero.NewMemMapFs(), afero.NewMemMapFs(), 0)
+		},
+		Skip: memMapFsSkip,
+	})
+}
+
+func TestConformanceRegexpFs(t *testing.T) {
+	fstest.Run(t, fstest.Config{
+		NewFs: func(t *testing.T) afero.Fs {
+			return afero.NewRegexpFs(afero.NewMemMapFs(), nil)
+		},
+		// RegexpFs ignores the renaming of directories
+		Skip: skip(memMapFsSkip, []string{"Rename/Directory"}),
+	})
+}
+
+// newSftpFs returns an SftpFs connected to an in-process server of the
+// local file system, without SSH.
+func newSftpFs(t *testing.T) afero.Fs {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// closing the server ends the client connection
+		server.Close()
+		client.Close()
+	})
+	return afero.SftpFs{SftpClient: client}
+}
+
+func TestConformanceSftpFs(t *testing.T) {
+	fstest.Run(t, fstest.Config{
+		NewFs: func(t *testing.T) afero.Fs {
+			return afero.NewBasePathFs(newSftpFs(t), t.TempDir())
+		},
+		Skip: []string{"Create/Name"},
+	})
+}
+
+var differential = flag.Bool("differential", false, "compare MemMapFs against OsFs with random operations")
+
+func TestDifferentialMemMapFs(t *testing.T) {
+	if !*differential {
+		t.Skip("run with -differential, MemMapFs still diverges from OsFs")
+	}
+	if d := fstest.Diff(t, fstest.DiffConfig{
+		NewFs: func(t *testing.T) afero.Fs { return afero.NewMemMapFs() },
+	}); d != nil {
+		t.Error(d)
+	}
+}
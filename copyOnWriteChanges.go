@@ -0,0 +1,405 @@
+package afero
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChangeType is the kind of a change recorded in the overlay of a
+// CopyOnWriteFs.
+type ChangeType int
+
+const (
+	// ChangeAdd is a file, directory or symlink not present in the base
+	ChangeAdd ChangeType = iota
+	// ChangeModify is a file with different content or a symlink with a
+	// different target than in the base
+	ChangeModify
+	// ChangeDelete is a file or directory of the base hidden by a whiteout
+	ChangeDelete
+	// ChangeAttr is a file with the same content as in the base, but with a
+	// different mode or modification time
+	ChangeAttr
+)
+
+func (c ChangeType) String() string {
+	switch c {
+	case ChangeAdd:
+		return "A"
+	case ChangeModify:
+		return "M"
+	case ChangeDelete:
+		return "D"
+	case ChangeAttr:
+		return "T"
+	}
+	return "?"
+}
+
+// Change describes a single difference between the overlay and the base of
+// a CopyOnWriteFs.
+type Change struct {
+	Type ChangeType
+	Path string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s", c.Type, c.Path)
+}
+
+type byPath []Change
+
+func (c byPath) Len() int      { return len(c) }
+func (c byPath) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byPath) Less(i, j int) bool {
+	if c[i].Path == c[j].Path {
+		// a replaced file is deleted before it is added again
+		return c[i].Type == ChangeDelete && c[j].Type != ChangeDelete
+	}
+	return c[i].Path < c[j].Path
+}
+
+// Changes returns the changes recorded in the overlay compared to the base,
+// sorted by path. Directories present in both layers are never reported, as
+// the overlay creates them implicitly when copying files.
+func (u *CopyOnWriteFs) Changes() ([]Change, error) {
+	var changes []Change
+	seen := make(map[Change]bool)
+	add := func(c Change) {
+		if !seen[c] {
+			seen[c] = true
+			changes = append(changes, c)
+		}
+	}
+
+	root := FilePathSeparator
+	err := Walk(u.layer, root, func(path string, lfi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		dir, name := filepath.Split(path)
+		switch {
+		case name == whiteoutOpaqueDir:
+			return u.opaqueChanges(filepath.Clean(dir), add)
+		case strings.HasPrefix(name, whiteoutPrefix):
+			p := filepath.Join(dir, strings.TrimPrefix(name, whiteoutPrefix))
+			if _, err := Lstat(u.base, p); err == nil {
+				add(Change{Type: ChangeDelete, Path: p})
+			}
+			return nil
+		}
+
+		bfi, err := Lstat(u.base, path)
+		if err != nil {
+			add(Change{Type: ChangeAdd, Path: path})
+			return nil
+		}
+		if fileType(lfi) != fileType(bfi) {
+			add(Change{Type: ChangeDelete, Path: path})
+			add(Change{Type: ChangeAdd, Path: path})
+			return nil
+		}
+		switch {
+		case lfi.IsDir():
+		case lfi.Mode()&os.ModeSymlink != 0:
+			llink, err := Readlink(u.layer, path)
+			if err != nil {
+				return err
+			}
+			blink, err := Readlink(u.base, path)
+			if err != nil {
+				return err
+			}
+			if llink != blink {
+				add(Change{Type: ChangeModify, Path: path})
+			}
+		default:
+			same, err := sameContent(u.layer, u.base, path, lfi, bfi)
+			if err != nil {
+				return err
+			}
+			if !same {
+				add(Change{Type: ChangeModify, Path: path})
+			} else if lfi.Mode() != bfi.Mode() || !lfi.ModTime().Equal(bfi.ModTime()) {
+				add(Change{Type: ChangeAttr, Path: path})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byPath(changes))
+	return changes, nil
+}
+
+// opaqueChanges adds the deletion of the base entries below the opaque
+// directory dir, at any depth, which have no counterpart in the overlay.
+// Entries replaced by one of another type are reported by Changes.
+func (u *CopyOnWriteFs) opaqueChanges(dir string, add func(Change)) error {
+	err := Walk(u.base, dir, func(path string, bfi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		lfi, err := Lstat(u.layer, path)
+		if err != nil {
+			add(Change{Type: ChangeDelete, Path: path})
+			if bfi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if bfi.IsDir() && !lfi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Commit applies all changes recorded in the overlay to target, which is
+// usually the writable Fs wrapped by the read only base. On success the
+// overlay is emptied.
+//
+// The content of all added and modified files is first written to a
+// temporary directory at the root of target. Only when this succeeded, the
+// changes are applied: entries which are deleted or replaced are moved into
+// the temporary directory and the staged files are renamed into place. If
+// this fails, the changes applied so far are undone and the moved entries
+// are restored, so target is left as it was (except for missing parent
+// directories of added entries).
+func (u *CopyOnWriteFs) Commit(target Fs) error {
+	changes, err := u.Changes()
+	if err != nil {
+		return err
+	}
+	tmp, err := commitDir(u, target)
+	if err != nil {
+		return err
+	}
+	defer target.RemoveAll(tmp)
+
+	// stage the file contents
+	staged := make(map[string]string)
+	for i, c := range changes {
+		if c.Type != ChangeAdd && c.Type != ChangeModify {
+			continue
+		}
+		fi, err := Lstat(u.layer, c.Path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		name := filepath.Join(tmp, fmt.Sprintf("new%d", i))
+		if err := stageFile(u.layer, target, c.Path, name); err != nil {
+			return err
+		}
+		staged[c.Path] = name
+	}
+
+	j := &commitJournal{target: target}
+	for i, c := range changes {
+		old := filepath.Join(tmp, fmt.Sprintf("old%d", i))
+		if err := j.apply(u.layer, c, staged[c.Path], old); err != nil {
+			j.rollback()
+			return err
+		}
+	}
+	if err := target.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	fis, err := ReadDir(u.layer, FilePathSeparator)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if err := u.layer.RemoveAll(filepath.Join(FilePathSeparator, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitDir creates a new directory at the root of target, which is not
+// used in fs either.
+func commitDir(fs, target Fs) (string, error) {
+	for {
+		name := filepath.Join(FilePathSeparator, ".commit"+nextSuffix())
+		if exists, _ := Exists(target, name); exists {
+			continue
+		}
+		if exists, _ := Exists(fs, name); exists {
+			continue
+		}
+		if err := target.Mkdir(name, 0700); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+}
+
+// stageFile copies the file name from src to the new file tmp in dst.
+func stageFile(src, dst Fs, name, tmp string) error {
+	sfh, err := src.Open(name)
+	if err != nil {
+		return err
+	}
+	defer sfh.Close()
+
+	dfh, err := dst.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dfh, sfh); err != nil {
+		dfh.Close()
+		return err
+	}
+	return dfh.Close()
+}
+
+// commitJournal records the changes applied to the target of a commit, so
+// they can be undone.
+type commitJournal struct {
+	target Fs
+	undo   []func()
+}
+
+// apply applies c to the target. staged is the name of the staged content
+// of an added or modified file, old is where an entry replaced or deleted is
+// moved to.
+func (j *commitJournal) apply(layer Fs, c Change, staged, old string) error {
+	t := j.target
+	ofi, err := Lstat(t, c.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+	if c.Type == ChangeDelete {
+		if !exists {
+			// removed with its parent already
+			return nil
+		}
+		return j.move(c.Path, old)
+	}
+
+	fi, err := Lstat(layer, c.Path)
+	if err != nil {
+		return err
+	}
+	if exists && (c.Type == ChangeAttr || fi.IsDir() && ofi.IsDir()) {
+		// only the attributes change, a directory keeps its content
+		mode, mtime := ofi.Mode().Perm(), ofi.ModTime()
+		j.undo = append(j.undo, func() {
+			t.Chmod(c.Path, mode)
+			t.Chtimes(c.Path, mtime, mtime)
+		})
+	} else {
+		if exists {
+			if err := j.move(c.Path, old); err != nil {
+				return err
+			}
+		}
+		if err := t.MkdirAll(filepath.Dir(c.Path), 0777); err != nil {
+			return err
+		}
+		switch {
+		case staged != "":
+			err = t.Rename(staged, c.Path)
+		case fi.IsDir():
+			err = t.Mkdir(c.Path, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			var link string
+			if link, err = Readlink(layer, c.Path); err == nil {
+				err = Symlink(t, link, c.Path)
+			}
+		default:
+			err = &os.PathError{Op: "commit", Path: c.Path, Err: os.ErrNotExist}
+		}
+		if err != nil {
+			return err
+		}
+		j.undo = append(j.undo, func() { t.RemoveAll(c.Path) })
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	if err := t.Chmod(c.Path, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return t.Chtimes(c.Path, fi.ModTime(), fi.ModTime())
+}
+
+// move moves the entry name out of the way to old.
+func (j *commitJournal) move(name, old string) error {
+	if err := j.target.Rename(name, old); err != nil {
+		return err
+	}
+	j.undo = append(j.undo, func() { j.target.Rename(old, name) })
+	return nil
+}
+
+// rollback undoes all changes applied, the last one first.
+func (j *commitJournal) rollback() {
+	for i := len(j.undo) - 1; i >= 0; i-- {
+		j.undo[i]()
+	}
+}
+
+func fileType(fi os.FileInfo) os.FileMode {
+	if fi.IsDir() {
+		return os.ModeDir
+	}
+	return fi.Mode() & os.ModeSymlink
+}
+
+func sameContent(a, b Fs, name string, afi, bfi os.FileInfo) (bool, error) {
+	if afi.Size() != bfi.Size() {
+		return false, nil
+	}
+	afh, err := a.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer afh.Close()
+	bfh, err := b.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer bfh.Close()
+
+	abuf := make([]byte, 32*1024)
+	bbuf := make([]byte, len(abuf))
+	for {
+		an, aerr := io.ReadFull(afh, abuf)
+		bn, berr := io.ReadFull(bfh, bbuf)
+		if !bytes.Equal(abuf[:an], bbuf[:bn]) {
+			return false, nil
+		}
+		if aerr == io.EOF || aerr == io.ErrUnexpectedEOF {
+			return berr == aerr, nil
+		}
+		if aerr != nil {
+			return false, aerr
+		}
+		if berr != nil {
+			return false, berr
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package afero
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCopyOnWriteChanges(t *testing.T) {
+	base, fs := newWhiteoutTestFs(t, &MemMapFs{})
+	ufs := fs.(*CopyOnWriteFs)
+
+	if err := WriteFile(ufs, "/home/test/file.txt", []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(ufs, "/home/test/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Remove("/home/test/other.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Chmod("/home/test/sub/deep.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Mkdir("/home/newdir", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[A /home/newdir M /home/test/file.txt A /home/test/new.txt D /home/test/other.txt T /home/test/sub/deep.txt]"
+	if fmt.Sprint(changes) != expected {
+		t.Errorf("expected changes %s, got %v", expected, changes)
+	}
+
+	if err := ufs.Commit(base); err != nil {
+		t.Fatal("Commit failed:", err)
+	}
+
+	if data, _ := ReadFile(base, "/home/test/file.txt"); string(data) != "modified" {
+		t.Errorf("modified file not committed, got %q", data)
+	}
+	if data, _ := ReadFile(base, "/home/test/new.txt"); string(data) != "new" {
+		t.Errorf("new file not committed, got %q", data)
+	}
+	if _, err := base.Stat("/home/test/other.txt"); !os.IsNotExist(err) {
+		t.Error("deleted file still in base:", err)
+	}
+	if fi, err := base.Stat("/home/test/sub/deep.txt"); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("chmod not committed: %v, %v", fi, err)
+	}
+	if isDir, _ := IsDir(base, "/home/newdir"); !isDir {
+		t.Error("new directory not committed")
+	}
+	if names := readDirNamesSorted(t, base, "/home/test"); fmt.Sprint(names) != "[file.txt new.txt sub]" {
+		t.Errorf("unexpected base content %v", names)
+	}
+
+	changes, err = ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes after commit, got %v", changes)
+	}
+	if data, _ := ReadFile(ufs, "/home/test/file.txt"); string(data) != "modified" {
+		t.Errorf("union view changed by commit, got %q", data)
+	}
+}
+
+func TestCopyOnWriteChangesReplaceDir(t *testing.T) {
+	base, fs := newWhiteoutTestFs(t, &MemMapFs{})
+	ufs := fs.(*CopyOnWriteFs)
+
+	if err := ufs.RemoveAll("/home/test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Mkdir("/home/test", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(ufs, "/home/test/file.txt", []byte("base /home/test/file.txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[D /home/test/other.txt D /home/test/sub]"
+	if fmt.Sprint(changes[len(changes)-2:]) != expected {
+		t.Errorf("expected changes ending with %s, got %v", expected, changes)
+	}
+
+	if err := ufs.Commit(base); err != nil {
+		t.Fatal("Commit failed:", err)
+	}
+	if names := readDirNamesSorted(t, base, "/home/test"); fmt.Sprint(names) != "[file.txt]" {
+		t.Errorf("unexpected base content %v", names)
+	}
+}
+
+func TestCopyOnWriteChangesRecreatedNestedDir(t *testing.T) {
+	base, fs := newWhiteoutTestFs(t, &MemMapFs{})
+	ufs := fs.(*CopyOnWriteFs)
+
+	if err := ufs.RemoveAll("/home/test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.MkdirAll("/home/test/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Stat("/home/test/sub/deep.txt"); !os.IsNotExist(err) {
+		t.Fatal("file of the removed directory still visible:", err)
+	}
+
+	changes, err := ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[D /home/test/file.txt D /home/test/other.txt D /home/test/sub/deep.txt]"
+	if fmt.Sprint(changes) != expected {
+		t.Errorf("expected changes %s, got %v", expected, changes)
+	}
+
+	if err := ufs.Commit(base); err != nil {
+		t.Fatal("Commit failed:", err)
+	}
+	if names := readDirNamesSorted(t, base, "/home/test"); fmt.Sprint(names) != "[sub]" {
+		t.Errorf("unexpected base content %v", names)
+	}
+	if names := readDirNamesSorted(t, base, "/home/test/sub"); len(names) != 0 {
+		t.Errorf("unexpected base content %v", names)
+	}
+}
+
+func TestCopyOnWriteChangesFileToDir(t *testing.T) {
+	base := &MemMapFs{}
+	WriteFile(base, "/a", []byte("file"), 0644)
+	ufs := NewCopyOnWriteFs(NewReadOnlyFs(base), &MemMapFs{}).(*CopyOnWriteFs)
+
+	if err := ufs.Remove("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Mkdir("/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(ufs, "/a/x", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := ufs.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[D /a A /a A /a/x]"
+	if fmt.Sprint(changes) != expected {
+		t.Errorf("expected changes %s, got %v", expected, changes)
+	}
+
+	if err := ufs.Commit(base); err != nil {
+		t.Fatal("Commit failed:", err)
+	}
+	if names := readDirNamesSorted(t, base, "/"); fmt.Sprint(names) != "[a]" {
+		t.Errorf("unexpected base content %v", names)
+	}
+	if data, err := ReadFile(base, "/a/x"); err != nil || string(data) != "x" {
+		t.Errorf("unexpected content of /a/x: %q, %v", data, err)
+	}
+}
+
+// failRenameFs fails to rename anything to the name fail.
+type failRenameFs struct {
+	Fs
+	fail string
+}
+
+func (f failRenameFs) Rename(oldname, newname string) error {
+	if newname == f.fail {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrPermission}
+	}
+	return f.Fs.Rename(oldname, newname)
+}
+
+func TestCopyOnWriteCommitRollback(t *testing.T) {
+	base, fs := newWhiteoutTestFs(t, &MemMapFs{})
+	ufs := fs.(*CopyOnWriteFs)
+
+	if err := WriteFile(ufs, "/home/test/file.txt", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Remove("/home/test/other.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(ufs, "/home/test/zzz.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ufs.Commit(failRenameFs{Fs: base, fail: "/home/test/zzz.txt"})
+	if !os.IsPermission(err) {
+		t.Fatal("expected the failed rename, got", err)
+	}
+	if names := readDirNamesSorted(t, base, "/"); fmt.Sprint(names) != "[home]" {
+		t.Errorf("unexpected base content %v", names)
+	}
+	if names := readDirNamesSorted(t, base, "/home/test"); fmt.Sprint(names) != "[file.txt other.txt sub]" {
+		t.Errorf("unexpected base content %v", names)
+	}
+	if data, err := ReadFile(base, "/home/test/file.txt"); err != nil || string(data) != "base /home/test/file.txt" {
+		t.Errorf("unexpected content of file.txt: %q, %v", data, err)
+	}
+
+	// the overlay is kept, so the commit can be retried
+	if err := ufs.Commit(base); err != nil {
+		t.Fatal("Commit failed:", err)
+	}
+	if names := readDirNamesSorted(t, base, "/home/test"); fmt.Sprint(names) != "[file.txt sub zzz.txt]" {
+		t.Errorf("unexpected base content %v", names)
+	}
+}
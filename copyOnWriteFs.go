@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -14,21 +15,131 @@ import (
 // is not present in the overlay will copy the file to the overlay ("changing"
 // includes also calls to e.g. Chtimes() and Chmod()).
 //
+// Removing or renaming a file of the base layer leaves a whiteout in the
+// overlay, which hides the base file from then on. A directory created in the
+// place of a removed one is marked as opaque, i.e. the content of the base
+// directory is hidden completely. Whiteouts use the aufs naming scheme:
+// ".wh.<name>" next to the hidden file and ".wh..wh..opq" inside an opaque
+// directory.
+//
 // Reading directories is currently only supported via Open(), not OpenFile().
 type CopyOnWriteFs struct {
 	base  Fs
 	layer Fs
 }
 
+// NewCopyOnWriteFs returns a union of the read only base and the writable
+// layer. Like with overlayfs, the names starting with ".wh." are reserved
+// for whiteouts: creating, opening or renaming to such a name fails with
+// EINVAL.
 func NewCopyOnWriteFs(base Fs, layer Fs) Fs {
 	return &CopyOnWriteFs{base: base, layer: layer}
 }
 
+const (
+	// whiteoutPrefix+name in a layer directory hides name in the base
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaqueDir in a layer directory hides all of the base directory
+	whiteoutOpaqueDir = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// isWhiteoutName reports whether name is reserved for the whiteouts.
+func isWhiteoutName(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), whiteoutPrefix)
+}
+
+func whiteoutName(name string) string {
+	dir, file := filepath.Split(filepath.Clean(name))
+	return filepath.Join(dir, whiteoutPrefix+file)
+}
+
+func (u *CopyOnWriteFs) inLayer(name string) bool {
+	_, err := Lstat(u.layer, name)
+	return err == nil
+}
+
+// Returns true if the file in the base is hidden by a whiteout, an opaque
+// directory or a non-directory in the overlay
+func (u *CopyOnWriteFs) isHidden(name string) bool {
+	for p := filepath.Clean(name); ; {
+		if u.inLayer(whiteoutName(p)) {
+			return true
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return false
+		}
+		if fi, err := Lstat(u.layer, parent); err == nil {
+			if !fi.IsDir() || u.inLayer(filepath.Join(parent, whiteoutOpaqueDir)) {
+				return true
+			}
+		}
+		p = parent
+	}
+}
+
+// Returns true if the file exists in the base and is not hidden
+func (u *CopyOnWriteFs) inBase(name string) bool {
+	if u.isHidden(name) {
+		return false
+	}
+	_, err := Lstat(u.base, name)
+	return err == nil
+}
+
+// Returns true if name is a directory in the base which is not hidden
+func (u *CopyOnWriteFs) isBaseDir(name string) (bool, error) {
+	if u.isHidden(name) {
+		return false, nil
+	}
+	return IsDir(u.base, name)
+}
+
+// whiteout hides the base file name
+func (u *CopyOnWriteFs) whiteout(name string) error {
+	if err := u.layer.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	f, err := u.layer.Create(whiteoutName(name))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (u *CopyOnWriteFs) makeOpaque(name string) error {
+	f, err := u.layer.Create(filepath.Join(name, whiteoutOpaqueDir))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// clearWhiteout removes the whiteout of a file just created in the overlay.
+// A directory replacing a whited out file is made opaque, so the old base
+// content does not show up again.
+func (u *CopyOnWriteFs) clearWhiteout(name string, dir bool) error {
+	wh := whiteoutName(name)
+	if !u.inLayer(wh) {
+		return nil
+	}
+	if err := u.layer.Remove(wh); err != nil {
+		return err
+	}
+	if dir {
+		return u.makeOpaque(name)
+	}
+	return nil
+}
+
 // Returns true if the file is not in the overlay
 func (u *CopyOnWriteFs) isBaseFile(name string) (bool, error) {
 	if _, err := u.layer.Stat(name); err == nil {
 		return false, nil
 	}
+	if u.isHidden(name) {
+		return false, nil
+	}
 	_, err := u.base.Stat(name)
 	if err != nil {
 		if oerr, ok := err.(*os.PathError); ok {
@@ -73,6 +184,35 @@ func (u *CopyOnWriteFs) Chmod(name string, mode os.FileMode) error {
 	return u.layer.Chmod(name, mode)
 }
 
+func (u *CopyOnWriteFs) Chown(name string, uid, gid int) error {
+	b, err := u.isBaseFile(name)
+	if err != nil {
+		return err
+	}
+	if b {
+		if err := u.copyToLayer(name); err != nil {
+			return err
+		}
+	}
+	return Chown(u.layer, name, uid, gid)
+}
+
+// Lchown of a symbolic link present in the base layer copies the link to
+// the overlay first.
+func (u *CopyOnWriteFs) Lchown(name string, uid, gid int) error {
+	fi, err := u.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return u.Chown(name, uid, gid)
+	}
+	if err := u.copyUp(name); err != nil {
+		return err
+	}
+	return Lchown(u.layer, name, uid, gid)
+}
+
 func (u *CopyOnWriteFs) Stat(name string) (os.FileInfo, error) {
 	fi, err := u.layer.Stat(name)
 	if err != nil {
@@ -81,6 +221,9 @@ func (u *CopyOnWriteFs) Stat(name string) (os.FileInfo, error) {
 			err = e.Err
 		}
 		if err == os.ErrNotExist || err == syscall.ENOENT || err == syscall.ENOTDIR {
+			if u.isHidden(name) {
+				return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+			}
 			return u.base.Stat(name)
 		}
 		return nil, origErr
@@ -88,50 +231,204 @@ func (u *CopyOnWriteFs) Stat(name string) (os.FileInfo, error) {
 	return fi, nil
 }
 
-// Renaming files present only in the base layer is not permitted
+func (u *CopyOnWriteFs) Lstat(name string) (os.FileInfo, error) {
+	fi, err := Lstat(u.layer, name)
+	if err != nil {
+		origErr := err
+		if e, ok := err.(*os.PathError); ok {
+			err = e.Err
+		}
+		if err == os.ErrNotExist || err == syscall.ENOENT || err == syscall.ENOTDIR {
+			if u.isHidden(name) {
+				return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+			}
+			return Lstat(u.base, name)
+		}
+		return nil, origErr
+	}
+	return fi, nil
+}
+
+// Symlink creates the link in the overlay, the parent directory is created
+// there if it only exists in the base layer.
+func (u *CopyOnWriteFs) Symlink(oldname, newname string) error {
+	if isWhiteoutName(newname) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EINVAL}
+	}
+	if _, err := u.Lstat(newname); err == nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EEXIST}
+	}
+	dir := filepath.Dir(newname)
+	isaDir, err := u.isBaseDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if isaDir {
+		if err = u.layer.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+	}
+	if err := Symlink(u.layer, oldname, newname); err != nil {
+		return err
+	}
+	return u.clearWhiteout(newname, false)
+}
+
+func (u *CopyOnWriteFs) Readlink(name string) (string, error) {
+	if u.inLayer(name) {
+		return Readlink(u.layer, name)
+	}
+	if u.isHidden(name) {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+	}
+	return Readlink(u.base, name)
+}
+
+// copyUp copies the file or the complete directory tree name from the base
+// to the overlay, files already present in the overlay are kept.
+func (u *CopyOnWriteFs) copyUp(name string) error {
+	return Walk(u, name, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			if u.inLayer(path) {
+				return nil
+			}
+			return u.layer.MkdirAll(path, 0777)
+		case fi.Mode()&os.ModeSymlink != 0:
+			if u.inLayer(path) {
+				return nil
+			}
+			link, err := Readlink(u.base, path)
+			if err != nil {
+				return err
+			}
+			if err := u.layer.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				return err
+			}
+			return Symlink(u.layer, link, path)
+		default:
+			b, err := u.isBaseFile(path)
+			if err != nil || !b {
+				return err
+			}
+			return u.copyToLayer(path)
+		}
+	})
+}
+
+// Renaming a file or directory present in the base layer copies it to the
+// overlay first, the old name in the base is hidden by a whiteout.
 func (u *CopyOnWriteFs) Rename(oldname, newname string) error {
-	b, err := u.isBaseFile(oldname)
+	if isWhiteoutName(oldname) || isWhiteoutName(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EINVAL}
+	}
+	fi, err := u.Lstat(oldname)
 	if err != nil {
 		return err
 	}
-	if b {
-		return syscall.EPERM
+	replaceDir := false
+	if fi.IsDir() && filepath.Clean(oldname) != filepath.Clean(newname) {
+		// like rename(2), a directory only replaces an empty directory,
+		// the merged view of the target counts
+		if target, err := u.Lstat(newname); err == nil && target.IsDir() {
+			empty, err := IsEmpty(u, newname)
+			if err != nil {
+				return err
+			}
+			if !empty {
+				return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.ENOTEMPTY}
+			}
+			replaceDir = true
+		}
+	}
+	oldInBase := u.inBase(oldname)
+	if oldInBase {
+		if err := u.copyUp(oldname); err != nil {
+			return err
+		}
+	}
+	dir := filepath.Dir(newname)
+	isaDir, err := u.isBaseDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if isaDir {
+		if err = u.layer.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+	}
+	newInBase := u.inBase(newname)
+	if replaceDir {
+		// the empty target may still hold whiteouts in the overlay, the
+		// moved directory is made opaque instead
+		if err := u.layer.RemoveAll(newname); err != nil {
+			return err
+		}
+	}
+	if err := u.layer.Rename(oldname, newname); err != nil {
+		return err
+	}
+	if err := u.clearWhiteout(newname, fi.IsDir()); err != nil {
+		return err
+	}
+	if fi.IsDir() && newInBase {
+		if err := u.makeOpaque(newname); err != nil {
+			return err
+		}
+	}
+	if oldInBase {
+		return u.whiteout(oldname)
 	}
-	return u.layer.Rename(oldname, newname)
+	return nil
 }
 
-// Removing files present only in the base layer is not permitted. If
-// a file is present in the base layer and the overlay, only the overlay
-// will be removed.
+// Removing a file present in the base layer creates a whiteout in the
+// overlay, the base layer itself is never changed.
 func (u *CopyOnWriteFs) Remove(name string) error {
-	err := u.layer.Remove(name)
-	switch err {
-	case syscall.ENOENT:
-		_, err = u.base.Stat(name)
-		if err == nil {
-			return syscall.EPERM
-		}
-		return syscall.ENOENT
-	default:
+	fi, err := u.Lstat(name)
+	if err != nil {
 		return err
 	}
+	if fi.IsDir() {
+		empty, err := IsEmpty(u, name)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	inBase := u.inBase(name)
+	if u.inLayer(name) {
+		// an empty directory may still contain whiteouts
+		if err := u.layer.RemoveAll(name); err != nil {
+			return err
+		}
+	}
+	if inBase {
+		return u.whiteout(name)
+	}
+	return nil
 }
 
 func (u *CopyOnWriteFs) RemoveAll(name string) error {
-	err := u.layer.RemoveAll(name)
-	switch err {
-	case syscall.ENOENT:
-		_, err = u.base.Stat(name)
-		if err == nil {
-			return syscall.EPERM
-		}
-		return syscall.ENOENT
-	default:
+	inBase := u.inBase(name)
+	if err := u.layer.RemoveAll(name); err != nil {
 		return err
 	}
+	if inBase {
+		return u.whiteout(name)
+	}
+	return nil
 }
 
 func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if isWhiteoutName(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EINVAL}
+	}
 	b, err := u.isBaseFile(name)
 	if err != nil {
 		return nil, err
@@ -146,7 +443,7 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 		}
 
 		dir := filepath.Dir(name)
-		isaDir, err := IsDir(u.base, dir)
+		isaDir, err := u.isBaseDir(dir)
 		if err != nil && !os.IsNotExist(err) {
 			return nil, err
 		}
@@ -154,7 +451,7 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 			if err = u.layer.MkdirAll(dir, 0777); err != nil {
 				return nil, err
 			}
-			return u.layer.OpenFile(name, flag, perm)
+			return u.openLayerFile(name, flag, perm)
 		}
 
 		isaDir, err = IsDir(u.layer, dir)
@@ -162,7 +459,7 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 			return nil, err
 		}
 		if isaDir {
-			return u.layer.OpenFile(name, flag, perm)
+			return u.openLayerFile(name, flag, perm)
 		}
 
 		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOTDIR} // ...or os.ErrNotExist?
@@ -173,11 +470,28 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 	return u.layer.OpenFile(name, flag, perm)
 }
 
+// openLayerFile opens a file for writing in the overlay, a whiteout for the
+// base file is removed as soon as the file exists in the overlay.
+func (u *CopyOnWriteFs) openLayerFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := u.layer.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.clearWhiteout(name, false); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
 // This function handles the 9 different possibilities caused
 // by the union which are the intersection of the following...
 //  layer: doesn't exist, exists as a file, and exists as a directory
 //  base:  doesn't exist, exists as a file, and exists as a directory
 func (u *CopyOnWriteFs) Open(name string) (File, error) {
+	if isWhiteoutName(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EINVAL}
+	}
 	// Since the overlay overrides the base we check that first
 	b, err := u.isBaseFile(name)
 	if err != nil {
@@ -189,7 +503,8 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 		return u.base.Open(name)
 	}
 
-	// If overlay is a file, return it (base state irrelevant)
+	// If overlay is a file, return it unwrapped (base state irrelevant), so
+	// the capabilities of the overlay file like Locker stay visible
 	dir, err := IsDir(u.layer, name)
 	if err != nil {
 		return nil, err
@@ -203,10 +518,15 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 	// A. It's a file or non-readable in the base (return just the overlay)
 	// B. It's an accessible directory in the base (return a UnionFile)
 
-	// If base is file or nonreadable, return overlay
-	dir, err = IsDir(u.base, name)
+	// If base is file or nonreadable, return overlay (still hiding the
+	// whiteouts)
+	dir, err = u.isBaseDir(name)
 	if !dir || err != nil {
-		return u.layer.Open(name)
+		lfile, err := u.layer.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &UnionFile{layer: lfile, whiteouts: true}, nil
 	}
 
 	// Both base & layer are directories
@@ -219,18 +539,36 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 		return nil, fmt.Errorf("BaseErr: %v\nOverlayErr: %v", bErr, lErr)
 	}
 
-	return &UnionFile{base: bfile, layer: lfile}, nil
+	return &UnionFile{base: bfile, layer: lfile, whiteouts: true}, nil
 }
 
 func (u *CopyOnWriteFs) Mkdir(name string, perm os.FileMode) error {
-	dir, err := IsDir(u.base, name)
-	if err != nil {
-		return u.layer.MkdirAll(name, perm)
+	if isWhiteoutName(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EINVAL}
 	}
-	if dir {
-		return syscall.EEXIST
+	if _, err := u.Lstat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EEXIST}
+	}
+	if fi, err := u.Stat(filepath.Dir(filepath.Clean(name))); err != nil || !fi.IsDir() {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOENT}
+	}
+	return u.mkdirAll(name, perm)
+}
+
+// mkdirAll creates the directory path in the overlay, whited out directories
+// on the way are made opaque.
+func (u *CopyOnWriteFs) mkdirAll(name string, perm os.FileMode) error {
+	if err := u.layer.MkdirAll(name, perm); err != nil {
+		return err
+	}
+	for p := filepath.Clean(name); ; p = filepath.Dir(p) {
+		if err := u.clearWhiteout(p, true); err != nil {
+			return err
+		}
+		if filepath.Dir(p) == p {
+			return nil
+		}
 	}
-	return u.layer.MkdirAll(name, perm)
 }
 
 func (u *CopyOnWriteFs) Name() string {
@@ -238,14 +576,19 @@ func (u *CopyOnWriteFs) Name() string {
 }
 
 func (u *CopyOnWriteFs) MkdirAll(name string, perm os.FileMode) error {
-	dir, err := IsDir(u.base, name)
+	for p := filepath.Clean(name); filepath.Dir(p) != p; p = filepath.Dir(p) {
+		if isWhiteoutName(p) {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EINVAL}
+		}
+	}
+	dir, err := u.isBaseDir(name)
 	if err != nil {
-		return u.layer.MkdirAll(name, perm)
+		return u.mkdirAll(name, perm)
 	}
 	if dir {
 		return syscall.EEXIST
 	}
-	return u.layer.MkdirAll(name, perm)
+	return u.mkdirAll(name, perm)
 }
 
 func (u *CopyOnWriteFs) Create(name string) (File, error) {
@@ -1,6 +1,14 @@
 package afero
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero/mem"
+)
 
 func TestCopyOnWrite(t *testing.T) {
 	var fs Fs
@@ -21,3 +29,206 @@ func TestCopyOnWrite(t *testing.T) {
 	}
 
 }
+
+func newWhiteoutTestFs(t *testing.T, layer Fs) (base Fs, ufs Fs) {
+	base = &MemMapFs{}
+	base.MkdirAll("/home/test/sub", 0777)
+	for _, name := range []string{"/home/test/file.txt", "/home/test/other.txt", "/home/test/sub/deep.txt"} {
+		if err := WriteFile(base, name, []byte("base "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base, NewCopyOnWriteFs(NewReadOnlyFs(base), layer)
+}
+
+func readDirNamesSorted(t *testing.T, fs Fs, name string) []string {
+	fis, err := ReadDir(fs, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func TestCopyOnWriteRemoveBaseFile(t *testing.T) {
+	base, ufs := newWhiteoutTestFs(t, &MemMapFs{})
+
+	if err := ufs.Remove("/home/test/file.txt"); err != nil {
+		t.Fatal("Remove of a base file failed:", err)
+	}
+	if _, err := ufs.Stat("/home/test/file.txt"); !os.IsNotExist(err) {
+		t.Error("removed base file still visible:", err)
+	}
+	if _, err := base.Stat("/home/test/file.txt"); err != nil {
+		t.Error("base was modified:", err)
+	}
+	if err := ufs.Remove("/home/test/file.txt"); err == nil {
+		t.Error("removing a whited out file twice should fail")
+	}
+	if names := readDirNamesSorted(t, ufs, "/home/test"); fmt.Sprint(names) != "[other.txt sub]" {
+		t.Errorf("unexpected directory content %v", names)
+	}
+
+	if err := WriteFile(ufs, "/home/test/file.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ReadFile(ufs, "/home/test/file.txt"); string(data) != "new" {
+		t.Errorf("expected recreated file content, got %q", data)
+	}
+	if names := readDirNamesSorted(t, ufs, "/home/test"); fmt.Sprint(names) != "[file.txt other.txt sub]" {
+		t.Errorf("unexpected directory content %v", names)
+	}
+}
+
+func TestCopyOnWriteWhiteoutNames(t *testing.T) {
+	_, ufs := newWhiteoutTestFs(t, &MemMapFs{})
+	ufs.Remove("/home/test/file.txt")
+
+	for _, test := range []struct {
+		op  string
+		err error
+	}{
+		{"create", func() error { _, err := ufs.Create("/home/test/.wh.other.txt"); return err }()},
+		{"open", func() error { _, err := ufs.Open("/home/test/.wh.file.txt"); return err }()},
+		{"openfile", func() error { _, err := ufs.OpenFile("/home/test/.wh.file.txt", os.O_RDONLY, 0); return err }()},
+		{"mkdir", ufs.Mkdir("/home/test/.wh.dir", 0755)},
+		{"mkdirall", ufs.MkdirAll("/home/test/.wh.dir/sub", 0755)},
+		{"rename", ufs.Rename("/home/test/other.txt", "/home/test/.wh.sub")},
+		{"symlink", Symlink(ufs, "other.txt", "/home/test/.wh.link")},
+	} {
+		if !errors.Is(test.err, syscall.EINVAL) {
+			t.Errorf("%s of a whiteout name: expected EINVAL, got %v", test.op, test.err)
+		}
+	}
+	// the base is left alone
+	if names := readDirNamesSorted(t, ufs, "/home/test"); fmt.Sprint(names) != "[other.txt sub]" {
+		t.Errorf("unexpected directory content %v", names)
+	}
+}
+
+func TestCopyOnWriteOpenLayerFile(t *testing.T) {
+	_, ufs := newWhiteoutTestFs(t, &MemMapFs{})
+	WriteFile(ufs, "/home/test/new.txt", []byte("layer"), 0644)
+
+	// a file only in the overlay is returned as is, with its capabilities
+	f, err := ufs.Open("/home/test/new.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(*mem.File); !ok {
+		t.Errorf("expected the overlay file, got %T", f)
+	}
+	if err := LockFile(f); err != nil {
+		t.Error(err)
+	}
+	d, err := ufs.Open("/home/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, ok := d.(*UnionFile); !ok {
+		t.Errorf("expected a UnionFile for a directory, got %T", d)
+	}
+}
+
+func TestCopyOnWriteRemoveBaseDir(t *testing.T) {
+	base, ufs := newWhiteoutTestFs(t, &MemMapFs{})
+
+	if err := ufs.Remove("/home/test/sub"); err == nil {
+		t.Error("Remove of a non-empty directory should fail")
+	}
+	if err := ufs.RemoveAll("/home/test"); err != nil {
+		t.Fatal("RemoveAll of a base directory failed:", err)
+	}
+	for _, name := range []string{"/home/test", "/home/test/other.txt", "/home/test/sub/deep.txt"} {
+		if _, err := ufs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s still visible: %v", name, err)
+		}
+	}
+	if _, err := ufs.Create("/home/test/new.txt"); err == nil {
+		t.Error("created a file in a removed directory")
+	}
+	if _, err := base.Stat("/home/test/sub/deep.txt"); err != nil {
+		t.Error("base was modified:", err)
+	}
+
+	if err := ufs.MkdirAll("/home/test/sub", 0777); err != nil {
+		t.Fatal(err)
+	}
+	if names := readDirNamesSorted(t, ufs, "/home/test"); fmt.Sprint(names) != "[sub]" {
+		t.Errorf("recreated directory not opaque: %v", names)
+	}
+	if names := readDirNamesSorted(t, ufs, "/home/test/sub"); len(names) != 0 {
+		t.Errorf("recreated directory not empty: %v", names)
+	}
+}
+
+func TestCopyOnWriteRenameBaseFile(t *testing.T) {
+	base, ufs := newWhiteoutTestFs(t, &MemMapFs{})
+
+	if err := ufs.Rename("/home/test/file.txt", "/home/test/renamed.txt"); err != nil {
+		t.Fatal("Rename of a base file failed:", err)
+	}
+	if _, err := ufs.Stat("/home/test/file.txt"); !os.IsNotExist(err) {
+		t.Error("old name still visible:", err)
+	}
+	if data, _ := ReadFile(ufs, "/home/test/renamed.txt"); string(data) != "base /home/test/file.txt" {
+		t.Errorf("unexpected content after rename: %q", data)
+	}
+	if _, err := base.Stat("/home/test/file.txt"); err != nil {
+		t.Error("base was modified:", err)
+	}
+}
+
+func TestCopyOnWriteRenameOntoBaseDir(t *testing.T) {
+	_, ufs := newWhiteoutTestFs(t, &MemMapFs{})
+	ufs.MkdirAll("/new", 0755)
+	WriteFile(ufs, "/new/layer.txt", []byte("layer"), 0644)
+
+	// the target has entries only in the base
+	err := ufs.Rename("/new", "/home/test/sub")
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != syscall.ENOTEMPTY {
+		t.Fatalf("Rename onto a non-empty base directory: expected ENOTEMPTY, got %v", err)
+	}
+	if data, _ := ReadFile(ufs, "/home/test/sub/deep.txt"); string(data) != "base /home/test/sub/deep.txt" {
+		t.Errorf("base content hidden by a failed rename: %q", data)
+	}
+
+	// once its entries are removed, the directory can be replaced
+	if err := ufs.Remove("/home/test/sub/deep.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Rename("/new", "/home/test/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if names := readDirNamesSorted(t, ufs, "/home/test/sub"); fmt.Sprint(names) != "[layer.txt]" {
+		t.Errorf("unexpected content of the replaced directory %v", names)
+	}
+}
+
+func TestCopyOnWriteRenameBaseDir(t *testing.T) {
+	defer CleanupTempDirs(t)
+	for _, layer := range []Fs{&MemMapFs{}, NewTempOsBaseFs(t)} {
+		_, ufs := newWhiteoutTestFs(t, layer)
+
+		if err := ufs.Rename("/home/test/sub", "/home/test/moved"); err != nil {
+			t.Fatal("Rename of a base directory failed:", err)
+		}
+		if _, err := ufs.Stat("/home/test/sub/deep.txt"); !os.IsNotExist(err) {
+			t.Error("old directory still visible:", err)
+		}
+		if data, _ := ReadFile(ufs, "/home/test/moved/deep.txt"); string(data) != "base /home/test/sub/deep.txt" {
+			t.Errorf("unexpected content after rename: %q", data)
+		}
+		if names := readDirNamesSorted(t, ufs, "/home/test"); fmt.Sprint(names) != "[file.txt moved other.txt]" {
+			t.Errorf("unexpected directory content %v", names)
+		}
+		if names := readDirNamesSorted(t, ufs, "/home/test/moved"); fmt.Sprint(names) != "[deep.txt]" {
+			t.Errorf("unexpected content of the moved directory %v", names)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+package fstest
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// DiffConfig configures a differential run, see Diff.
+type DiffConfig struct {
+	// NewFs returns a new, empty Fs to compare against the reference.
+	NewFs func(t *testing.T) afero.Fs
+
+	// NewReference returns a new, empty reference Fs. If nil, an OsFs
+	// rooted in a temporary directory is used.
+	NewReference func(t *testing.T) afero.Fs
+
+	// Seed of the random sequences, if 0 the current time is used. The seed
+	// is part of the report, so a divergence can be reproduced.
+	Seed int64
+
+	// Sequences is the number of random sequences, 100 if 0.
+	Sequences int
+
+	// Length is the number of operations of a sequence, 30 if 0.
+	Length int
+
+	// Ops restricts the generated operations to the given names, e.g.
+	// "Write" or "Rename". All operations are used if empty.
+	Ops []string
+}
+
+// Divergence is a sequence of operations whose results differ between the
+// reference and the tested Fs.
+type Divergence struct {
+	Seed  int64
+	Steps []Step
+}
+
+// Step is an operation of a sequence and the results on both filesystems.
+type Step struct {
+	Op        string
+	Reference string
+	Result    string
+}
+
+func (d *Divergence) String() string {
+	var b strings.Builder
+	// the last step is the comparison of the content
+	fmt.Fprintf(&b, "diverging sequence of %d operations (seed %d):\n", len(d.Steps)-1, d.Seed)
+	for i, s := range d.Steps {
+		marker := " "
+		if s.Reference != s.Result {
+			marker = "!"
+		}
+		fmt.Fprintf(&b, "%s %3d. %s\n", marker, i+1, s.Op)
+		if s.Reference == s.Result {
+			fmt.Fprintf(&b, "        = %s\n", s.Reference)
+		} else {
+			fmt.Fprintf(&b, "        reference: %s\n        result:    %s\n", s.Reference, s.Result)
+		}
+	}
+	return b.String()
+}
+
+// Diff replays random sequences of operations against a reference Fs and
+// the Fs under test and compares the results of every operation and the
+// final content of both. For the first diverging sequence, the minimal
+// subsequence which still diverges is searched and returned. Diff returns
+// nil if no sequence diverged.
+func Diff(t *testing.T, c DiffConfig) *Divergence {
+	if c.NewReference == nil {
+		c.NewReference = func(t *testing.T) afero.Fs {
+			return afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+		}
+	}
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+	if c.Sequences == 0 {
+		c.Sequences = 100
+	}
+	if c.Length == 0 {
+		c.Length = 30
+	}
+	gens := generators
+	if len(c.Ops) > 0 {
+		gens = nil
+		for _, g := range generators {
+			for _, name := range c.Ops {
+				if g.name == name {
+					gens = append(gens, g)
+				}
+			}
+		}
+	}
+	if len(gens) == 0 {
+		t.Fatalf("no operations selected by %v", c.Ops)
+	}
+
+	d := &differ{t: t, c: c, umask: umask(t, c.NewReference(t))}
+	rnd := rand.New(rand.NewSource(c.Seed))
+	for i := 0; i < c.Sequences; i++ {
+		seq := make([]op, c.Length)
+		for j := range seq {
+			seq[j] = gens[rnd.Intn(len(gens))].gen(rnd)
+		}
+		n, _ := d.run(seq)
+		if n < 0 {
+			continue
+		}
+		seq = d.minimize(seq[:n+1])
+		_, steps := d.run(seq)
+		return &Divergence{Seed: c.Seed, Steps: steps}
+	}
+	return nil
+}
+
+type differ struct {
+	t     *testing.T
+	c     DiffConfig
+	umask os.FileMode
+}
+
+// umask returns the permission bits the reference filesystem removes from
+// the mode of new files, the same bits are ignored when comparing modes.
+func umask(t *testing.T, fs afero.Fs) os.FileMode {
+	f, err := fs.OpenFile("/umask", os.O_CREATE|os.O_RDWR, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	fi, err := fs.Stat("/umask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ^fi.Mode().Perm() & os.ModePerm
+}
+
+// run replays seq on new filesystems and returns the index of the first
+// diverging step, -1 if there is none, and all steps. The final content of
+// both filesystems is compared as an additional step.
+func (d *differ) run(seq []op) (int, []Step) {
+	ref, fs := newState(d.c.NewReference(d.t)), newState(d.c.NewFs(d.t))
+	defer ref.closeAll()
+	defer fs.closeAll()
+
+	first := -1
+	var steps []Step
+	for i, o := range seq {
+		s := Step{Op: o.String(), Reference: o.apply(ref, d.umask), Result: o.apply(fs, d.umask)}
+		if first < 0 && s.Reference != s.Result {
+			first = i
+		}
+		steps = append(steps, s)
+		if strings.HasPrefix(s.Reference, "panic: ") || strings.HasPrefix(s.Result, "panic: ") {
+			// a panic is a divergence even if both filesystems panicked,
+			// the rest of the sequence is not replayed
+			if first < 0 {
+				first = i
+			}
+			seq = seq[:i+1]
+			break
+		}
+	}
+	ref.closeAll()
+	fs.closeAll()
+	s := Step{Op: "(content)", Reference: ref.content(d.umask), Result: fs.content(d.umask)}
+	if first < 0 && s.Reference != s.Result {
+		first = len(seq) - 1
+	}
+	return first, append(steps, s)
+}
+
+// minimize removes operations from a diverging sequence as long as it
+// still diverges.
+func (d *differ) minimize(seq []op) []op {
+	for changed := true; changed; {
+		changed = false
+		for i := len(seq) - 1; i >= 0; i-- {
+			shorter := append(append([]op(nil), seq[:i]...), seq[i+1:]...)
+			if n, _ := d.run(shorter); n >= 0 {
+				seq = shorter[:n+1]
+				changed = true
+				if i > len(seq) {
+					i = len(seq)
+				}
+			}
+		}
+	}
+	return seq
+}
+
+// state is a filesystem and the files opened by a sequence
+type state struct {
+	fs      afero.Fs
+	handles [3]afero.File
+}
+
+func newState(fs afero.Fs) *state {
+	return &state{fs: fs}
+}
+
+func (s *state) closeAll() {
+	for i, f := range s.handles {
+		if f != nil {
+			closeFile(f)
+			s.handles[i] = nil
+		}
+	}
+}
+
+// closeFile closes f, a panic of Close was already reported by the
+// operation which broke the file.
+func closeFile(f afero.File) {
+	defer func() { recover() }()
+	f.Close()
+}
+
+// content describes all files and directories below the root
+func (s *state) content(umask os.FileMode) (result string) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+	var lines []string
+	afero.Walk(s.fs, "/", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			lines = append(lines, path+": "+errClass(err))
+			return nil
+		}
+		if path == "/" {
+			return nil
+		}
+		if fi.IsDir() {
+			lines = append(lines, fmt.Sprintf("%s: dir %v", filepath.ToSlash(path), fi.Mode().Perm()&^umask))
+			return nil
+		}
+		data, err := afero.ReadFile(s.fs, path)
+		lines = append(lines, fmt.Sprintf("%s: file %v %q %s",
+			filepath.ToSlash(path), fi.Mode().Perm()&^umask, data, errClass(err)))
+		return nil
+	})
+	sort.Strings(lines)
+	return strings.Join(lines, "; ")
+}
+
+// op is a single operation on a filesystem or an open file
+type op struct {
+	name   string
+	path   string
+	path2  string
+	h      int
+	flag   int
+	perm   os.FileMode
+	data   string
+	off    int64
+	whence int
+	n      int
+}
+
+type generator struct {
+	name string
+	gen  func(r *rand.Rand) op
+}
+
+var (
+	paths  = []string{"/a", "/b", "/d", "/d/a", "/d/e", "/d/e/f"}
+	datas  = []string{"", "x", "hello", "0123456789"}
+	perms  = []os.FileMode{0700, 0750, 0755, 0777}
+	flags  = []int{os.O_RDONLY, os.O_WRONLY, os.O_RDWR}
+	extras = []int{0, os.O_CREATE, os.O_TRUNC, os.O_APPEND, os.O_EXCL}
+)
+
+func pick(r *rand.Rand, s []string) string { return s[r.Intn(len(s))] }
+
+var generators = []generator{
+	{"Create", func(r *rand.Rand) op { return op{name: "Create", path: pick(r, paths), h: r.Intn(3)} }},
+	{"OpenFile", func(r *rand.Rand) op {
+		flag := flags[r.Intn(len(flags))]
+		for i := r.Intn(3); i > 0; i-- {
+			flag |= extras[r.Intn(len(extras))]
+		}
+		return op{name: "OpenFile", path: pick(r, paths), h: r.Intn(3), flag: flag, perm: perms[r.Intn(len(perms))]}
+	}},
+	{"Mkdir", func(r *rand.Rand) op { return op{name: "Mkdir", path: pick(r, paths), perm: perms[r.Intn(len(perms))]} }},
+	{"MkdirAll", func(r *rand.Rand) op {
+		return op{name: "MkdirAll", path: pick(r, paths), perm: perms[r.Intn(len(perms))]}
+	}},
+	{"Remove", func(r *rand.Rand) op { return op{name: "Remove", path: pick(r, paths)} }},
+	{"RemoveAll", func(r *rand.Rand) op { return op{name: "RemoveAll", path: pick(r, paths)} }},
+	{"Rename", func(r *rand.Rand) op { return op{name: "Rename", path: pick(r, paths), path2: pick(r, paths)} }},
+	{"Stat", func(r *rand.Rand) op { return op{name: "Stat", path: pick(r, paths)} }},
+	{"Chmod", func(r *rand.Rand) op { return op{name: "Chmod", path: pick(r, paths), perm: perms[r.Intn(len(perms))]} }},
+	{"Write", func(r *rand.Rand) op { return op{name: "Write", h: r.Intn(3), data: pick(r, datas)} }},
+	{"WriteAt", func(r *rand.Rand) op {
+		return op{name: "WriteAt", h: r.Intn(3), data: pick(r, datas), off: int64(r.Intn(14) - 1)}
+	}},
+	{"Read", func(r *rand.Rand) op { return op{name: "Read", h: r.Intn(3), n: r.Intn(12)} }},
+	{"ReadAt", func(r *rand.Rand) op {
+		return op{name: "ReadAt", h: r.Intn(3), n: r.Intn(12), off: int64(r.Intn(14) - 1)}
+	}},
+	{"Seek", func(r *rand.Rand) op {
+		return op{name: "Seek", h: r.Intn(3), off: int64(r.Intn(16) - 4), whence: r.Intn(3)}
+	}},
+	{"Truncate", func(r *rand.Rand) op { return op{name: "Truncate", h: r.Intn(3), off: int64(r.Intn(14) - 1)} }},
+	{"Readdirnames", func(r *rand.Rand) op { return op{name: "Readdirnames", h: r.Intn(3)} }},
+	{"FileStat", func(r *rand.Rand) op { return op{name: "FileStat", h: r.Intn(3)} }},
+	{"Close", func(r *rand.Rand) op { return op{name: "Close", h: r.Intn(3)} }},
+}
+
+func flagString(flag int) string {
+	var s []string
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		s = append(s, "O_RDONLY")
+	case os.O_WRONLY:
+		s = append(s, "O_WRONLY")
+	case os.O_RDWR:
+		s = append(s, "O_RDWR")
+	}
+	for _, f := range []struct {
+		flag int
+		name string
+	}{{os.O_CREATE, "O_CREATE"}, {os.O_TRUNC, "O_TRUNC"}, {os.O_APPEND, "O_APPEND"}, {os.O_EXCL, "O_EXCL"}} {
+		if flag&f.flag != 0 {
+			s = append(s, f.name)
+		}
+	}
+	return strings.Join(s, "|")
+}
+
+func (o op) String() string {
+	switch o.name {
+	case "Create":
+		return fmt.Sprintf("f%d = Create(%q)", o.h, o.path)
+	case "OpenFile":
+		return fmt.Sprintf("f%d = OpenFile(%q, %s, %v)", o.h, o.path, flagString(o.flag), o.perm)
+	case "Mkdir", "MkdirAll", "Chmod":
+		return fmt.Sprintf("%s(%q, %v)", o.name, o.path, o.perm)
+	case "Remove", "RemoveAll", "Stat":
+		return fmt.Sprintf("%s(%q)", o.name, o.path)
+	case "Rename":
+		return fmt.Sprintf("Rename(%q, %q)", o.path, o.path2)
+	case "Write":
+		return fmt.Sprintf("f%d.Write(%q)", o.h, o.data)
+	case "WriteAt":
+		return fmt.Sprintf("f%d.WriteAt(%q, %d)", o.h, o.data, o.off)
+	case "Read":
+		return fmt.Sprintf("f%d.Read(%d bytes)", o.h, o.n)
+	case "ReadAt":
+		return fmt.Sprintf("f%d.ReadAt(%d bytes, %d)", o.h, o.n, o.off)
+	case "Seek":
+		return fmt.Sprintf("f%d.Seek(%d, %d)", o.h, o.off, o.whence)
+	case "Truncate":
+		return fmt.Sprintf("f%d.Truncate(%d)", o.h, o.off)
+	case "FileStat":
+		return fmt.Sprintf("f%d.Stat()", o.h)
+	}
+	return fmt.Sprintf("f%d.%s()", o.h, o.name)
+}
+
+// apply runs the operation and returns a description of its result, which
+// is compared between the filesystems. A panic is a result as well.
+func (o op) apply(s *state, umask os.FileMode) (result string) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+	switch o.name {
+	case "Create", "OpenFile":
+		if f := s.handles[o.h]; f != nil {
+			f.Close()
+			s.handles[o.h] = nil
+		}
+		var f afero.File
+		var err error
+		if o.name == "Create" {
+			f, err = s.fs.Create(o.path)
+		} else {
+			f, err = s.fs.OpenFile(o.path, o.flag, o.perm)
+		}
+		if err == nil {
+			s.handles[o.h] = f
+		}
+		return errClass(err)
+	case "Mkdir":
+		return errClass(s.fs.Mkdir(o.path, o.perm))
+	case "MkdirAll":
+		return errClass(s.fs.MkdirAll(o.path, o.perm))
+	case "Remove":
+		return errClass(s.fs.Remove(o.path))
+	case "RemoveAll":
+		return errClass(s.fs.RemoveAll(o.path))
+	case "Rename":
+		return errClass(s.fs.Rename(o.path, o.path2))
+	case "Chmod":
+		return errClass(s.fs.Chmod(o.path, o.perm))
+	case "Stat":
+		fi, err := s.fs.Stat(o.path)
+		return fileInfo(fi, err, umask)
+	}
+
+	f := s.handles[o.h]
+	if f == nil {
+		return "no file"
+	}
+	switch o.name {
+	case "Write":
+		n, err := f.Write([]byte(o.data))
+		return fmt.Sprintf("%d %s", n, errClass(err))
+	case "WriteAt":
+		n, err := f.WriteAt([]byte(o.data), o.off)
+		return fmt.Sprintf("%d %s", n, errClass(err))
+	case "Read":
+		buf := make([]byte, o.n)
+		n, err := f.Read(buf)
+		return fmt.Sprintf("%q %s", buf[:n], errClass(err))
+	case "ReadAt":
+		buf := make([]byte, o.n)
+		n, err := f.ReadAt(buf, o.off)
+		return fmt.Sprintf("%q %s", buf[:n], errClass(err))
+	case "Seek":
+		pos, err := f.Seek(o.off, o.whence)
+		if err != nil {
+			return errClass(err)
+		}
+		return fmt.Sprintf("%d", pos)
+	case "Truncate":
+		return errClass(f.Truncate(o.off))
+	case "Readdirnames":
+		names, err := f.Readdirnames(-1)
+		sort.Strings(names)
+		return fmt.Sprintf("%v %s", names, errClass(err))
+	case "FileStat":
+		fi, err := f.Stat()
+		return fileInfo(fi, err, umask)
+	case "Close":
+		s.handles[o.h] = nil
+		return errClass(f.Close())
+	}
+	panic("unknown operation " + o.name)
+}
+
+// fileInfo describes fi, leaving out what differs between filesystems
+// anyway, like the size of directories
+func fileInfo(fi os.FileInfo, err error, umask os.FileMode) string {
+	if err != nil {
+		return errClass(err)
+	}
+	if fi.IsDir() {
+		return fmt.Sprintf("dir %v", fi.Mode().Perm()&^umask)
+	}
+	return fmt.Sprintf("file %v size %d", fi.Mode().Perm()&^umask, fi.Size())
+}
+
+// errClass maps err to a name, errors are only compared by their kind. As
+// in os.IsExist, ENOTEMPTY is reported as EEXIST.
+func errClass(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case err == io.EOF:
+		return "EOF"
+	case os.IsNotExist(err):
+		return "ENOENT"
+	case os.IsExist(err):
+		return "EEXIST"
+	}
+	var errno syscall.Errno
+	switch e := err.(type) {
+	case *os.PathError:
+		errno, _ = e.Err.(syscall.Errno)
+	case *os.LinkError:
+		errno, _ = e.Err.(syscall.Errno)
+	case *os.SyscallError:
+		errno, _ = e.Err.(syscall.Errno)
+	case syscall.Errno:
+		errno = e
+	}
+	switch errno {
+	case syscall.ENOTDIR, syscall.EISDIR, syscall.EINVAL, syscall.EBADF:
+		return errno.Error()
+	}
+	return "error"
+}
@@ -0,0 +1,107 @@
+package fstest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestDiffSame(t *testing.T) {
+	d := Diff(t, DiffConfig{
+		NewFs:        func(t *testing.T) afero.Fs { return afero.NewMemMapFs() },
+		NewReference: func(t *testing.T) afero.Fs { return afero.NewMemMapFs() },
+		Sequences:    20,
+	})
+	if d != nil {
+		t.Errorf("identical filesystems diverged:\n%v", d)
+	}
+}
+
+// forgetfulFs does not remove /d/a
+type forgetfulFs struct {
+	afero.Fs
+}
+
+func (fs forgetfulFs) Remove(name string) error {
+	if filepath.ToSlash(name) == "/d/a" {
+		return nil
+	}
+	return fs.Fs.Remove(name)
+}
+
+func TestDiffMinimal(t *testing.T) {
+	d := Diff(t, DiffConfig{
+		NewFs:        func(t *testing.T) afero.Fs { return forgetfulFs{afero.NewMemMapFs()} },
+		NewReference: func(t *testing.T) afero.Fs { return afero.NewMemMapFs() },
+		Seed:         1,
+		Sequences:    200,
+		Length:       50,
+		Ops:          []string{"Create", "Mkdir", "MkdirAll", "Remove", "Stat", "Write"},
+	})
+	if d == nil {
+		t.Fatal("expected a divergence")
+	}
+	// creating /d/a and removing it is enough
+	if len(d.Steps) > 5 {
+		t.Errorf("sequence not minimized:\n%v", d)
+	}
+	if !strings.Contains(d.String(), `Remove("/d/a")`) {
+		t.Errorf("unexpected sequence:\n%v", d)
+	}
+}
+
+// panickyFs panics on Rename and counts the files left open
+type panickyFs struct {
+	afero.Fs
+	open *int
+}
+
+type countedFile struct {
+	afero.File
+	open *int
+}
+
+func (f countedFile) Close() error {
+	*f.open--
+	return f.File.Close()
+}
+
+func (fs panickyFs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs panickyFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	*fs.open++
+	return countedFile{f, fs.open}, nil
+}
+
+func (fs panickyFs) Rename(oldname, newname string) error {
+	panic("rename")
+}
+
+func TestDiffPanic(t *testing.T) {
+	var open int
+	d := Diff(t, DiffConfig{
+		NewFs:        func(t *testing.T) afero.Fs { return panickyFs{afero.NewMemMapFs(), &open} },
+		NewReference: func(t *testing.T) afero.Fs { return afero.NewMemMapFs() },
+		Seed:         1,
+		Sequences:    20,
+		Ops:          []string{"Create", "OpenFile", "Rename"},
+	})
+	if d == nil {
+		t.Fatal("expected the panic to be reported as a divergence")
+	}
+	if !strings.Contains(d.String(), "panic: rename") {
+		t.Errorf("panic not reported:\n%v", d)
+	}
+	if open != 0 {
+		t.Errorf("%d files left open", open)
+	}
+}
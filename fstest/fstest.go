@@ -0,0 +1,786 @@
+// Package fstest implements a conformance suite for afero.Fs
+// implementations. It checks that an Fs behaves like the file system of the
+// operating system, so third party backends can prove compatibility:
+//
+//	func TestConformance(t *testing.T) {
+//		fstest.Run(t, fstest.Config{
+//			NewFs: func(t *testing.T) afero.Fs { return NewMyFs() },
+//		})
+//	}
+//
+// All checks use absolute paths, an OsFs has to be wrapped in a BasePathFs
+// of a temporary directory.
+package fstest
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Config configures a run of the suite.
+type Config struct {
+	// NewFs returns a new, empty and writable Fs for a single check.
+	NewFs func(t *testing.T) afero.Fs
+
+	// Skip are the names of checks the Fs is known to fail, e.g.
+	// "Rename/Directory". The names are the ones passed to t.Run, a name
+	// without a slash skips a whole group.
+	Skip []string
+}
+
+type check struct {
+	name string
+	fn   func(t *testing.T, fs afero.Fs)
+}
+
+type group struct {
+	name   string
+	checks []check
+}
+
+var groups = []group{
+	{"Create", []check{
+		{"ReadBack", testCreateReadBack},
+		{"Truncates", testCreateTruncates},
+		{"Name", testFileName},
+	}},
+	{"Open", []check{
+		{"NotExist", testOpenNotExist},
+		{"Directory", testOpenDirectory},
+	}},
+	{"OpenFile", []check{
+		{"NotExist", testOpenFileNotExist},
+		{"Excl", testOpenFileExcl},
+		{"Trunc", testOpenFileTrunc},
+		{"Append", testOpenFileAppend},
+		{"ReadOnly", testOpenFileReadOnly},
+		{"WriteOnly", testOpenFileWriteOnly},
+		{"Perm", testOpenFilePerm},
+	}},
+	{"Offset", []check{
+		{"Seek", testSeek},
+		{"SeekPastEnd", testSeekPastEnd},
+		{"WriteAdvances", testWriteAdvances},
+		{"ReadAt", testReadAt},
+		{"WriteAt", testWriteAt},
+	}},
+	{"Truncate", []check{
+		{"Shrink", testTruncateShrink},
+		{"Grow", testTruncateGrow},
+	}},
+	{"Readdir", []check{
+		{"All", testReaddirAll},
+		{"Paginated", testReaddirPaginated},
+		{"Names", testReaddirnames},
+		{"NotDir", testReaddirNotDir},
+	}},
+	{"Mkdir", []check{
+		{"Exists", testMkdirExists},
+		{"NoParent", testMkdirNoParent},
+		{"All", testMkdirAll},
+		{"AllOverFile", testMkdirAllOverFile},
+	}},
+	{"Remove", []check{
+		{"File", testRemoveFile},
+		{"NotExist", testRemoveNotExist},
+		{"NotEmpty", testRemoveNotEmpty},
+		{"EmptyDir", testRemoveEmptyDir},
+	}},
+	{"RemoveAll", []check{
+		{"Tree", testRemoveAllTree},
+		{"NotExist", testRemoveAllNotExist},
+		{"Siblings", testRemoveAllSiblings},
+	}},
+	{"Rename", []check{
+		{"File", testRenameFile},
+		{"OverExisting", testRenameOverExisting},
+		{"NotExist", testRenameNotExist},
+		{"Directory", testRenameDirectory},
+	}},
+	{"Attributes", []check{
+		{"Chmod", testChmod},
+		{"Chtimes", testChtimes},
+		{"StatRoot", testStatRoot},
+	}},
+}
+
+// Run runs all checks of the suite as subtests of t, every check on a new
+// Fs returned by c.NewFs.
+func Run(t *testing.T, c Config) {
+	skip := make(map[string]bool)
+	for _, name := range c.Skip {
+		skip[name] = true
+	}
+	for _, g := range groups {
+		g := g
+		t.Run(g.name, func(t *testing.T) {
+			if skip[g.name] {
+				t.Skip("skipped by configuration")
+			}
+			for _, ch := range g.checks {
+				ch := ch
+				t.Run(ch.name, func(t *testing.T) {
+					if skip[g.name+"/"+ch.name] {
+						t.Skip("skipped by configuration")
+					}
+					defer func() {
+						if r := recover(); r != nil {
+							t.Fatalf("panic: %v", r)
+						}
+					}()
+					ch.fn(t, c.NewFs(t))
+				})
+			}
+		})
+	}
+}
+
+// helpers
+
+func writeFile(t *testing.T, fs afero.Fs, name, content string) {
+	t.Helper()
+	if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+}
+
+func readFile(t *testing.T, fs afero.Fs, name string) string {
+	t.Helper()
+	data, err := afero.ReadFile(fs, name)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func mkdirAll(t *testing.T, fs afero.Fs, name string) {
+	t.Helper()
+	if err := fs.MkdirAll(name, 0755); err != nil {
+		t.Fatalf("MkdirAll %s: %v", name, err)
+	}
+}
+
+func openFile(t *testing.T, fs afero.Fs, name string, flag int) afero.File {
+	t.Helper()
+	f, err := fs.OpenFile(name, flag, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile %s: %v", name, err)
+	}
+	return f
+}
+
+func exists(fs afero.Fs, name string) bool {
+	_, err := fs.Stat(name)
+	return err == nil
+}
+
+func expectPathError(t *testing.T, op string, err error, is func(error) bool) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("%s: expected an error", op)
+	}
+	switch err.(type) {
+	case *os.PathError, *os.LinkError, *os.SyscallError:
+	default:
+		t.Errorf("%s: expected *os.PathError, got %T: %v", op, err, err)
+	}
+	if is != nil && !is(err) {
+		t.Errorf("%s: unexpected error %v", op, err)
+	}
+}
+
+func names(fis []os.FileInfo) []string {
+	s := make([]string, len(fis))
+	for i, fi := range fis {
+		s[i] = fi.Name()
+	}
+	sort.Strings(s)
+	return s
+}
+
+// Create
+
+func testCreateReadBack(t *testing.T, fs afero.Fs) {
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("content"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, "/file"); got != "content" {
+		t.Errorf("got %q, want %q", got, "content")
+	}
+	fi, err := fs.Stat("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "file" || fi.Size() != 7 || !fi.Mode().IsRegular() {
+		t.Errorf("Stat: got name %q size %d mode %v", fi.Name(), fi.Size(), fi.Mode())
+	}
+}
+
+func testCreateTruncates(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "old content")
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("new")
+	f.Close()
+	if got := readFile(t, fs, "/file"); got != "new" {
+		t.Errorf("got %q, want %q", got, "new")
+	}
+}
+
+func testFileName(t *testing.T, fs afero.Fs) {
+	mkdirAll(t, fs, "/dir")
+	f, err := fs.Create("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Name() != "/dir/file" {
+		t.Errorf("got %q, want %q", f.Name(), "/dir/file")
+	}
+}
+
+// Open
+
+func testOpenNotExist(t *testing.T, fs afero.Fs) {
+	_, err := fs.Open("/missing")
+	expectPathError(t, "Open", err, os.IsNotExist)
+	_, err = fs.Stat("/missing")
+	expectPathError(t, "Stat", err, os.IsNotExist)
+}
+
+func testOpenDirectory(t *testing.T, fs afero.Fs) {
+	mkdirAll(t, fs, "/dir")
+	f, err := fs.Open("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Errorf("expected a directory, got mode %v", fi.Mode())
+	}
+}
+
+// OpenFile
+
+func testOpenFileNotExist(t *testing.T, fs afero.Fs) {
+	_, err := fs.OpenFile("/missing", os.O_RDWR, 0644)
+	expectPathError(t, "OpenFile", err, os.IsNotExist)
+}
+
+func testOpenFileExcl(t *testing.T, fs afero.Fs) {
+	f := openFile(t, fs, "/file", os.O_RDWR|os.O_CREATE|os.O_EXCL)
+	f.Close()
+	_, err := fs.OpenFile("/file", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	expectPathError(t, "OpenFile with O_EXCL", err, os.IsExist)
+}
+
+func testOpenFileTrunc(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "content")
+	f := openFile(t, fs, "/file", os.O_WRONLY|os.O_TRUNC)
+	f.Close()
+	if got := readFile(t, fs, "/file"); got != "" {
+		t.Errorf("got %q, expected an empty file", got)
+	}
+}
+
+func testOpenFileAppend(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "content")
+	f := openFile(t, fs, "/file", os.O_WRONLY|os.O_APPEND)
+	if _, err := f.WriteString(" appended"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := readFile(t, fs, "/file"); got != "content appended" {
+		t.Errorf("got %q, want %q", got, "content appended")
+	}
+}
+
+func testOpenFileReadOnly(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "content")
+	f := openFile(t, fs, "/file", os.O_RDONLY)
+	defer f.Close()
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("Write to a read only file succeeded")
+	}
+	if got := readFile(t, fs, "/file"); got != "content" {
+		t.Errorf("got %q, want %q", got, "content")
+	}
+}
+
+func testOpenFileWriteOnly(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "content")
+	f := openFile(t, fs, "/file", os.O_WRONLY)
+	defer f.Close()
+	if _, err := f.Read(make([]byte, 1)); err == nil || err == io.EOF {
+		t.Errorf("Read from a write only file: expected an error, got %v", err)
+	}
+}
+
+func testOpenFilePerm(t *testing.T, fs afero.Fs) {
+	f, err := fs.OpenFile("/file", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	fi, err := fs.Stat("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+}
+
+// Offset
+
+func testSeek(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "0123456789")
+	f := openFile(t, fs, "/file", os.O_RDONLY)
+	defer f.Close()
+
+	for _, s := range []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{2, io.SeekStart, 2},
+		{3, io.SeekCurrent, 5},
+		{-1, io.SeekCurrent, 4},
+		{-3, io.SeekEnd, 7},
+	} {
+		pos, err := f.Seek(s.offset, s.whence)
+		if err != nil {
+			t.Fatalf("Seek(%d, %d): %v", s.offset, s.whence, err)
+		}
+		if pos != s.want {
+			t.Errorf("Seek(%d, %d): got %d, want %d", s.offset, s.whence, pos, s.want)
+		}
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "78" {
+		t.Errorf("read after seek: got %q, %v", buf, err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seeking to a negative offset succeeded")
+	}
+}
+
+func testSeekPastEnd(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "abc")
+	f := openFile(t, fs, "/file", os.O_RDWR)
+	if _, err := f.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("read past the end: got %d, %v", n, err)
+	}
+	if _, err := f.WriteString("x"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := readFile(t, fs, "/file"); got != "abc\x00\x00x" {
+		t.Errorf("got %q, want %q", got, "abc\x00\x00x")
+	}
+}
+
+func testWriteAdvances(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "0123456789")
+	f := openFile(t, fs, "/file", os.O_RDWR)
+	if _, err := f.WriteString("ab"); err != nil {
+		t.Fatal(err)
+	}
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 2 {
+		t.Errorf("offset after writing 2 bytes: got %d", pos)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "23" {
+		t.Errorf("read after write: got %q, %v", buf, err)
+	}
+	f.Close()
+	if got := readFile(t, fs, "/file"); got != "ab23456789" {
+		t.Errorf("got %q, want %q", got, "ab23456789")
+	}
+}
+
+func testReadAt(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "0123456789")
+	f := openFile(t, fs, "/file", os.O_RDONLY)
+	defer f.Close()
+
+	buf := make([]byte, 3)
+	if n, err := f.ReadAt(buf, 4); n != 3 || err != nil || string(buf) != "456" {
+		t.Errorf("ReadAt: got %d %q, %v", n, buf, err)
+	}
+	if n, err := f.ReadAt(buf, 8); n != 2 || err != io.EOF {
+		t.Errorf("short ReadAt: got %d, %v, want 2, io.EOF", n, err)
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("ReadAt changed the offset to %d", pos)
+	}
+	if _, err := f.ReadAt(buf, -1); err == nil {
+		t.Error("ReadAt at a negative offset succeeded")
+	}
+}
+
+func testWriteAt(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "0123456789")
+	f := openFile(t, fs, "/file", os.O_RDWR)
+	if n, err := f.WriteAt([]byte("ab"), 3); n != 2 || err != nil {
+		t.Errorf("WriteAt: got %d, %v", n, err)
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("WriteAt changed the offset to %d", pos)
+	}
+	if _, err := f.WriteAt([]byte("z"), 12); err != nil {
+		t.Errorf("WriteAt past the end: %v", err)
+	}
+	f.Close()
+	if got := readFile(t, fs, "/file"); got != "012ab56789\x00\x00z" {
+		t.Errorf("got %q, want %q", got, "012ab56789\x00\x00z")
+	}
+}
+
+// Truncate
+
+func testTruncateShrink(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "0123456789")
+	f := openFile(t, fs, "/file", os.O_RDWR)
+	if err := f.Truncate(4); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 4 {
+		t.Errorf("size after Truncate: got %d, want 4", fi.Size())
+	}
+	f.Close()
+	if got := readFile(t, fs, "/file"); got != "0123" {
+		t.Errorf("got %q, want %q", got, "0123")
+	}
+}
+
+func testTruncateGrow(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "ab")
+	f := openFile(t, fs, "/file", os.O_RDWR)
+	if err := f.Truncate(4); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if got := readFile(t, fs, "/file"); got != "ab\x00\x00" {
+		t.Errorf("got %q, want %q", got, "ab\x00\x00")
+	}
+	if err := f.Truncate(1); err == nil {
+		t.Error("Truncate of a closed file succeeded")
+	}
+}
+
+// Readdir
+
+func setupDir(t *testing.T, fs afero.Fs) []string {
+	mkdirAll(t, fs, "/dir/sub")
+	want := []string{"a", "b", "c", "d", "e", "sub"}
+	for _, name := range want[:5] {
+		writeFile(t, fs, "/dir/"+name, name)
+	}
+	writeFile(t, fs, "/dir/sub/nested", "")
+	return want
+}
+
+func testReaddirAll(t *testing.T, fs afero.Fs) {
+	want := setupDir(t, fs)
+	f := openFile(t, fs, "/dir", os.O_RDONLY)
+	defer f.Close()
+	fis, err := f.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(fis); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, fi := range fis {
+		if fi.IsDir() != (fi.Name() == "sub") {
+			t.Errorf("%s: unexpected mode %v", fi.Name(), fi.Mode())
+		}
+	}
+	if fis, err := f.Readdir(-1); err != nil || len(fis) != 0 {
+		t.Errorf("second Readdir(-1): got %d entries, %v", len(fis), err)
+	}
+}
+
+func testReaddirPaginated(t *testing.T, fs afero.Fs) {
+	want := setupDir(t, fs)
+	f := openFile(t, fs, "/dir", os.O_RDONLY)
+	defer f.Close()
+	var all []os.FileInfo
+	for i := 0; ; i++ {
+		fis, err := f.Readdir(4)
+		if err == io.EOF {
+			if len(fis) != 0 {
+				t.Errorf("got %d entries with io.EOF", len(fis))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fis) == 0 || len(fis) > 4 {
+			t.Fatalf("Readdir(4) returned %d entries", len(fis))
+		}
+		if i > len(want) {
+			t.Fatal("Readdir never returned io.EOF")
+		}
+		all = append(all, fis...)
+	}
+	if got := names(all); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func testReaddirnames(t *testing.T, fs afero.Fs) {
+	want := setupDir(t, fs)
+	f := openFile(t, fs, "/dir", os.O_RDONLY)
+	defer f.Close()
+	got, err := f.Readdirnames(2)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Readdirnames(2): got %v, %v", got, err)
+	}
+	rest, err := f.Readdirnames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, rest...)
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func testReaddirNotDir(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "content")
+	f := openFile(t, fs, "/file", os.O_RDONLY)
+	defer f.Close()
+	if _, err := f.Readdir(-1); err == nil {
+		t.Error("Readdir of a file succeeded")
+	}
+}
+
+// Mkdir
+
+func testMkdirExists(t *testing.T, fs afero.Fs) {
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	expectPathError(t, "Mkdir", fs.Mkdir("/dir", 0755), os.IsExist)
+	writeFile(t, fs, "/file", "")
+	expectPathError(t, "Mkdir over a file", fs.Mkdir("/file", 0755), os.IsExist)
+}
+
+func testMkdirNoParent(t *testing.T, fs afero.Fs) {
+	expectPathError(t, "Mkdir", fs.Mkdir("/missing/dir", 0755), os.IsNotExist)
+}
+
+func testMkdirAll(t *testing.T, fs afero.Fs) {
+	mkdirAll(t, fs, "/a/b/c")
+	mkdirAll(t, fs, "/a/b/c")
+	for _, name := range []string{"/a", "/a/b", "/a/b/c"} {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.IsDir() {
+			t.Errorf("%s: expected a directory, got mode %v", name, fi.Mode())
+		}
+	}
+}
+
+func testMkdirAllOverFile(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "")
+	if err := fs.MkdirAll("/file/dir", 0755); err == nil {
+		t.Error("MkdirAll below a file succeeded")
+	}
+	if err := fs.MkdirAll("/file", 0755); err == nil {
+		t.Error("MkdirAll over a file succeeded")
+	}
+}
+
+// Remove
+
+func testRemoveFile(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "")
+	if err := fs.Remove("/file"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(fs, "/file") {
+		t.Error("file still exists")
+	}
+}
+
+func testRemoveNotExist(t *testing.T, fs afero.Fs) {
+	expectPathError(t, "Remove", fs.Remove("/missing"), os.IsNotExist)
+}
+
+func testRemoveNotEmpty(t *testing.T, fs afero.Fs) {
+	mkdirAll(t, fs, "/dir")
+	writeFile(t, fs, "/dir/file", "")
+	expectPathError(t, "Remove", fs.Remove("/dir"), nil)
+	if !exists(fs, "/dir/file") {
+		t.Error("Remove of a non-empty directory removed its content")
+	}
+}
+
+func testRemoveEmptyDir(t *testing.T, fs afero.Fs) {
+	mkdirAll(t, fs, "/dir")
+	if err := fs.Remove("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(fs, "/dir") {
+		t.Error("directory still exists")
+	}
+}
+
+// RemoveAll
+
+func testRemoveAllTree(t *testing.T, fs afero.Fs) {
+	setupDir(t, fs)
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/dir", "/dir/a", "/dir/sub/nested"} {
+		if exists(fs, name) {
+			t.Errorf("%s still exists", name)
+		}
+	}
+}
+
+func testRemoveAllNotExist(t *testing.T, fs afero.Fs) {
+	if err := fs.RemoveAll("/missing"); err != nil {
+		t.Errorf("RemoveAll of a missing path: %v", err)
+	}
+}
+
+func testRemoveAllSiblings(t *testing.T, fs afero.Fs) {
+	mkdirAll(t, fs, "/a")
+	writeFile(t, fs, "/a/file", "")
+	writeFile(t, fs, "/ab", "")
+	mkdirAll(t, fs, "/a.d")
+	if err := fs.RemoveAll("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(fs, "/ab") || !exists(fs, "/a.d") {
+		t.Error("RemoveAll removed a sibling sharing the name as prefix")
+	}
+}
+
+// Rename
+
+func testRenameFile(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/old", "content")
+	if err := fs.Rename("/old", "/new"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(fs, "/old") {
+		t.Error("old name still exists")
+	}
+	if got := readFile(t, fs, "/new"); got != "content" {
+		t.Errorf("got %q, want %q", got, "content")
+	}
+}
+
+func testRenameOverExisting(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/old", "new content")
+	writeFile(t, fs, "/new", "old content")
+	if err := fs.Rename("/old", "/new"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, "/new"); got != "new content" {
+		t.Errorf("got %q, want %q", got, "new content")
+	}
+}
+
+func testRenameNotExist(t *testing.T, fs afero.Fs) {
+	expectPathError(t, "Rename", fs.Rename("/missing", "/new"), os.IsNotExist)
+}
+
+func testRenameDirectory(t *testing.T, fs afero.Fs) {
+	setupDir(t, fs)
+	if err := fs.Rename("/dir", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(fs, "/dir") || exists(fs, "/dir/a") {
+		t.Error("old directory still exists")
+	}
+	if got := readFile(t, fs, "/moved/a"); got != "a" {
+		t.Errorf("got %q, want %q", got, "a")
+	}
+	if !exists(fs, "/moved/sub/nested") {
+		t.Error("nested file was not moved")
+	}
+}
+
+// Attributes
+
+func testChmod(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "")
+	if err := fs.Chmod("/file", 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("got mode %v, want %v", fi.Mode(), os.FileMode(0600))
+	}
+	expectPathError(t, "Chmod", fs.Chmod("/missing", 0600), os.IsNotExist)
+}
+
+func testChtimes(t *testing.T, fs afero.Fs) {
+	writeFile(t, fs, "/file", "")
+	mtime := time.Date(2015, 10, 21, 16, 29, 0, 0, time.UTC)
+	if err := fs.Chtimes("/file", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("got mtime %v, want %v", fi.ModTime(), mtime)
+	}
+	expectPathError(t, "Chtimes", fs.Chtimes("/missing", mtime, mtime), os.IsNotExist)
+}
+
+func testStatRoot(t *testing.T, fs afero.Fs) {
+	fi, err := fs.Stat("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Errorf("expected a directory, got mode %v", fi.Mode())
+	}
+}
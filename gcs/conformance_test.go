@@ -0,0 +1,122 @@
+package gcs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/fstest"
+)
+
+// gcsSkip are the known deviations of the gcs Fs from the OS.
+var gcsSkip = []string{
+	// the test strips the leading slash, object names do not have one
+	"Create/Name",
+	// a missing object is opened as an empty folder
+	"Open/NotExist",
+	// OpenFile creates objects without checking for an existing one
+	"OpenFile/Excl",
+	// handles on existing objects which are not truncated are read-write
+	// copies in memory
+	"OpenFile/WriteOnly",
+	// the permissions given to OpenFile are not stored, only Chmod sets them
+	"OpenFile/Perm",
+	// Readdir of an object handle returns no entries instead of an error
+	"Readdir/NotDir",
+	// folders only exist as prefixes of object names, Mkdir and MkdirAll
+	// are no-ops
+	"Mkdir/Exists",
+	"Mkdir/NoParent",
+	"Mkdir/All",
+	"Mkdir/AllOverFile",
+	// the error of the storage client is returned, not a *os.PathError
+	"Remove/NotExist",
+	// a folder is no object which Remove could delete or refuse to delete
+	"Remove/NotEmpty",
+	"Remove/EmptyDir",
+	// the empty folder /a.d is not created, so it does not survive
+	"RemoveAll/Siblings",
+	// the bucket itself cannot be stat'ed
+	"Attributes/StatRoot",
+}
+
+// objectNames strips the leading slash of the absolute names used by the
+// conformance checks, the fake server rejects object names with an empty
+// segment.
+type objectNames struct {
+	afero.Fs
+}
+
+func objectName(name string) string {
+	return strings.TrimPrefix(name, "/")
+}
+
+func (o objectNames) Create(name string) (afero.File, error) {
+	return o.Fs.Create(objectName(name))
+}
+
+func (o objectNames) Mkdir(name string, perm os.FileMode) error {
+	return o.Fs.Mkdir(objectName(name), perm)
+}
+
+func (o objectNames) MkdirAll(path string, perm os.FileMode) error {
+	return o.Fs.MkdirAll(objectName(path), perm)
+}
+
+func (o objectNames) Open(name string) (afero.File, error) {
+	return o.Fs.Open(objectName(name))
+}
+
+func (o objectNames) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return o.Fs.OpenFile(objectName(name), flag, perm)
+}
+
+func (o objectNames) Remove(name string) error {
+	return o.Fs.Remove(objectName(name))
+}
+
+func (o objectNames) RemoveAll(path string) error {
+	return o.Fs.RemoveAll(objectName(path))
+}
+
+func (o objectNames) Rename(oldname, newname string) error {
+	return o.Fs.Rename(objectName(oldname), objectName(newname))
+}
+
+func (o objectNames) Stat(name string) (os.FileInfo, error) {
+	return o.Fs.Stat(objectName(name))
+}
+
+func (o objectNames) Chmod(name string, mode os.FileMode) error {
+	return o.Fs.Chmod(objectName(name), mode)
+}
+
+func (o objectNames) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return o.Fs.Chtimes(objectName(name), atime, mtime)
+}
+
+var conformanceBuckets int32
+
+func TestConformance(t *testing.T) {
+	if fakeServer == nil {
+		t.Skip("the conformance checks need an empty bucket for every check")
+	}
+	fstest.Run(t, fstest.Config{
+		NewFs: func(t *testing.T) afero.Fs {
+			bucket := fmt.Sprintf("conformance-%d", atomic.AddInt32(&conformanceBuckets, 1))
+			if err := fakeServer.CreateBucket(bucket); err != nil {
+				t.Fatal(err)
+			}
+			fs, err := New("afero", bucket, WithEndpoint(fakeServer.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return objectNames{fs}
+		},
+		Skip: gcsSkip,
+	})
+}
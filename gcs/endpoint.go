@@ -0,0 +1,27 @@
+package gcs
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// endpointTransport sends all requests to another server. The client sends
+// requests to different hosts, e.g. the JSON API to www.googleapis.com and
+// downloads to storage.googleapis.com, so only replacing the base URL of the
+// JSON API is not enough.
+type endpointTransport struct {
+	endpoint *url.URL
+	base     http.RoundTripper
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	u := new(url.URL)
+	*u = *req.URL
+	u.Scheme = t.endpoint.Scheme
+	u.Host = t.endpoint.Host
+	r.URL = u
+	r.Host = ""
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,228 @@
+package gcs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+var (
+	ErrReadOnly      = errors.New("file handle is read only")
+	ErrWriteOnly     = errors.New("file handle is write only")
+	ErrNotSequential = errors.New("objects can only be written sequentially")
+)
+
+// readableFile streams the content of an object. Read continues a single
+// download, after a Seek the download is restarted at the new offset with a
+// range request. ReadAt issues a range request of its own and does not
+// affect the offset.
+type readableFile struct {
+	unfile
+	g      gcs
+	name   string
+	attrs  *storage.ObjectAttrs
+	mu     sync.Mutex
+	offset int64
+	r      *storage.Reader
+	closed bool
+}
+
+func (f *readableFile) Name() string {
+	return f.name
+}
+
+func (f *readableFile) Stat() (os.FileInfo, error) {
+	return GcsFileInfo{attrs: f.attrs}, nil
+}
+
+func (f *readableFile) Read(p []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrInvalid
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.offset >= f.attrs.Size {
+		return 0, io.EOF
+	}
+	if f.r == nil {
+		if f.r, err = f.g.bucket.Object(f.name).NewRangeReader(f.g.ctx, f.offset, -1); err != nil {
+			return 0, err
+		}
+	}
+	n, err = f.r.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *readableFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: os.ErrInvalid}
+	}
+	if off >= f.attrs.Size {
+		return 0, io.EOF
+	}
+	length := int64(len(p))
+	if off+length > f.attrs.Size {
+		length = f.attrs.Size - off
+	}
+	r, err := f.g.bucket.Object(f.name).NewRangeReader(f.g.ctx, off, length)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	n, err = io.ReadFull(r, p[:length])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *readableFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrInvalid
+	}
+	switch whence {
+	case os.SEEK_SET:
+	case os.SEEK_CUR:
+		offset += f.offset
+	case os.SEEK_END:
+		offset += f.attrs.Size
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	if offset != f.offset && f.r != nil {
+		// restart the download at the new offset on the next Read
+		f.r.Close()
+		f.r = nil
+	}
+	f.offset = offset
+	return f.offset, nil
+}
+
+func (f *readableFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrInvalid
+	}
+	f.closed = true
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return nil
+}
+
+func (f *readableFile) Write(p []byte) (n int, err error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: ErrReadOnly}
+}
+
+func (f *readableFile) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, &os.PathError{Op: "writeat", Path: f.name, Err: ErrReadOnly}
+}
+
+func (f *readableFile) WriteString(s string) (n int, err error) {
+	return f.Write([]byte(s))
+}
+
+func (f *readableFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: ErrReadOnly}
+}
+
+// streamingFile uploads everything written to it directly through a
+// storage.Writer, which sends the data to GCS as a resumable upload in chunks
+// of the configured chunk size. Objects are immutable, so the handle is write
+// only and can only be written sequentially. The object is created on Close.
+type streamingFile struct {
+	unfile
+	name   string
+	wc     *storage.Writer
+	mu     sync.Mutex
+	offset int64
+	closed bool
+}
+
+func (f *streamingFile) Name() string {
+	return f.name
+}
+
+func (f *streamingFile) Stat() (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return GcsFileInfo{attrs: &storage.ObjectAttrs{Name: f.name, Size: f.offset}}, nil
+}
+
+func (f *streamingFile) Write(p []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrInvalid
+	}
+	n, err = f.wc.Write(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *streamingFile) WriteAt(p []byte, off int64) (n int, err error) {
+	f.mu.Lock()
+	offset := f.offset
+	f.mu.Unlock()
+	if off != offset {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: ErrNotSequential}
+	}
+	return f.Write(p)
+}
+
+func (f *streamingFile) WriteString(s string) (n int, err error) {
+	return f.Write([]byte(s))
+}
+
+// Seek only allows to query the current offset, which is also the end of
+// the file.
+func (f *streamingFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrInvalid
+	}
+	switch whence {
+	case os.SEEK_SET:
+	case os.SEEK_CUR, os.SEEK_END:
+		offset += f.offset
+	}
+	if offset != f.offset {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: ErrNotSequential}
+	}
+	return f.offset, nil
+}
+
+func (f *streamingFile) Read(p []byte) (n int, err error) {
+	return 0, &os.PathError{Op: "read", Path: f.name, Err: ErrWriteOnly}
+}
+
+func (f *streamingFile) ReadAt(p []byte, off int64) (n int, err error) {
+	return 0, &os.PathError{Op: "readat", Path: f.name, Err: ErrWriteOnly}
+}
+
+func (f *streamingFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: ErrNotSequential}
+}
+
+// Close finishes the upload, only now the object becomes visible.
+func (f *streamingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrInvalid
+	}
+	f.closed = true
+	return f.wc.Close()
+}
@@ -1,18 +1,24 @@
 package gcs
 
 import (
+	"context"
+	"errors"
 	"io"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/net/context"
-
 	"github.com/spf13/afero"
 	"github.com/spf13/afero/mem"
 
-	"google.golang.org/cloud"
-	"google.golang.org/cloud/storage"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
 type storer interface {
@@ -20,25 +26,73 @@ type storer interface {
 	Create(name string)
 }
 
+// DefaultChunkSize is the size of the chunks in which objects are uploaded.
+// Each chunk is sent as one request of a resumable upload, so an interrupted
+// chunk can be retried without sending the whole object again.
+const DefaultChunkSize = 8 * 1024 * 1024
+
 type gcs struct {
-	ctx      context.Context
-	client   *storage.Client
-	bucket   *storage.BucketHandle
-	database storer
+	ctx       context.Context
+	client    *storage.Client
+	bucket    *storage.BucketHandle
+	database  storer
+	chunkSize int
+}
+
+// Option configures the Fs returned by New.
+type Option func(*options)
+
+type options struct {
+	endpoint  string
+	chunkSize int
+}
+
+// WithEndpoint sends all requests to the server at endpoint instead of
+// Google Cloud Storage, e.g. to the URL of a gcstest.Server. No credentials
+// are sent to such a server.
+func WithEndpoint(endpoint string) Option {
+	return func(o *options) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithChunkSize sets the size of the chunks of uploads, see
+// DefaultChunkSize. With a size of 0 every object is uploaded with a single
+// request, which cannot be resumed.
+func WithChunkSize(size int) Option {
+	return func(o *options) {
+		o.chunkSize = size
+	}
 }
 
-func New(project, bucket string) (*gcs, error) {
+func New(project, bucket string, opts ...Option) (*gcs, error) {
+	o := options{chunkSize: DefaultChunkSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	var err error
 	var scope = storage.ScopeFullControl
 	ctx := context.Background()
+	clientOpt := option.WithScopes(scope)
+	if o.endpoint != "" {
+		var endpoint *url.URL
+		if endpoint, err = url.Parse(o.endpoint); err != nil {
+			return nil, err
+		}
+		clientOpt = option.WithHTTPClient(&http.Client{
+			Transport: &endpointTransport{endpoint: endpoint, base: http.DefaultTransport},
+		})
+	}
 	var client *storage.Client
-	if client, err = storage.NewClient(ctx, cloud.WithScopes(scope)); err != nil {
+	if client, err = storage.NewClient(ctx, clientOpt); err != nil {
 		return nil, err
 	}
 	return &gcs{
-		ctx:    ctx,
-		client: client,
-		bucket: client.Bucket(bucket),
+		ctx:       ctx,
+		client:    client,
+		bucket:    client.Bucket(bucket),
+		chunkSize: o.chunkSize,
 	}, nil
 }
 
@@ -74,75 +128,69 @@ func (c fakeContext) Err() (err error) { return }
 // functions.
 func (c fakeContext) Value(key interface{}) interface{} { return nil }
 
+// writeableFile buffers the content in memory, for handles opened read-write
+// or for appending which need the existing content of the object. The object
+// is uploaded on Close.
 type writeableFile struct {
 	*mem.File
 	wc *storage.Writer
-	//unfile
 }
 
 func (w writeableFile) Close() (err error) {
-	defer func() {
-		if err = w.wc.Close(); err != nil {
-			log.Println(err)
-		}
-	}()
 	if err = w.File.Close(); err != nil {
+		w.wc.CloseWithError(err)
 		return err
 	}
 	if err = w.File.Open(); err != nil {
+		w.wc.CloseWithError(err)
 		return err
 	}
 	defer w.File.Close()
 	if _, err = io.Copy(w.wc, w.File); err != nil {
+		w.wc.CloseWithError(err)
 		return err
 	}
+	if err = w.wc.Close(); err != nil {
+		log.Println(err)
+	}
 	return err
 }
 
+// folder is a prefix of object names. Its entries are listed by the first
+// Readdir, later calls continue with the following entries.
 type folder struct {
 	name string
 	g    gcs
 	unfile
-	cursor *string
+	entries []os.FileInfo
+	offset  int
 }
 
-func (f folder) Stat() (os.FileInfo, error) {
+func (f *folder) Stat() (os.FileInfo, error) {
 	// return empty objectAttrs
 	return GcsFileInfo{isFolder: true, attrs: new(storage.ObjectAttrs)}, nil
 }
 
-func (f folder) Readdir(count int) (info []os.FileInfo, err error) {
-	// 	Readdir reads the contents of the directory associated with file and returns a slice of up to n FileInfo values, as would be returned by Lstat, in directory order. Subsequent calls on the same file will yield further FileInfos.
-	// If n > 0, Readdir returns at most n FileInfo structures. In this case, if Readdir returns an empty slice, it will return a non-nil error explaining why. At the end of a directory, the error is io.EOF.
-	// If n <= 0, Readdir returns all the FileInfo from the directory in a single slice. In this case, if Readdir succeeds (reads all the way to the end of the directory), it returns the slice and a nil error. If it encounters an error before the end of the directory, Readdir returns the FileInfo read until that point and a non-nil error.
-
-	q := &storage.Query{
-		Prefix:     f.name,
-		MaxResults: count,
-		Versions:   false,
-	}
-	if f.cursor != nil {
-		q.Cursor = *f.cursor
-	}
-	var objectList *storage.ObjectList
-	if objectList, err = f.g.bucket.List(f.g.ctx, q); err != nil {
-		// fmt.Println("readdir errror", err)
-		return nil, os.ErrNotExist
-	}
-	// will allow for multiple readDirs
-	if objectList.Next != nil {
-		f.cursor = &objectList.Next.Cursor
+func (f *folder) Readdir(count int) (info []os.FileInfo, err error) {
+	if f.entries == nil {
+		if f.entries, err = f.g.readdir(f.name); err != nil {
+			return nil, err
+		}
 	}
-	infos := make([]GcsFileInfo, len(objectList.Results))
-	for i, obj := range objectList.Results {
-		// fmt.Println("RESULTS OBJ", obj.Name, obj.ContentType, obj.StorageClass)
-		infos[i] = GcsFileInfo{false, obj}
+	info = f.entries[f.offset:]
+	if count > 0 {
+		if len(info) == 0 {
+			return nil, io.EOF
+		}
+		if len(info) > count {
+			info = info[:count]
+		}
 	}
-	// fmt.Println("PREFIXS", objectList.Prefixes)
-	return
+	f.offset += len(info)
+	return info, nil
 }
 
-func (f folder) Readdirnames(n int) (s []string, err error) {
+func (f *folder) Readdirnames(n int) (s []string, err error) {
 	infos, err := f.Readdir(n)
 	if err != nil {
 		return s, err
@@ -154,77 +202,101 @@ func (f folder) Readdirnames(n int) (s []string, err error) {
 	return s, nil
 }
 
-type readableFile struct {
-	*mem.File
-	attrs *storage.ObjectAttrs
-	//r     *storage.Reader
-	//unfile
-	//unfile
-}
-
-func (r readableFile) Stat() (os.FileInfo, error) {
-	return GcsFileInfo{attrs: r.attrs}, nil
+// readdir lists the objects and folders directly below the folder name, in
+// name order.
+func (g gcs) readdir(name string) ([]os.FileInfo, error) {
+	prefix := folderPrefix(name)
+	if prefix == "/" {
+		prefix = ""
+	}
+	infos := make([]os.FileInfo, 0)
+	it := g.bucket.Objects(g.ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return infos, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Prefix != "" {
+			infos = append(infos, GcsFileInfo{isFolder: true, attrs: &storage.ObjectAttrs{Name: path.Base(attrs.Prefix)}})
+			continue
+		}
+		entry := *attrs
+		entry.Name = path.Base(attrs.Name)
+		infos = append(infos, GcsFileInfo{attrs: &entry})
+	}
 }
 
-func (g gcs) createFile(filename string) (f afero.File, err error) {
-	//ctx, _, err := aetest.NewContext()
-	//ctx := context.Background()
-	//if err != nil {
-	//	return f, err
-	//}
+func (g gcs) newWriter(filename string) *storage.Writer {
 	wc := g.bucket.Object(filename).NewWriter(g.ctx)
 	//	Attributes can be set on the object by modifying the returned Writer's
 	//	ObjectAttrs field before the first call to Write. If no ContentType
 	//	attribute is specified, the content type will be automatically sniffed using
 	//	net/http.DetectContentType
 	//wc.ContentType = ""
+	wc.ChunkSize = g.chunkSize
+	return wc
+}
 
-	// we return an in-memory file so that it has seek methods
-	memdata, err := mem.CreateFile(filename), nil
-	if err != nil {
-		return f, err
+func (g gcs) createFile(filename string) (f afero.File, err error) {
+	return &streamingFile{
+		name: filename,
+		wc:   g.newWriter(filename),
+	}, nil
+}
+
+// openBuffered returns a read-write handle on an in-memory copy of the
+// object, which is uploaded again on Close.
+func (g gcs) openBuffered(filename string, flag int) (f afero.File, err error) {
+	memdata := mem.CreateFile(filename)
+	memfile := mem.NewFileHandle(memdata)
+	if flag&os.O_TRUNC == 0 {
+		r, err := g.bucket.Object(filename).NewReader(g.ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			if flag&os.O_CREATE == 0 {
+				return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+			}
+		} else if err != nil {
+			return nil, err
+		} else {
+			_, err = io.Copy(memfile, r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if flag&os.O_APPEND == 0 {
+		if _, err = memfile.Seek(0, os.SEEK_SET); err != nil {
+			return nil, err
+		}
 	}
 	return writeableFile{
-		File: mem.NewFileHandle(memdata),
-		wc:   wc,
+		File: memfile,
+		wc:   g.newWriter(filename),
 	}, nil
 }
 
 func (g gcs) openFolder(path string) (f afero.File, err error) {
-	return folder{name: path, g: g}, nil
+	return &folder{name: path, g: g}, nil
 }
 
+// open returns a handle which streams the object, nothing is downloaded
+// before the first Read.
 func (g gcs) open(filename string) (f afero.File, err error) {
-	var r *storage.Reader
-	//ctx, _, err := aetest.NewContext()
-	//ctx := context.Background()
-	if r, err = g.bucket.Object(filename).NewReader(g.ctx); err == storage.ErrObjectNotExist {
+	var attrs *storage.ObjectAttrs
+	if attrs, err = g.bucket.Object(filename).Attrs(g.ctx); errors.Is(err, storage.ErrObjectNotExist) {
 		// if the file doesn't exist, then try returning it as a folder path
 		return g.openFolder(filename)
 	} else if err != nil {
 		return f, err
 	}
-	defer r.Close()
-	var attrs *storage.ObjectAttrs
-	if attrs, err = g.bucket.Object(filename).Attrs(g.ctx); err != nil {
-		return f, err
-	}
-	memdata, err := mem.CreateFile(filename), nil
-	if err != nil {
-		return f, err
-	}
-	memfile := mem.NewFileHandle(memdata)
-	//memfile.Open()
-	//var n int64
-	if _, err = io.Copy(memfile, r); err != nil {
-		return f, err
-	}
-	// have it open for reading
-	memfile.Open()
-	return readableFile{
-		File:  memfile,
+	return &readableFile{
+		g:     g,
+		name:  filename,
 		attrs: attrs,
-		//r:     r,
 	}, nil
 }
 
@@ -252,35 +324,24 @@ func (g gcs) Open(name string) (f afero.File, err error) {
 }
 
 // OpenFile opens a file using the given flags and the given mode.
+//
+// Read only handles stream the object. Write only handles stream a new
+// object, if there is no existing content to keep (O_TRUNC or a new object).
+// All other handles work on an in-memory copy of the object, which is
+// uploaded on Close.
 func (g gcs) OpenFile(name string, flag int, perm os.FileMode) (f afero.File, err error) {
-	//file, err := m.openWrite(name)
-	//if os.IsNotExist(err) && (flag&os.O_CREATE > 0) {
-	//	file, err = m.Create(name)
-	//}
-	//if err != nil {
-	//	return nil, err
-	//}
-	if flag == os.O_RDONLY {
-		//file = mem.NewReadOnlyFileHandle(file.(*mem.File).Data())
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
 		return g.open(name)
 	}
-	if flag&os.O_APPEND > 0 {
-		//_, err = file.Seek(0, os.SEEK_END)
-		//if err != nil {
-		//	file.Close()
-		//	return nil, err
-		//}
-	}
-	if flag&(os.O_CREATE|os.O_WRONLY) > 0 {
-		//err = file.Truncate(0)
-		//if err != nil {
-		//	file.Close()
-		//	return nil, err
-		//}
-		return g.createFile(name)
-	}
-	// else just return readable
-	return g.createFile(name)
+	if flag&os.O_WRONLY != 0 && flag&os.O_APPEND == 0 {
+		if flag&os.O_TRUNC != 0 {
+			return g.createFile(name)
+		}
+		if _, err := g.Stat(name); os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+			return g.createFile(name)
+		}
+	}
+	return g.openBuffered(name, flag)
 }
 
 // Remove removes a file identified by name, returning an error, if any
@@ -292,12 +353,124 @@ func (g gcs) Remove(name string) (err error) {
 // RemoveAll removes a directory path and all any children it contains. It
 // does not fail if the path does not exist (return nil).
 func (g gcs) RemoveAll(path string) (err error) {
-	return
+	var names []string
+	if _, err = g.bucket.Object(path).Attrs(g.ctx); err == nil {
+		names = append(names, path)
+	} else if !errors.Is(err, storage.ErrObjectNotExist) {
+		return err
+	}
+	err = g.list(folderPrefix(path), func(attrs *storage.ObjectAttrs) error {
+		names = append(names, attrs.Name)
+		if len(names) >= deleteBatchSize {
+			err := g.deleteObjects(names)
+			names = names[:0]
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return g.deleteObjects(names)
 }
 
-// Rename renames a file.
+// Rename renames a file. As objects cannot be renamed, they are copied on
+// the server and the originals are deleted afterwards. Renaming a folder
+// moves every object below its prefix, this is not atomic.
 func (g gcs) Rename(oldname, newname string) (err error) {
-	return
+	if _, err = g.bucket.Object(oldname).Attrs(g.ctx); err == nil {
+		if err = g.copyObject(oldname, newname); err != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+		}
+		return g.deleteObjects([]string{oldname})
+	} else if !errors.Is(err, storage.ErrObjectNotExist) {
+		return err
+	}
+
+	oldprefix, newprefix := folderPrefix(oldname), folderPrefix(newname)
+	if strings.HasPrefix(newprefix, oldprefix) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrInvalid}
+	}
+	var names []string
+	err = g.list(oldprefix, func(attrs *storage.ObjectAttrs) error {
+		names = append(names, attrs.Name)
+		return g.copyObject(attrs.Name, newprefix+strings.TrimPrefix(attrs.Name, oldprefix))
+	})
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	if len(names) == 0 {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	for len(names) > 0 {
+		n := len(names)
+		if n > deleteBatchSize {
+			n = deleteBatchSize
+		}
+		if err = g.deleteObjects(names[:n]); err != nil {
+			return err
+		}
+		names = names[n:]
+	}
+	return nil
+}
+
+// deleteBatchSize is the number of objects deleted concurrently.
+const deleteBatchSize = 100
+
+// folderPrefix returns the prefix of all objects in the folder name.
+func folderPrefix(name string) string {
+	return strings.TrimSuffix(name, "/") + "/"
+}
+
+// list calls fn for every object whose name starts with prefix.
+func (g gcs) list(prefix string, fn func(*storage.ObjectAttrs) error) error {
+	it := g.bucket.Objects(g.ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(attrs); err != nil {
+			return err
+		}
+	}
+}
+
+// isFolder reports whether there are objects below the prefix of name.
+func (g gcs) isFolder(name string) (bool, error) {
+	_, err := g.bucket.Objects(g.ctx, &storage.Query{Prefix: folderPrefix(name)}).Next()
+	if err == iterator.Done {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (g gcs) copyObject(src, dst string) error {
+	_, err := g.bucket.Object(dst).CopierFrom(g.bucket.Object(src)).Run(g.ctx)
+	return err
+}
+
+// deleteObjects deletes the named objects concurrently, objects which do
+// not exist anymore are ignored.
+func (g gcs) deleteObjects(names []string) error {
+	errs := make(chan error, len(names))
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if err := g.bucket.Object(name).Delete(g.ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				errs <- &os.PathError{Op: "remove", Path: name, Err: err}
+			}
+		}(name)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
 }
 
 type GcsFileInfo struct {
@@ -314,10 +487,20 @@ func (i GcsFileInfo) Size() int64 {
 }
 
 func (i GcsFileInfo) Mode() os.FileMode {
-	return 0777
+	mode := os.FileMode(0777)
+	if m, ok := parseMode(i.attrs.Metadata[metaMode]); ok {
+		mode = m
+	}
+	if i.isFolder {
+		mode |= os.ModeDir
+	}
+	return mode
 }
 
 func (i GcsFileInfo) ModTime() time.Time {
+	if t, ok := parseTime(i.attrs.Metadata[metaMtime]); ok {
+		return t
+	}
 	return i.attrs.Updated
 }
 
@@ -336,14 +519,14 @@ func (g gcs) SignedUrl(path, email string, key []byte) (string, error) {
 		Method:         "GET",
 		Expires:        time.Now().Add(10 * time.Minute),
 	}
-	return storage.SignedURL(g.bucket, path, opts)
+	return g.bucket.SignedURL(path, opts)
 }
 
 // Stat returns a FileInfo describing the named file, or an error, if any
 // happens.
 func (g gcs) Stat(name string) (info os.FileInfo, err error) {
 	attrs, err := g.bucket.Object(name).Attrs(g.ctx)
-	if err == storage.ErrObjectNotExist {
+	if errors.Is(err, storage.ErrObjectNotExist) {
 		return nil, os.ErrNotExist
 	} else if err != nil {
 		return nil, err
@@ -361,14 +544,26 @@ func (g gcs) Name() (name string) {
 	return Name
 }
 
-//Chmod changes the mode of the named file to mode.
+// Chmod changes the mode of the named file to mode. The permission bits are
+// stored in the metadata of the object, folders have no mode which could be
+// changed.
 func (g gcs) Chmod(name string, mode os.FileMode) (err error) {
-	return
+	if mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 {
+		return &os.PathError{Op: "chmod", Path: name, Err: ErrUnsupported}
+	}
+	return g.updateMetadata("chmod", name, map[string]string{
+		metaMode: formatMode(mode),
+	})
 }
 
-//Chtimes changes the access and modification times of the named file
+// Chtimes changes the access and modification times of the named file. The
+// times are stored in the metadata of the object, the time of the last
+// update of an object is set by GCS and cannot be changed.
 func (g gcs) Chtimes(name string, atime time.Time, mtime time.Time) (err error) {
-	return
+	return g.updateMetadata("chtimes", name, map[string]string{
+		metaAtime: formatTime(atime),
+		metaMtime: formatTime(mtime),
+	})
 }
 
 type unfile struct {
@@ -6,14 +6,32 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
+	"github.com/spf13/afero/gcs/gcstest"
 	"github.com/stretchr/testify/require"
 )
 
+// fakeServer is used when no bucket is given in the environment
+var fakeServer *gcstest.Server
+
+func TestMain(m *testing.M) {
+	if os.Getenv("BUCKET_NAME") == "" {
+		fakeServer = gcstest.NewServer("afero-test")
+	}
+	code := m.Run()
+	if fakeServer != nil {
+		fakeServer.Close()
+	}
+	os.Exit(code)
+}
+
 func getFs() (*gcs, error) {
-	//var err error
-	//var scope = storage.ScopeFullControl
+	if fakeServer != nil {
+		// small chunks to exercise resumable uploads
+		return New("afero", "afero-test", WithEndpoint(fakeServer.URL), WithChunkSize(256*1024))
+	}
 	var bucketName string
 	var projectId string
 	if bucketName = os.Getenv("BUCKET_NAME"); bucketName == "" {
@@ -23,16 +41,6 @@ func getFs() (*gcs, error) {
 		return nil, errors.New("Required Env: PROJECT")
 	}
 	return New(projectId, bucketName)
-	//	var client *storage.Client
-	//	ctx := context.Background()
-	//	if client, err = storage.NewClient(ctx, cloud.WithScopes(scope)); err != nil {
-	//		return nil, err
-	//	}
-	//	return &gcs{
-	//		ctx:    ctx,
-	//		client: client,
-	//		bucket: client.Bucket(bucketName),
-	//	}, nil
 }
 
 func TestInterface(t *testing.T) {
@@ -80,6 +88,172 @@ func TestCreateRead(t *testing.T) {
 	}
 }
 
+func TestSeekReadAt(t *testing.T) {
+	require := require.New(t)
+	var err error
+	var fs *gcs
+	if fs, err = getFs(); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.client.Close()
+	name := "test-seek-readat.txt"
+	if err = fs.quickCreate(name); err != nil {
+		t.Fatal(err)
+	}
+	var f afero.File
+	if f, err = fs.Open(name); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 6)
+	if _, err = f.ReadAt(buf, 6); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal("create", string(buf))
+
+	if _, err = f.Seek(-6, os.SEEK_END); err != nil {
+		t.Fatal(err)
+	}
+	var contents []byte
+	if contents, err = ioutil.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal("create", string(contents))
+
+	if _, err = f.Write([]byte("x")); err == nil {
+		t.Error("expected an error writing to a read only handle")
+	}
+}
+
+func TestRenameRemoveAll(t *testing.T) {
+	require := require.New(t)
+	var err error
+	var fs *gcs
+	if fs, err = getFs(); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.client.Close()
+	for _, name := range []string{"folder3/a.txt", "folder3/sub/b.txt"} {
+		if err = fs.quickCreate(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = fs.Rename("folder3", "folder4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.Stat("folder3/sub/b.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected the old object to be gone, got %v", err)
+	}
+	if _, err = fs.Stat("folder4/sub/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fs.Rename("folder4/a.txt", "folder4/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.Stat("folder4/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fs.RemoveAll("folder4"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"folder4/c.txt", "folder4/sub/b.txt"} {
+		_, err = fs.Stat(name)
+		require.True(os.IsNotExist(err), name)
+	}
+	require.Nil(fs.RemoveAll("folder4"))
+}
+
+func TestChmodChtimes(t *testing.T) {
+	require := require.New(t)
+	var err error
+	var fs *gcs
+	if fs, err = getFs(); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.client.Close()
+	name := "folder5/test-chmod.txt"
+	if err = fs.quickCreate(name); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = fs.Chmod(name, 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2015, 10, 21, 16, 29, 0, 0, time.UTC)
+	if err = fs.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	var info os.FileInfo
+	if info, err = fs.Stat(name); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(os.FileMode(0640), info.Mode())
+	require.True(mtime.Equal(info.ModTime()))
+
+	if err = fs.Chmod(name, 0640|os.ModeSetuid); err == nil {
+		t.Error("expected an error setting the setuid bit")
+	}
+	if err = fs.Chmod("folder5", 0755); err == nil {
+		t.Error("expected an error changing the mode of a folder")
+	}
+	if err = fs.Chmod("does-not-exist", 0755); !os.IsNotExist(err) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestLargeObject(t *testing.T) {
+	require := require.New(t)
+	var err error
+	var fs *gcs
+	if fs, err = getFs(); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.client.Close()
+	name := "test-large-object.bin"
+	content := make([]byte, 1024*1024+17)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	var f afero.File
+	if f, err = fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// written in pieces smaller and larger than the chunk size
+	for _, piece := range [][]byte{content[:1000], content[1000:600000], content[600000:]} {
+		if _, err = f.Write(piece); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, err = fs.Open(name); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 100)
+	var n int
+	if n, err = f.ReadAt(buf, 700000); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(content[700000:700000+n], buf[:n])
+	if n, err = f.ReadAt(buf, int64(len(content)-10)); err != io.EOF {
+		t.Errorf("expected io.EOF reading past the end, got %v", err)
+	}
+	require.Equal(10, n)
+	var contents []byte
+	if contents, err = ioutil.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	require.Equal(content, contents)
+}
+
 func (fs gcs) quickCreate(name string) error {
 	var err error
 	var f afero.File
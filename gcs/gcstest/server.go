@@ -0,0 +1,799 @@
+// Package gcstest implements an in-process fake of the Google Cloud Storage
+// JSON and XML APIs, so the gcs backend can be tested without network access
+// or credentials.
+//
+// The objects of a bucket are stored as files below /<bucket> in a MemMapFs,
+// which tests can use to seed or inspect the content directly. As a
+// consequence an object "a" and an object "a/b" cannot exist at the same
+// time, the server rejects such conflicting writes.
+package gcstest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Server is a fake GCS server listening on a local address.
+type Server struct {
+	// URL of the server, to be passed to gcs.WithEndpoint
+	URL string
+	// Fs holds the objects, the object name in bucket is stored at
+	// /bucket/name
+	Fs afero.Fs
+
+	srv        *httptest.Server
+	mu         sync.Mutex
+	meta       map[string]*objectMeta
+	uploads    map[string]*upload
+	generation int64
+	lastUpload int
+}
+
+// objectMeta are the attributes of an object which are not kept in Fs
+type objectMeta struct {
+	generation     int64
+	metageneration int64
+	contentType    string
+	metadata       map[string]string
+	created        time.Time
+	updated        time.Time
+}
+
+// upload is a resumable upload in progress
+type upload struct {
+	bucket string
+	attrs  objectAttrs
+	data   []byte
+}
+
+// object is the JSON representation of an object
+type object struct {
+	Kind           string            `json:"kind"`
+	ID             string            `json:"id"`
+	SelfLink       string            `json:"selfLink,omitempty"`
+	MediaLink      string            `json:"mediaLink,omitempty"`
+	Name           string            `json:"name"`
+	Bucket         string            `json:"bucket"`
+	Generation     int64             `json:"generation,string"`
+	Metageneration int64             `json:"metageneration,string"`
+	ContentType    string            `json:"contentType,omitempty"`
+	StorageClass   string            `json:"storageClass"`
+	Size           uint64            `json:"size,string"`
+	MD5Hash        string            `json:"md5Hash"`
+	CRC32C         string            `json:"crc32c"`
+	Etag           string            `json:"etag"`
+	TimeCreated    string            `json:"timeCreated"`
+	Updated        string            `json:"updated"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+// objectAttrs are the attributes a client sends with an insert, patch or
+// copy. Null metadata values remove a key.
+type objectAttrs struct {
+	Name        string             `json:"name"`
+	ContentType *string            `json:"contentType"`
+	Metadata    map[string]*string `json:"metadata"`
+}
+
+type apiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Errors  []struct {
+			Domain  string `json:"domain"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// NewServer starts a server with the given, empty buckets. The caller
+// should call Close when finished, to shut it down.
+func NewServer(buckets ...string) *Server {
+	s := &Server{
+		Fs:      &afero.MemMapFs{},
+		meta:    make(map[string]*objectMeta),
+		uploads: make(map[string]*upload),
+	}
+	for _, b := range buckets {
+		s.CreateBucket(b)
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// CreateBucket creates the bucket name if it does not exist yet.
+func (s *Server) CreateBucket(name string) error {
+	return s.Fs.MkdirAll(bucketPath(name), 0777)
+}
+
+func bucketPath(bucket string) string {
+	return filepath.Join("/", bucket)
+}
+
+func objectPath(bucket, name string) string {
+	return filepath.Join("/", bucket, filepath.FromSlash(name))
+}
+
+func metaKey(bucket, name string) string {
+	return bucket + "/" + name
+}
+
+// validName reports whether name can be stored as a file. Names with empty,
+// "." or ".." segments would be changed by cleaning the path.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(p, "/upload/storage/v1/b/"):
+		s.serveUpload(w, r, splitPath(strings.TrimPrefix(p, "/upload/storage/v1/b/")))
+	case strings.HasPrefix(p, "/download/storage/v1/b/"):
+		seg := splitPath(strings.TrimPrefix(p, "/download/storage/v1/b/"))
+		if len(seg) != 3 || seg[1] != "o" {
+			writeError(w, http.StatusNotFound, "no such endpoint")
+			return
+		}
+		s.serveMedia(w, r, seg[0], seg[2])
+	case strings.HasPrefix(p, "/storage/v1/b/"):
+		s.serveJSON(w, r, splitPath(strings.TrimPrefix(p, "/storage/v1/b/")))
+	default:
+		// XML API: /bucket/object
+		seg := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+		if len(seg) != 2 {
+			writeError(w, http.StatusNotFound, "no such endpoint")
+			return
+		}
+		bucket, _ := url.PathUnescape(seg[0])
+		name, err := url.PathUnescape(seg[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" {
+			writeError(w, http.StatusMethodNotAllowed, "method not supported")
+			return
+		}
+		s.serveMedia(w, r, bucket, name)
+	}
+}
+
+// splitPath splits an escaped path into its unescaped segments
+func splitPath(p string) []string {
+	seg := strings.Split(p, "/")
+	for i, s := range seg {
+		if u, err := url.PathUnescape(s); err == nil {
+			seg[i] = u
+		}
+	}
+	return seg
+}
+
+func (s *Server) serveJSON(w http.ResponseWriter, r *http.Request, seg []string) {
+	bucket := seg[0]
+	if !s.bucketExists(bucket) {
+		writeError(w, http.StatusNotFound, "bucket "+bucket+" not found")
+		return
+	}
+	switch {
+	case len(seg) == 1 && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]string{
+			"kind": "storage#bucket",
+			"id":   bucket,
+			"name": bucket,
+		})
+	case len(seg) == 2 && seg[1] == "o" && r.Method == "GET":
+		s.list(w, r, bucket)
+	case len(seg) == 3 && seg[1] == "o":
+		name := seg[2]
+		switch r.Method {
+		case "GET":
+			if r.URL.Query().Get("alt") == "media" {
+				s.serveMedia(w, r, bucket, name)
+				return
+			}
+			obj, err := s.resource(bucket, name)
+			if err != nil {
+				writeFsError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, obj)
+		case "PATCH", "PUT":
+			s.update(w, r, bucket, name, r.Method == "PUT")
+		case "DELETE":
+			if _, _, err := s.stat(bucket, name); err != nil {
+				writeFsError(w, err)
+				return
+			}
+			s.remove(bucket, name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not supported")
+		}
+	case len(seg) == 8 && seg[1] == "o" && (seg[3] == "copyTo" || seg[3] == "rewriteTo") &&
+		seg[4] == "b" && seg[6] == "o" && r.Method == "POST":
+		s.copy(w, r, bucket, seg[2], seg[5], seg[7], seg[3] == "rewriteTo")
+	default:
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	}
+}
+
+func (s *Server) bucketExists(bucket string) bool {
+	if bucket == "" || strings.ContainsAny(bucket, "/\\") {
+		return false
+	}
+	fi, err := s.Fs.Stat(bucketPath(bucket))
+	return err == nil && fi.IsDir()
+}
+
+// stat returns the file info and attributes of an object. Objects which were
+// written directly into Fs get their attributes assigned on first use.
+func (s *Server) stat(bucket, name string) (os.FileInfo, *objectMeta, error) {
+	if !validName(name) {
+		return nil, nil, os.ErrNotExist
+	}
+	fi, err := s.Fs.Stat(objectPath(bucket, name))
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		return nil, nil, os.ErrNotExist
+	}
+	m, ok := s.meta[metaKey(bucket, name)]
+	if !ok {
+		s.generation++
+		m = &objectMeta{
+			generation:     s.generation,
+			metageneration: 1,
+			created:        fi.ModTime(),
+			updated:        fi.ModTime(),
+		}
+		s.meta[metaKey(bucket, name)] = m
+	}
+	return fi, m, nil
+}
+
+func (s *Server) resource(bucket, name string) (*object, error) {
+	_, m, err := s.stat(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := afero.ReadFile(s.Fs, objectPath(bucket, name))
+	if err != nil {
+		return nil, err
+	}
+	md5sum := md5.Sum(data)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	return &object{
+		Kind:           "storage#object",
+		ID:             fmt.Sprintf("%s/%s/%d", bucket, name, m.generation),
+		SelfLink:       s.URL + "/storage/v1/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(name),
+		MediaLink:      s.URL + "/download/storage/v1/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(name) + "?alt=media",
+		Name:           name,
+		Bucket:         bucket,
+		Generation:     m.generation,
+		Metageneration: m.metageneration,
+		ContentType:    m.contentType,
+		StorageClass:   "STANDARD",
+		Size:           uint64(len(data)),
+		MD5Hash:        base64.StdEncoding.EncodeToString(md5sum[:]),
+		CRC32C:         base64.StdEncoding.EncodeToString(crc),
+		Etag:           fmt.Sprintf("%x", md5sum),
+		TimeCreated:    m.created.UTC().Format(time.RFC3339Nano),
+		Updated:        m.updated.UTC().Format(time.RFC3339Nano),
+		Metadata:       m.metadata,
+	}, nil
+}
+
+// remove deletes an object and the directories which only held it, so
+// they do not conflict with objects written later
+func (s *Server) remove(bucket, name string) {
+	p := objectPath(bucket, name)
+	s.Fs.Remove(p)
+	delete(s.meta, metaKey(bucket, name))
+	for dir := filepath.Dir(p); dir != bucketPath(bucket); dir = filepath.Dir(dir) {
+		if empty, err := afero.IsEmpty(s.Fs, dir); err != nil || !empty {
+			return
+		}
+		s.Fs.Remove(dir)
+	}
+}
+
+// write stores a new generation of an object
+func (s *Server) write(bucket string, attrs objectAttrs, data []byte) (*object, error) {
+	name := attrs.Name
+	if !validName(name) {
+		return nil, errBadName
+	}
+	p := objectPath(bucket, name)
+	if fi, err := s.Fs.Stat(p); err == nil && fi.IsDir() {
+		return nil, errConflict
+	}
+	for dir := filepath.Dir(p); dir != bucketPath(bucket); dir = filepath.Dir(dir) {
+		if fi, err := s.Fs.Stat(dir); err == nil && !fi.IsDir() {
+			return nil, errConflict
+		}
+	}
+	if err := s.Fs.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return nil, err
+	}
+	if err := afero.WriteFile(s.Fs, p, data, 0666); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.generation++
+	m := &objectMeta{
+		generation:     s.generation,
+		metageneration: 1,
+		contentType:    "application/octet-stream",
+		created:        now,
+		updated:        now,
+	}
+	m.apply(attrs, true)
+	s.meta[metaKey(bucket, name)] = m
+	return s.resource(bucket, name)
+}
+
+// apply sets the attributes sent by a client, with replace the metadata is
+// replaced instead of merged
+func (m *objectMeta) apply(attrs objectAttrs, replace bool) {
+	if attrs.ContentType != nil {
+		m.contentType = *attrs.ContentType
+	}
+	if replace || m.metadata == nil {
+		old := m.metadata
+		m.metadata = make(map[string]string)
+		if !replace {
+			for k, v := range old {
+				m.metadata[k] = v
+			}
+		}
+	}
+	for k, v := range attrs.Metadata {
+		if v == nil {
+			delete(m.metadata, k)
+		} else {
+			m.metadata[k] = *v
+		}
+	}
+	if len(m.metadata) == 0 {
+		m.metadata = nil
+	}
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request, bucket, name string, replace bool) {
+	_, m, err := s.stat(bucket, name)
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+	var attrs objectAttrs
+	if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	m.apply(attrs, replace)
+	m.metageneration++
+	m.updated = time.Now()
+	obj, err := s.resource(bucket, name)
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, obj)
+}
+
+func (s *Server) copy(w http.ResponseWriter, r *http.Request, srcBucket, srcName, dstBucket, dstName string, rewrite bool) {
+	if !s.bucketExists(dstBucket) {
+		writeError(w, http.StatusNotFound, "bucket "+dstBucket+" not found")
+		return
+	}
+	_, m, err := s.stat(srcBucket, srcName)
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+	data, err := afero.ReadFile(s.Fs, objectPath(srcBucket, srcName))
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+
+	// without attributes in the body, the destination gets the ones of the
+	// source
+	attrs := objectAttrs{ContentType: &m.contentType, Metadata: make(map[string]*string)}
+	for k, v := range m.metadata {
+		v := v
+		attrs.Metadata[k] = &v
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if len(bytes.TrimSpace(body)) > 0 {
+		attrs = objectAttrs{}
+		if err := json.Unmarshal(body, &attrs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	attrs.Name = dstName
+
+	obj, err := s.write(dstBucket, attrs, data)
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+	if !rewrite {
+		writeJSON(w, http.StatusOK, obj)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind":                "storage#rewriteResponse",
+		"totalBytesRewritten": strconv.FormatUint(obj.Size, 10),
+		"objectSize":          strconv.FormatUint(obj.Size, 10),
+		"done":                true,
+		"resource":            obj,
+	})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	prefix, delim, token := q.Get("prefix"), q.Get("delimiter"), q.Get("pageToken")
+	max := 1000
+	if m, err := strconv.Atoi(q.Get("maxResults")); err == nil && m > 0 && m < max {
+		max = m
+	}
+
+	root := bucketPath(bucket)
+	var names []string
+	err := afero.Walk(s.Fs, root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			rel, _ := filepath.Rel(root, p)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+
+	// the entries of a page are objects and prefixes, in name order
+	isPrefix := make(map[string]bool)
+	var keys []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if delim != "" {
+			if i := strings.Index(name[len(prefix):], delim); i >= 0 {
+				p := name[:len(prefix)+i+len(delim)]
+				if !isPrefix[p] {
+					isPrefix[p] = true
+					keys = append(keys, p)
+				}
+				continue
+			}
+		}
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	resp := struct {
+		Kind          string    `json:"kind"`
+		NextPageToken string    `json:"nextPageToken,omitempty"`
+		Prefixes      []string  `json:"prefixes,omitempty"`
+		Items         []*object `json:"items,omitempty"`
+	}{Kind: "storage#objects"}
+	i := sort.SearchStrings(keys, token)
+	for ; i < len(keys) && len(resp.Items)+len(resp.Prefixes) < max; i++ {
+		if isPrefix[keys[i]] {
+			resp.Prefixes = append(resp.Prefixes, keys[i])
+			continue
+		}
+		obj, err := s.resource(bucket, keys[i])
+		if err != nil {
+			writeFsError(w, err)
+			return
+		}
+		resp.Items = append(resp.Items, obj)
+	}
+	if i < len(keys) {
+		resp.NextPageToken = keys[i]
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// serveMedia serves the content of an object, including range requests
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	if !s.bucketExists(bucket) {
+		writeError(w, http.StatusNotFound, "bucket "+bucket+" not found")
+		return
+	}
+	obj, err := s.resource(bucket, name)
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+	data, err := afero.ReadFile(s.Fs, objectPath(bucket, name))
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", obj.ContentType)
+	h.Set("Etag", `"`+obj.Etag+`"`)
+	h.Set("X-Goog-Generation", strconv.FormatInt(obj.Generation, 10))
+	h.Set("X-Goog-Metageneration", strconv.FormatInt(obj.Metageneration, 10))
+	h.Set("X-Goog-Stored-Content-Length", strconv.Itoa(len(data)))
+	if r.Header.Get("Range") == "" {
+		h.Add("X-Goog-Hash", "crc32c="+obj.CRC32C)
+		h.Add("X-Goog-Hash", "md5="+obj.MD5Hash)
+	}
+	updated, _ := time.Parse(time.RFC3339Nano, obj.Updated)
+	http.ServeContent(w, r, name, updated, bytes.NewReader(data))
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, seg []string) {
+	if len(seg) != 2 || seg[1] != "o" {
+		writeError(w, http.StatusNotFound, "no such endpoint")
+		return
+	}
+	bucket := seg[0]
+	if !s.bucketExists(bucket) {
+		writeError(w, http.StatusNotFound, "bucket "+bucket+" not found")
+		return
+	}
+	q := r.URL.Query()
+	if id := q.Get("upload_id"); id != "" {
+		s.resumeUpload(w, r, id)
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not supported")
+		return
+	}
+
+	var attrs objectAttrs
+	var data []byte
+	switch q.Get("uploadType") {
+	case "media":
+		ct := r.Header.Get("Content-Type")
+		attrs.ContentType = &ct
+		var err error
+		if data, err = ioutil.ReadAll(r.Body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case "multipart":
+		var err error
+		if attrs, data, err = readMultipart(r); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case "resumable":
+		if err := decodeOptional(r.Body, &attrs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if ct := r.Header.Get("X-Upload-Content-Type"); ct != "" && attrs.ContentType == nil {
+			attrs.ContentType = &ct
+		}
+		if attrs.Name == "" {
+			attrs.Name = q.Get("name")
+		}
+		if !validName(attrs.Name) {
+			writeFsError(w, errBadName)
+			return
+		}
+		s.lastUpload++
+		id := strconv.Itoa(s.lastUpload)
+		s.uploads[id] = &upload{bucket: bucket, attrs: attrs}
+		w.Header().Set("Location", s.URL+"/upload/storage/v1/b/"+url.PathEscape(bucket)+
+			"/o?uploadType=resumable&upload_id="+id)
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported uploadType")
+		return
+	}
+	if attrs.Name == "" {
+		attrs.Name = q.Get("name")
+	}
+	obj, err := s.write(bucket, attrs, data)
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, obj)
+}
+
+// resumeUpload handles a chunk of a resumable upload. The Content-Range
+// header is "bytes first-last/total", where total is "*" until the last
+// chunk, or "bytes */total" without content.
+func (s *Server) resumeUpload(w http.ResponseWriter, r *http.Request, id string) {
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such upload")
+		return
+	}
+	if r.Method == "DELETE" {
+		delete(s.uploads, id)
+		w.WriteHeader(499)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	first, total := int64(len(u.data)), int64(-1)
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		spec := strings.TrimPrefix(cr, "bytes ")
+		i := strings.Index(spec, "/")
+		if i < 0 {
+			writeError(w, http.StatusBadRequest, "invalid Content-Range "+cr)
+			return
+		}
+		if t := spec[i+1:]; t != "*" {
+			if total, err = strconv.ParseInt(t, 10, 64); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid Content-Range "+cr)
+				return
+			}
+		}
+		if rng := spec[:i]; rng != "*" {
+			if first, err = strconv.ParseInt(strings.SplitN(rng, "-", 2)[0], 10, 64); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid Content-Range "+cr)
+				return
+			}
+		}
+	} else {
+		total = first + int64(len(data))
+	}
+	if first > int64(len(u.data)) {
+		writeError(w, http.StatusBadRequest, "chunk does not continue the upload")
+		return
+	}
+	// a retried chunk overlaps with the data already received
+	u.data = append(u.data[:first], data...)
+
+	if total < 0 || int64(len(u.data)) < total {
+		if len(u.data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(u.data)-1))
+		}
+		if r.Header.Get("X-GUploader-No-308") == "yes" {
+			// clients whose HTTP stack follows 308 as a redirect ask for
+			// the status in a header instead
+			w.Header().Set("X-HTTP-Status-Code-Override", "308")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(308)
+		return
+	}
+	delete(s.uploads, id)
+	obj, err := s.write(u.bucket, u.attrs, u.data[:total])
+	if err != nil {
+		writeFsError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, obj)
+}
+
+func readMultipart(r *http.Request) (attrs objectAttrs, data []byte, err error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return attrs, nil, err
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return attrs, nil, err
+	}
+	if err = decodeOptional(part, &attrs); err != nil {
+		return attrs, nil, err
+	}
+	if part, err = mr.NextPart(); err != nil {
+		return attrs, nil, err
+	}
+	if ct := part.Header.Get("Content-Type"); ct != "" && attrs.ContentType == nil {
+		attrs.ContentType = &ct
+	}
+	data, err = ioutil.ReadAll(part)
+	return attrs, data, err
+}
+
+// decodeOptional decodes JSON from r, an empty body is no error
+func decodeOptional(r io.Reader, v interface{}) error {
+	body, err := ioutil.ReadAll(r)
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+var (
+	errBadName  = &statusError{http.StatusBadRequest, "object name not supported by gcstest"}
+	errConflict = &statusError{http.StatusConflict, "object name conflicts with an existing object"}
+)
+
+func writeFsError(w http.ResponseWriter, err error) {
+	switch e := err.(type) {
+	case *statusError:
+		writeError(w, e.code, e.msg)
+		return
+	}
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, "No such object")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	var e apiError
+	e.Error.Code = code
+	e.Error.Message = msg
+	e.Error.Errors = append(e.Error.Errors, struct {
+		Domain  string `json:"domain"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}{"global", strings.Replace(strings.ToLower(http.StatusText(code)), " ", "", -1), msg})
+	writeJSON(w, code, e)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,224 @@
+package gcstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func do(t *testing.T, method, url string, header map[string]string, body []byte) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("unexpected status %d: %s", resp.StatusCode, b)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, resp *http.Response) string {
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestUploadAndRead(t *testing.T) {
+	s := NewServer("bucket")
+	defer s.Close()
+
+	var obj object
+	decode(t, do(t, "POST", s.URL+"/upload/storage/v1/b/bucket/o?uploadType=media&name=dir%2Fa.txt",
+		map[string]string{"Content-Type": "text/plain"}, []byte("hello world")), &obj)
+	if obj.Name != "dir/a.txt" || obj.Size != 11 || obj.ContentType != "text/plain" {
+		t.Errorf("unexpected object %+v", obj)
+	}
+	if data, err := afero.ReadFile(s.Fs, "/bucket/dir/a.txt"); err != nil || string(data) != "hello world" {
+		t.Errorf("object not stored in Fs: %q, %v", data, err)
+	}
+
+	resp := do(t, "GET", s.URL+"/bucket/dir/a.txt", map[string]string{"Range": "bytes=6-"}, nil)
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("expected partial content, got %d", resp.StatusCode)
+	}
+	if got := read(t, resp); got != "world" {
+		t.Errorf("range read: got %q", got)
+	}
+	resp = do(t, "GET", s.URL+"/storage/v1/b/bucket/o/dir%2Fa.txt?alt=media", nil, nil)
+	if got := read(t, resp); got != "hello world" {
+		t.Errorf("media read: got %q", got)
+	}
+
+	resp = do(t, "GET", s.URL+"/storage/v1/b/bucket/o/missing", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing object, got %d", resp.StatusCode)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	s := NewServer("bucket")
+	defer s.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+	pw.Write([]byte(`{"name":"m.txt","metadata":{"k":"v"}}`))
+	pw, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain"}})
+	pw.Write([]byte("multipart content"))
+	mw.Close()
+
+	var obj object
+	decode(t, do(t, "POST", s.URL+"/upload/storage/v1/b/bucket/o?uploadType=multipart",
+		map[string]string{"Content-Type": "multipart/related; boundary=" + mw.Boundary()}, body.Bytes()), &obj)
+	if obj.Name != "m.txt" || obj.Metadata["k"] != "v" || obj.ContentType != "text/plain" {
+		t.Errorf("unexpected object %+v", obj)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	s := NewServer("bucket")
+	defer s.Close()
+
+	resp := do(t, "POST", s.URL+"/upload/storage/v1/b/bucket/o?uploadType=resumable",
+		nil, []byte(`{"name":"big"}`))
+	resp.Body.Close()
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		t.Fatal("no upload location returned")
+	}
+
+	resp = do(t, "PUT", loc, map[string]string{"Content-Range": "bytes 0-4/*"}, []byte("01234"))
+	resp.Body.Close()
+	if resp.StatusCode != 308 || resp.Header.Get("Range") != "bytes=0-4" {
+		t.Errorf("expected 308 with Range bytes=0-4, got %d %q", resp.StatusCode, resp.Header.Get("Range"))
+	}
+	// a retried chunk overlapping the received data, from a client asking
+	// for the status in a header
+	resp = do(t, "PUT", loc, map[string]string{"Content-Range": "bytes 3-7/*", "X-GUploader-No-308": "yes"}, []byte("34567"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-HTTP-Status-Code-Override") != "308" {
+		t.Errorf("expected 200 with a status override, got %d %q", resp.StatusCode, resp.Header.Get("X-HTTP-Status-Code-Override"))
+	}
+	if resp.Header.Get("Range") != "bytes=0-7" {
+		t.Errorf("expected Range bytes=0-7, got %q", resp.Header.Get("Range"))
+	}
+
+	var obj object
+	decode(t, do(t, "PUT", loc, map[string]string{"Content-Range": "bytes 8-9/10"}, []byte("89")), &obj)
+	if obj.Size != 10 {
+		t.Errorf("expected size 10, got %d", obj.Size)
+	}
+	if got := read(t, do(t, "GET", s.URL+"/bucket/big", nil, nil)); got != "0123456789" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	s := NewServer("bucket")
+	defer s.Close()
+	for _, name := range []string{"a/1", "a/2", "a/b/3", "a-c", "z"} {
+		afero.WriteFile(s.Fs, "/bucket/"+name, []byte(name), 0644)
+	}
+
+	type list struct {
+		Items []struct {
+			Name string `json:"name"`
+		} `json:"items"`
+		Prefixes      []string `json:"prefixes"`
+		NextPageToken string   `json:"nextPageToken"`
+	}
+
+	var l list
+	decode(t, do(t, "GET", s.URL+"/storage/v1/b/bucket/o?prefix=a%2F&delimiter=%2F", nil, nil), &l)
+	if len(l.Items) != 2 || l.Items[0].Name != "a/1" || l.Items[1].Name != "a/2" {
+		t.Errorf("unexpected items %+v", l.Items)
+	}
+	if len(l.Prefixes) != 1 || l.Prefixes[0] != "a/b/" {
+		t.Errorf("unexpected prefixes %v", l.Prefixes)
+	}
+
+	var names []string
+	token := ""
+	for {
+		l = list{}
+		decode(t, do(t, "GET", s.URL+"/storage/v1/b/bucket/o?maxResults=2&pageToken="+token, nil, nil), &l)
+		for _, item := range l.Items {
+			names = append(names, item.Name)
+		}
+		if l.NextPageToken == "" {
+			break
+		}
+		token = l.NextPageToken
+	}
+	if got := strings.Join(names, ","); got != "a-c,a/1,a/2,a/b/3,z" {
+		t.Errorf("unexpected listing %s", got)
+	}
+}
+
+func TestPatchCopyDelete(t *testing.T) {
+	s := NewServer("bucket", "other")
+	defer s.Close()
+	afero.WriteFile(s.Fs, "/bucket/dir/src", []byte("content"), 0644)
+
+	var obj object
+	decode(t, do(t, "PATCH", s.URL+"/storage/v1/b/bucket/o/dir%2Fsrc", nil,
+		[]byte(`{"metadata":{"a":"1","b":"2"}}`)), &obj)
+	obj = object{}
+	decode(t, do(t, "PATCH", s.URL+"/storage/v1/b/bucket/o/dir%2Fsrc", nil,
+		[]byte(`{"metadata":{"b":null}}`)), &obj)
+	if len(obj.Metadata) != 1 || obj.Metadata["a"] != "1" {
+		t.Errorf("unexpected metadata %v", obj.Metadata)
+	}
+
+	obj = object{}
+	decode(t, do(t, "POST", s.URL+"/storage/v1/b/bucket/o/dir%2Fsrc/copyTo/b/other/o/dst", nil, nil), &obj)
+	if obj.Bucket != "other" || obj.Name != "dst" || obj.Metadata["a"] != "1" {
+		t.Errorf("unexpected copy %+v", obj)
+	}
+	if got := read(t, do(t, "GET", s.URL+"/other/dst", nil, nil)); got != "content" {
+		t.Errorf("copied content: got %q", got)
+	}
+
+	resp := do(t, "DELETE", s.URL+"/storage/v1/b/bucket/o/dir%2Fsrc", nil, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", resp.StatusCode)
+	}
+	// the directory of the deleted object does not conflict with a new object
+	resp = do(t, "POST", s.URL+"/upload/storage/v1/b/bucket/o?uploadType=media&name=dir", nil, []byte("x"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected to create object dir, got %d", resp.StatusCode)
+	}
+	resp = do(t, "POST", s.URL+"/upload/storage/v1/b/bucket/o?uploadType=media&name=dir%2Fx", nil, []byte("x"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected a conflict below an object, got %d", resp.StatusCode)
+	}
+}
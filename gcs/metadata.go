@@ -0,0 +1,77 @@
+package gcs
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrUnsupported is returned for operations which cannot be mapped onto
+// objects in a bucket.
+var ErrUnsupported = errors.New("operation not supported by Google Cloud Storage")
+
+// The metadata keys are the ones gsutil uses to preserve POSIX attributes,
+// so objects copied with "gsutil cp -P" show their original mode and times.
+const (
+	metaMode  = "goog-reserved-posix-mode"
+	metaAtime = "goog-reserved-file-atime"
+	metaMtime = "goog-reserved-file-mtime"
+)
+
+func formatMode(mode os.FileMode) string {
+	return strconv.FormatUint(uint64(mode.Perm()), 8)
+}
+
+func parseMode(s string) (os.FileMode, bool) {
+	if s == "" {
+		return 0, false
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, false
+	}
+	return os.FileMode(m) & os.ModePerm, true
+}
+
+// times are stored as seconds since the epoch
+func formatTime(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func parseTime(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// updateMetadata merges meta into the metadata of the object name.
+func (g gcs) updateMetadata(op, name string, meta map[string]string) error {
+	obj := g.bucket.Object(name)
+	attrs, err := obj.Attrs(g.ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		if folder, _ := g.isFolder(name); folder {
+			// only exists as a prefix of other objects
+			return &os.PathError{Op: op, Path: name, Err: ErrUnsupported}
+		}
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	} else if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(attrs.Metadata)+len(meta))
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	for k, v := range meta {
+		metadata[k] = v
+	}
+	_, err = obj.Update(g.ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	return err
+}
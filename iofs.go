@@ -0,0 +1,318 @@
+package afero
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// IOFS adapts an afero Fs to the io/fs interfaces of the standard library,
+// so it can be used with http.FS, template.ParseFS, fs.WalkDir and friends.
+//
+// Names are io/fs names: slash separated, unrooted and without "." or ".."
+// elements (see fs.ValidPath). They are resolved relative to the root the
+// IOFS was created with, "." being the root itself.
+type IOFS struct {
+	source Fs
+	root   string
+}
+
+var (
+	_ fs.FS         = IOFS{}
+	_ fs.ReadDirFS  = IOFS{}
+	_ fs.ReadFileFS = IOFS{}
+	_ fs.StatFS     = IOFS{}
+	_ fs.GlobFS     = IOFS{}
+	_ fs.SubFS      = IOFS{}
+)
+
+// NewIOFS returns an fs.FS serving the files of source. The root of the
+// returned fs.FS is the root of source.
+func NewIOFS(source Fs) IOFS {
+	return IOFS{source: source, root: FilePathSeparator}
+}
+
+// realPath maps a valid io/fs name to a name in the source Fs.
+func (i IOFS) realPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(i.root, filepath.FromSlash(name)), nil
+}
+
+// pathError rewrites the path of errors returned by the source Fs, which
+// does not know about the io/fs name.
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*fs.PathError); ok {
+		return &fs.PathError{Op: op, Path: name, Err: e.Err}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (i IOFS) Open(name string) (fs.File, error) {
+	p, err := i.realPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := i.source.Open(p)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &ioFile{File: f, name: name}, nil
+}
+
+func (i IOFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := i.realPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	list, err := ReadDir(i.source, p)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	return dirEntries(list), nil
+}
+
+func (i IOFS) ReadFile(name string) ([]byte, error) {
+	p, err := i.realPath("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ReadFile(i.source, p)
+	if err != nil {
+		return nil, pathError("readfile", name, err)
+	}
+	return data, nil
+}
+
+func (i IOFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := i.realPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := i.source.Stat(p)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fi, nil
+}
+
+// Glob returns the names of all files matching pattern, see fs.Glob.
+func (i IOFS) Glob(pattern string) ([]string, error) {
+	// hide the GlobFS implementation from fs.Glob, which would call us back
+	return fs.Glob(struct{ fs.ReadDirFS }{i}, pattern)
+}
+
+// Sub returns an IOFS rooted at dir, see fs.Sub.
+func (i IOFS) Sub(dir string) (fs.FS, error) {
+	p, err := i.realPath("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	return IOFS{source: i.source, root: p}, nil
+}
+
+// ioFile adds the fs.ReadDirFile method to a File. Everything else,
+// including Seek and ReadAt for http.FS, is passed through.
+type ioFile struct {
+	File
+	name string
+}
+
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	fi, err := f.File.Stat()
+	return fi, pathError("stat", f.name, err)
+}
+
+func (f *ioFile) ReadDir(count int) ([]fs.DirEntry, error) {
+	list, err := f.File.Readdir(count)
+	if err != nil && err != io.EOF {
+		return nil, pathError("readdir", f.name, err)
+	}
+	// Readdir does not guarantee any order, fs.ReadDirFile asks for
+	// directory order; sorting at least makes it stable
+	sort.Sort(byName(list))
+	return dirEntries(list), err
+}
+
+func dirEntries(list []os.FileInfo) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(list))
+	for i, fi := range list {
+		entries[i] = fs.FileInfoToDirEntry(fi)
+	}
+	return entries
+}
+
+// FromIOFS is a read-only Fs serving the files of an io/fs file system,
+// for example an embed.FS. All writes fail with EPERM, like ReadOnlyFs.
+//
+// Names are cleaned and made relative before being passed to the fs.FS, so
+// "/static/app.js", "static/app.js" and "static/../static/app.js" all name
+// the same file.
+type FromIOFS struct {
+	source fs.FS
+}
+
+func NewFromIOFS(source fs.FS) Fs {
+	return &FromIOFS{source: source}
+}
+
+// ioName maps an afero name to a valid io/fs name.
+func ioName(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	if name == "/" {
+		return "."
+	}
+	return strings.TrimPrefix(name, "/")
+}
+
+func (f *FromIOFS) Name() string {
+	return "FromIOFS"
+}
+
+func (f *FromIOFS) Open(name string) (File, error) {
+	file, err := f.source.Open(ioName(name))
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &fromIOFile{file: file, name: name}, nil
+}
+
+func (f *FromIOFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+	}
+	return f.Open(name)
+}
+
+func (f *FromIOFS) Stat(name string) (os.FileInfo, error) {
+	fi, err := fs.Stat(f.source, ioName(name))
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fi, nil
+}
+
+func (f *FromIOFS) Create(name string) (File, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+}
+
+func (f *FromIOFS) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (f *FromIOFS) MkdirAll(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (f *FromIOFS) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+func (f *FromIOFS) RemoveAll(name string) error {
+	return &os.PathError{Op: "removeall", Path: name, Err: syscall.EPERM}
+}
+
+func (f *FromIOFS) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EPERM}
+}
+
+func (f *FromIOFS) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+}
+
+func (f *FromIOFS) Chtimes(name string, atime, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EPERM}
+}
+
+// fromIOFile is a read-only File around an fs.File. Seek and ReadAt work if
+// the fs.File supports them, as the files of embed.FS and os.DirFS do.
+type fromIOFile struct {
+	file fs.File
+	name string
+}
+
+func (f *fromIOFile) Name() string {
+	return f.name
+}
+
+func (f *fromIOFile) Close() error {
+	return f.file.Close()
+}
+
+func (f *fromIOFile) Read(p []byte) (int, error) {
+	return f.file.Read(p)
+}
+
+func (f *fromIOFile) ReadAt(p []byte, off int64) (int, error) {
+	if r, ok := f.file.(io.ReaderAt); ok {
+		return r.ReadAt(p, off)
+	}
+	return 0, &os.PathError{Op: "readat", Path: f.name, Err: syscall.ENOTSUP}
+}
+
+func (f *fromIOFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.file.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.ESPIPE}
+}
+
+func (f *fromIOFile) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+func (f *fromIOFile) Readdir(count int) ([]os.FileInfo, error) {
+	d, ok := f.file.(fs.ReadDirFile)
+	if !ok {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	entries, err := d.ReadDir(count)
+	list := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		fi, ierr := e.Info()
+		if ierr != nil {
+			return list, ierr
+		}
+		list = append(list, fi)
+	}
+	return list, err
+}
+
+func (f *fromIOFile) Readdirnames(n int) ([]string, error) {
+	list, err := f.Readdir(n)
+	names := make([]string, len(list))
+	for i, fi := range list {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
+
+func (f *fromIOFile) Sync() error {
+	return nil
+}
+
+func (f *fromIOFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *fromIOFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *fromIOFile) WriteString(s string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *fromIOFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EPERM}
+}
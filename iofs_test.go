@@ -0,0 +1,170 @@
+package afero
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func newIOFSTestFs(t *testing.T, fs Fs) Fs {
+	for name, content := range map[string]string{
+		"/a.txt":         "a",
+		"/dir/b.txt":     "bb",
+		"/dir/c.html":    "<p>c</p>",
+		"/dir/sub/d.txt": "ddd",
+	} {
+		if err := fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.MkdirAll("/empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestIOFS(t *testing.T) {
+	// the MemMapFs File does not report io.EOF from short ReadAt calls,
+	// which fstest.TestFS insists on
+	osfs := NewIOFS(newIOFSTestFs(t, NewBasePathFs(NewOsFs(), t.TempDir())))
+	if err := fstest.TestFS(osfs, "a.txt", "dir/b.txt", "dir/c.html", "dir/sub/d.txt", "empty"); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := fs.Sub(osfs, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(sub, "b.txt", "c.html", "sub/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	iofs := NewIOFS(newIOFSTestFs(t, NewMemMapFs()))
+	if sub, err = fs.Sub(iofs, "dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(sub, "d.txt"); err != nil || string(data) != "ddd" {
+		t.Errorf("ReadFile from Sub: got %q, %v", data, err)
+	}
+
+	matches, err := fs.Glob(iofs, "dir/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(matches, []string{"dir/b.txt"}) {
+		t.Errorf("Glob: got %v", matches)
+	}
+
+	var walked []string
+	err = fs.WalkDir(iofs, ".", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "a.txt", "dir", "dir/b.txt", "dir/c.html", "dir/sub", "dir/sub/d.txt", "empty"}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("WalkDir: got %v, want %v", walked, want)
+	}
+
+	for _, name := range []string{"/a.txt", "dir/../a.txt", "dir/"} {
+		if _, err := iofs.Open(name); err == nil {
+			t.Errorf("Open(%q) should fail on an invalid name", name)
+		}
+	}
+	_, err = iofs.Open("missing")
+	if perr, ok := err.(*fs.PathError); !ok || perr.Path != "missing" || !os.IsNotExist(err) {
+		t.Errorf("Open of a missing file: got %#v", err)
+	}
+}
+
+func TestIOFSHttp(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.FS(NewIOFS(newIOFSTestFs(t, NewMemMapFs())))))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/dir/sub/d.txt", nil)
+	req.Header.Set("Range", "bytes=1-")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "dd" {
+		t.Errorf("got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestFromIOFS(t *testing.T) {
+	afs := NewFromIOFS(fstest.MapFS{
+		"a.txt":         {Data: []byte("a"), Mode: 0644},
+		"dir/b.txt":     {Data: []byte("bb"), Mode: 0644},
+		"dir/sub/c.txt": {Data: []byte("ccc"), Mode: 0600},
+	})
+
+	for _, name := range []string{"/dir/b.txt", "dir/b.txt", "/dir/../dir/b.txt"} {
+		data, err := ReadFile(afs, name)
+		if err != nil || string(data) != "bb" {
+			t.Errorf("ReadFile(%q): got %q, %v", name, data, err)
+		}
+	}
+
+	fi, err := afs.Stat("/dir/sub/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 3 || fi.Mode() != 0600 {
+		t.Errorf("Stat: unexpected size %d or mode %v", fi.Size(), fi.Mode())
+	}
+	if _, err := afs.Stat("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a missing file: expected ErrNotExist, got %v", err)
+	}
+
+	f, err := afs.Open("/dir/sub/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "cc" {
+		t.Errorf("Read after Seek: got %q, %v", buf, err)
+	}
+	if _, err := f.Write([]byte("x")); !os.IsPermission(err) {
+		t.Errorf("Write: expected EPERM, got %v", err)
+	}
+	f.Close()
+
+	var walked []string
+	err = Walk(afs, "/", func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/a.txt", "/dir", "/dir/b.txt", "/dir/sub", "/dir/sub/c.txt"}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("Walk: got %v, want %v", walked, want)
+	}
+
+	if _, err := afs.Create("/new"); !os.IsPermission(err) {
+		t.Errorf("Create: expected EPERM, got %v", err)
+	}
+	if _, err := afs.OpenFile("/a.txt", os.O_RDWR, 0); !os.IsPermission(err) {
+		t.Errorf("OpenFile O_RDWR: expected EPERM, got %v", err)
+	}
+	if err := afs.Remove("/a.txt"); !os.IsPermission(err) {
+		t.Errorf("Remove: expected EPERM, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package afero
+
+import (
+	"errors"
+	"os"
+)
+
+// Linker is an optional interface in Afero. It is only implemented by the
+// filesystems supporting hard links.
+type Linker interface {
+	// Link creates newname as a hard link to the oldname file.
+	Link(oldname, newname string) error
+}
+
+var ErrNoLink = errors.New("hard links not supported by filesystem")
+
+// Link creates newname as a hard link to the oldname file. It returns
+// ErrNoLink if the filesystem is not a Linker.
+func (a Afero) Link(oldname, newname string) error {
+	return Link(a.Fs, oldname, newname)
+}
+
+func Link(fs Fs, oldname, newname string) error {
+	if l, ok := fs.(Linker); ok {
+		return l.Link(oldname, newname)
+	}
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: ErrNoLink}
+}
@@ -0,0 +1,100 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero/mem"
+)
+
+func TestLink(t *testing.T) {
+	defer removeAllTestFiles(t)
+	for _, fs := range Fss {
+		tmp := testDir(fs)
+		oldname := filepath.Join(tmp, "old")
+		newname := filepath.Join(tmp, "new")
+
+		if err := WriteFile(fs, oldname, []byte("content"), 0644); err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+		if err := Link(fs, oldname, newname); err != nil {
+			t.Fatal(fs.Name(), "Link failed:", err)
+		}
+		if err := Link(fs, oldname, newname); !os.IsExist(err) {
+			t.Errorf("%v: Link over existing file: expected ErrExist, got %v", fs.Name(), err)
+		}
+		if err := Link(fs, tmp, filepath.Join(tmp, "dir")); err == nil {
+			t.Errorf("%v: Link of a directory should fail", fs.Name())
+		}
+
+		f, err := fs.OpenFile(newname, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+		f.WriteString(" appended")
+		f.Close()
+
+		data, err := ReadFile(fs, oldname)
+		if err != nil || string(data) != "content appended" {
+			t.Errorf("%v: write through link not visible: got %q, %v", fs.Name(), data, err)
+		}
+
+		if err := fs.Remove(oldname); err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+		data, err = ReadFile(fs, newname)
+		if err != nil || string(data) != "content appended" {
+			t.Errorf("%v: content lost after removing a link: got %q, %v", fs.Name(), data, err)
+		}
+	}
+}
+
+func TestMemMapFsInode(t *testing.T) {
+	fs := &MemMapFs{}
+	WriteFile(fs, "/a", []byte("content"), 0644)
+	WriteFile(fs, "/other", []byte("content"), 0644)
+
+	sys := func(name string) *mem.Stat {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Sys().(*mem.Stat)
+	}
+
+	if sys("/a").Nlink != 1 {
+		t.Errorf("expected link count 1, got %d", sys("/a").Nlink)
+	}
+	if sys("/a").Ino == sys("/other").Ino {
+		t.Error("different files share an inode number")
+	}
+
+	if err := fs.Link("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if sys("/a").Ino != sys("/b").Ino {
+		t.Error("hard links have different inode numbers")
+	}
+	if n := sys("/b").Nlink; n != 2 {
+		t.Errorf("expected link count 2, got %d", n)
+	}
+
+	ino := sys("/b").Ino
+	if err := fs.Rename("/b", "/c"); err != nil {
+		t.Fatal(err)
+	}
+	if sys("/c").Ino != ino {
+		t.Error("inode number changed on rename")
+	}
+
+	fs.Remove("/a")
+	if n := sys("/c").Nlink; n != 1 {
+		t.Errorf("expected link count 1 after remove, got %d", n)
+	}
+
+	fi, _ := fs.Stat("/c")
+	if fi.Name() != "c" {
+		t.Errorf("expected name of the link, got %q", fi.Name())
+	}
+}
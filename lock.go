@@ -0,0 +1,87 @@
+package afero
+
+import (
+	"errors"
+	"os"
+)
+
+// Locker is an optional interface in Afero. It is implemented by the files
+// supporting advisory locks, which, like flock(2), are held by an open file
+// and released when it is closed. Files of an OsFs are *os.File, they are
+// locked with flock where it is available.
+type Locker interface {
+	// Lock places an exclusive lock on the file, waiting until no other
+	// open file holds a lock on it. A lock already held is converted.
+	Lock() error
+	// RLock places a shared lock on the file, waiting until no other open
+	// file holds an exclusive lock on it. A lock already held is converted.
+	RLock() error
+	// TryLock is like Lock but returns false instead of waiting.
+	TryLock() (bool, error)
+	// TryRLock is like RLock but returns false instead of waiting.
+	TryRLock() (bool, error)
+	// Unlock releases the lock held by the file.
+	Unlock() error
+}
+
+var ErrNoLock = errors.New("file locking not supported by filesystem")
+
+// lockerOf returns the Locker of f, for an *os.File one using flock.
+func lockerOf(f File) (Locker, error) {
+	if l, ok := f.(Locker); ok {
+		return l, nil
+	}
+	if osf, ok := f.(*os.File); ok {
+		if l, ok := newFlock(osf); ok {
+			return l, nil
+		}
+	}
+	return nil, &os.PathError{Op: "lock", Path: f.Name(), Err: ErrNoLock}
+}
+
+// LockFile places an exclusive lock on f, see Locker. It returns ErrNoLock
+// if f cannot be locked.
+func LockFile(f File) error {
+	l, err := lockerOf(f)
+	if err != nil {
+		return err
+	}
+	return l.Lock()
+}
+
+// RLockFile places a shared lock on f, see Locker. It returns ErrNoLock if
+// f cannot be locked.
+func RLockFile(f File) error {
+	l, err := lockerOf(f)
+	if err != nil {
+		return err
+	}
+	return l.RLock()
+}
+
+// TryLockFile is like LockFile but returns false instead of waiting.
+func TryLockFile(f File) (bool, error) {
+	l, err := lockerOf(f)
+	if err != nil {
+		return false, err
+	}
+	return l.TryLock()
+}
+
+// TryRLockFile is like RLockFile but returns false instead of waiting.
+func TryRLockFile(f File) (bool, error) {
+	l, err := lockerOf(f)
+	if err != nil {
+		return false, err
+	}
+	return l.TryRLock()
+}
+
+// UnlockFile releases the lock held by f.
+func UnlockFile(f File) error {
+	l, err := lockerOf(f)
+	if err != nil {
+		return err
+	}
+	return l.Unlock()
+}
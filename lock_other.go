@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package afero
+
+import "os"
+
+func newFlock(f *os.File) (Locker, bool) {
+	return nil, false
+}
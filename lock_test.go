@@ -0,0 +1,120 @@
+package afero
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero/mem"
+)
+
+func TestLockFile(t *testing.T) {
+	defer removeAllTestFiles(t)
+	for _, fs := range Fss {
+		if _, ok := fs.(*OsFs); ok && runtime.GOOS == "windows" {
+			continue
+		}
+		name := filepath.Join(testDir(fs), "locked")
+		WriteFile(fs, name, nil, 0644)
+		f1, err := fs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f2, err := fs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := RLockFile(f1); err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+		if ok, err := TryRLockFile(f2); !ok || err != nil {
+			t.Errorf("%v: second shared lock: %v, %v", fs.Name(), ok, err)
+		}
+		if ok, err := TryLockFile(f1); ok || err != nil {
+			t.Errorf("%v: exclusive lock over a shared one: %v, %v", fs.Name(), ok, err)
+		}
+		UnlockFile(f2)
+		if ok, err := TryLockFile(f1); !ok || err != nil {
+			t.Errorf("%v: upgrade of the only lock: %v, %v", fs.Name(), ok, err)
+		}
+
+		// a blocked Lock gets the lock when the holder is closed
+		locked := make(chan error)
+		go func() {
+			locked <- LockFile(f2)
+		}()
+		select {
+		case err := <-locked:
+			t.Fatalf("%v: Lock did not wait: %v", fs.Name(), err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		f1.Close()
+		select {
+		case err := <-locked:
+			if err != nil {
+				t.Errorf("%v: %v", fs.Name(), err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: Close did not release the lock", fs.Name())
+		}
+		f2.Close()
+	}
+}
+
+func TestMemMapFsLock(t *testing.T) {
+	fs := &MemMapFs{}
+	WriteFile(fs, "/file", nil, 0644)
+	fs.Link("/file", "/link")
+	f1, _ := fs.Open("/file")
+	f2, _ := fs.Open("/link")
+	defer f2.Close()
+
+	// hard links share their locks
+	if err := LockFile(f1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := TryRLockFile(f2); ok {
+		t.Error("a hard link was locked twice")
+	}
+
+	// closing a handle wakes up its own pending Lock
+	f3, _ := fs.Open("/file")
+	locked := make(chan error)
+	go func() {
+		locked <- LockFile(f3)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	f3.Close()
+	select {
+	case err := <-locked:
+		if err != mem.ErrFileClosed {
+			t.Errorf("expected ErrFileClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not wake up a pending Lock")
+	}
+
+	// the two handles upgrading their shared locks do not deadlock
+	UnlockFile(f1)
+	RLockFile(f1)
+	RLockFile(f2)
+	done := make(chan bool)
+	go func() {
+		LockFile(f1)
+		f1.Close()
+		done <- true
+	}()
+	LockFile(f2)
+	UnlockFile(f2)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upgrading shared locks deadlocked")
+	}
+
+	if _, err := TryLockFile(&UnionFile{layer: f2}); err != nil {
+		t.Error("locking a UnionFile:", err)
+	}
+}
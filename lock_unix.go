@@ -0,0 +1,44 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package afero
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock locks an *os.File with flock(2).
+type flock struct {
+	f *os.File
+}
+
+func newFlock(f *os.File) (Locker, bool) {
+	return flock{f}, true
+}
+
+func (l flock) flock(how int) error {
+	for {
+		err := syscall.Flock(int(l.f.Fd()), how)
+		if err != syscall.EINTR {
+			if err != nil {
+				return &os.PathError{Op: "flock", Path: l.f.Name(), Err: err}
+			}
+			return nil
+		}
+	}
+}
+
+func (l flock) try(how int) (bool, error) {
+	err := l.flock(how | syscall.LOCK_NB)
+	if perr, ok := err.(*os.PathError); ok && perr.Err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l flock) Lock() error             { return l.flock(syscall.LOCK_EX) }
+func (l flock) RLock() error            { return l.flock(syscall.LOCK_SH) }
+func (l flock) TryLock() (bool, error)  { return l.try(syscall.LOCK_EX) }
+func (l flock) TryRLock() (bool, error) { return l.try(syscall.LOCK_SH) }
+func (l flock) Unlock() error           { return l.flock(syscall.LOCK_UN) }
@@ -0,0 +1,190 @@
+package mem
+
+import "io"
+
+// chunkSize is the size of the blocks the content of a file is split into.
+const chunkSize = 64 << 10
+
+// zeroChunk is written out for the holes of a file.
+var zeroChunk [chunkSize]byte
+
+// chunks is the content of a file, kept in blocks of chunkSize bytes so that
+// writing in the middle of a large file or extending it only touches the
+// blocks involved. A block covers the bytes from its index times chunkSize,
+// it may be shorter than chunkSize, the bytes it lacks below size read as
+// zeros. Missing blocks are holes: a file extended by Truncate or by writing
+// past its end is sparse, its holes take no memory until they are written
+// to.
+type chunks struct {
+	blocks map[int64][]byte
+	// whether a block may be modified in place, blocks shared with a clone
+	// are copied first
+	owned map[int64]bool
+	size  int64
+}
+
+// newChunks returns the content data, which it takes ownership of. The
+// blocks are slices of data, it is not copied.
+func newChunks(data []byte) chunks {
+	c := chunks{
+		blocks: make(map[int64][]byte),
+		owned:  make(map[int64]bool),
+		size:   int64(len(data)),
+	}
+	for off := 0; off < len(data); off += chunkSize {
+		end := off + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		i := int64(off / chunkSize)
+		c.blocks[i] = data[off:end:end]
+		c.owned[i] = true
+	}
+	return c
+}
+
+// share returns a copy of c sharing its blocks, c and the copy both copy
+// a block before modifying it.
+func (c *chunks) share() chunks {
+	s := chunks{
+		blocks: make(map[int64][]byte, len(c.blocks)),
+		owned:  make(map[int64]bool),
+		size:   c.size,
+	}
+	for i, blk := range c.blocks {
+		s.blocks[i] = blk
+	}
+	c.owned = make(map[int64]bool)
+	return s
+}
+
+// bytes returns the whole content as one slice.
+func (c *chunks) bytes() []byte {
+	b := make([]byte, c.size)
+	c.readAt(b, 0)
+	return b
+}
+
+// readAt copies the content from off to b and returns the number of bytes
+// copied.
+func (c *chunks) readAt(b []byte, off int64) int {
+	if off >= c.size {
+		return 0
+	}
+	if rest := c.size - off; int64(len(b)) > rest {
+		b = b[:rest]
+	}
+	n := len(b)
+	for len(b) > 0 {
+		i, o := off/chunkSize, int(off%chunkSize)
+		take := chunkSize - o
+		if take > len(b) {
+			take = len(b)
+		}
+		copied := 0
+		if blk := c.blocks[i]; o < len(blk) {
+			copied = copy(b[:take], blk[o:])
+		}
+		clearBytes(b[copied:take])
+		b = b[take:]
+		off += int64(take)
+	}
+	return n
+}
+
+// writeAt copies b to the content at off, extending it if off is past the
+// end.
+func (c *chunks) writeAt(b []byte, off int64) {
+	if len(b) == 0 {
+		return
+	}
+	if end := off + int64(len(b)); end > c.size {
+		c.truncate(end)
+	}
+	for len(b) > 0 {
+		i, o := off/chunkSize, int(off%chunkSize)
+		take := chunkSize - o
+		if take > len(b) {
+			take = len(b)
+		}
+		copy(c.block(i, o+take)[o:], b[:take])
+		b = b[take:]
+		off += int64(take)
+	}
+}
+
+// block returns the block i, owned and at least n bytes long, for writing.
+// A hole is allocated.
+func (c *chunks) block(i int64, n int) []byte {
+	if c.blocks == nil {
+		c.blocks, c.owned = make(map[int64][]byte), make(map[int64]bool)
+	}
+	blk := c.blocks[i]
+	if !c.owned[i] || n > cap(blk) {
+		// grow small files gradually, a block is never larger than chunkSize
+		size := 2 * cap(blk)
+		if size < n {
+			size = n
+		}
+		if size > chunkSize {
+			size = chunkSize
+		}
+		grown := make([]byte, len(blk), size)
+		copy(grown, blk)
+		blk = grown
+		c.owned[i] = true
+	}
+	if n > len(blk) {
+		// the capacity past the length may hold data of a previous truncation
+		old := len(blk)
+		blk = blk[:n]
+		clearBytes(blk[old:])
+	}
+	c.blocks[i] = blk
+	return blk
+}
+
+// truncate changes the size of the content, an extension is a hole.
+func (c *chunks) truncate(size int64) {
+	if size < c.size {
+		n := (size + chunkSize - 1) / chunkSize
+		for i := range c.blocks {
+			if i >= n {
+				delete(c.blocks, i)
+				delete(c.owned, i)
+			}
+		}
+		// the bytes cut from the last block must not show up again
+		if last := int(size - (n-1)*chunkSize); n > 0 && last < len(c.blocks[n-1]) {
+			c.blocks[n-1] = c.blocks[n-1][:last]
+		}
+	}
+	c.size = size
+}
+
+// writeTo writes the whole content to w.
+func (c *chunks) writeTo(w io.Writer) error {
+	for i := int64(0); i*chunkSize < c.size; i++ {
+		n := c.size - i*chunkSize
+		if n > chunkSize {
+			n = chunkSize
+		}
+		blk := c.blocks[i]
+		if int64(len(blk)) > n {
+			blk = blk[:n]
+		}
+		if _, err := w.Write(blk); err != nil {
+			return err
+		}
+		if _, err := w.Write(zeroChunk[:n-int64(len(blk))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clearBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
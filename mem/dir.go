@@ -22,7 +22,10 @@ type Dir interface {
 }
 
 func RemoveFromMemDir(dir *FileData, f *FileData) {
-	dir.memDir.Remove(f)
+	// the parent may have been replaced by a file since f was added
+	if dir.memDir != nil {
+		dir.memDir.Remove(f)
+	}
 }
 
 func AddToMemDir(dir *FileData, f *FileData) {
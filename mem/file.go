@@ -15,13 +15,13 @@
 package mem
 
 import (
-	"bytes"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"syscall"
 )
 
 import "time"
@@ -30,11 +30,21 @@ const FilePathSeparator = string(filepath.Separator)
 
 type File struct {
 	// atomic requires 64-bit alignment for struct field access
-	at           int64
 	readDirCount int64
-	closed       bool
-	readOnly     bool
-	fileData     *FileData
+	// mu guards the offset, which is private to the handle
+	mu        sync.Mutex
+	at        int64
+	closed    bool
+	readOnly  bool
+	writeOnly bool
+	// every write goes to the end of the file, as with O_APPEND
+	appendOnly bool
+	fileData   *FileData
+	// the content was modified through the handle since it was opened
+	written      bool
+	onCloseWrite func()
+	// set once f has used an advisory lock, see lock.go
+	locking int32
 }
 
 func NewFileHandle(data *FileData) *File {
@@ -45,18 +55,49 @@ func NewReadOnlyFileHandle(data *FileData) *File {
 	return &File{fileData: data, readOnly: true}
 }
 
-func (f File) Data() *FileData {
+// NewFileHandleFlag returns a handle on data opened with the access mode
+// and the O_APPEND flag of flag, as passed to os.OpenFile.
+func NewFileHandleFlag(data *FileData, flag int) *File {
+	f := &File{fileData: data, appendOnly: flag&os.O_APPEND != 0}
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		f.readOnly = true
+	case os.O_WRONLY:
+		f.writeOnly = true
+	}
+	return f
+}
+
+func (f *File) Data() *FileData {
 	return f.fileData
 }
 
+// FileData is a named entry of the filesystem. Entries created by Link share
+// their content, mode and times (the inode) with the entry they were linked
+// to, only the name is their own.
 type FileData struct {
+	*inode
+	name string
+}
+
+type inode struct {
 	sync.Mutex
-	name    string
-	data    []byte
+	ino     uint64
+	nlink   uint64
+	data    chunks
 	memDir  Dir
 	dir     bool
 	mode    os.FileMode
 	modtime time.Time
+	uid     int
+	gid     int
+}
+
+// the last inode number handed out
+var lastIno uint64
+
+func newInode() *inode {
+	return &inode{ino: atomic.AddUint64(&lastIno, 1), nlink: 1}
 }
 
 func (d FileData) Name() string {
@@ -64,11 +105,147 @@ func (d FileData) Name() string {
 }
 
 func CreateFile(name string) *FileData {
-	return &FileData{name: name, mode: os.ModeTemporary, modtime: time.Now()}
+	f := &FileData{inode: newInode(), name: name}
+	f.mode = os.ModeTemporary
+	f.modtime = time.Now()
+	return f
 }
 
 func CreateDir(name string) *FileData {
-	return &FileData{name: name, memDir: &DirMap{}, dir: true}
+	f := &FileData{inode: newInode(), name: name}
+	f.memDir = &DirMap{}
+	f.dir = true
+	return f
+}
+
+// CreateSymlink creates a symbolic link node pointing to target. Like on most
+// real filesystems, the target is kept as the content of the link.
+func CreateSymlink(name string, target string) *FileData {
+	f := &FileData{inode: newInode(), name: name}
+	f.data = newChunks([]byte(target))
+	f.mode = os.ModeSymlink | 0777
+	f.modtime = time.Now()
+	return f
+}
+
+// Link creates a new entry called name sharing the content of f and
+// increments the link count.
+func Link(f *FileData, name string) *FileData {
+	f.Lock()
+	f.nlink++
+	f.Unlock()
+	return &FileData{inode: f.inode, name: name}
+}
+
+// Unlink decrements the link count of f, it is called when the entry is
+// removed from the filesystem.
+func Unlink(f *FileData) {
+	f.Lock()
+	if f.nlink > 0 {
+		f.nlink--
+	}
+	f.Unlock()
+}
+
+// Cloner copies entries for a snapshot of a filesystem. The clones share
+// the blocks of their content with the originals until either of them
+// modifies a block, and entries which are hard links to each other are
+// cloned to hard links.
+type Cloner struct {
+	inodes map[*inode]*inode
+	files  map[*FileData]*FileData
+}
+
+func NewCloner() *Cloner {
+	return &Cloner{inodes: make(map[*inode]*inode), files: make(map[*FileData]*FileData)}
+}
+
+// Clone returns the copy of f. Directories are cloned empty, use
+// CloneDirs once all entries are cloned.
+func (c *Cloner) Clone(f *FileData) *FileData {
+	if clone, ok := c.files[f]; ok {
+		return clone
+	}
+	in, ok := c.inodes[f.inode]
+	if !ok {
+		f.Lock()
+		in = &inode{
+			ino:     f.ino,
+			nlink:   f.nlink,
+			data:    f.data.share(),
+			dir:     f.dir,
+			mode:    f.mode,
+			modtime: f.modtime,
+			uid:     f.uid,
+			gid:     f.gid,
+		}
+		if f.memDir != nil {
+			in.memDir = &DirMap{}
+		}
+		f.Unlock()
+		c.inodes[f.inode] = in
+	}
+	clone := &FileData{inode: in, name: f.name}
+	c.files[f] = clone
+	return clone
+}
+
+// CloneDirs fills the cloned directories with the clones of their entries.
+// Entries which were not cloned are left out.
+func (c *Cloner) CloneDirs() {
+	for f, clone := range c.files {
+		if f.memDir == nil || clone.memDir == nil {
+			continue
+		}
+		f.Lock()
+		for _, child := range f.memDir.Files() {
+			if childClone, ok := c.files[child]; ok {
+				clone.memDir.Add(childClone)
+			}
+		}
+		f.Unlock()
+	}
+}
+
+// size returns the length of the content of f, which must be locked.
+func (f *FileData) size() int64 {
+	return f.data.size
+}
+
+// resize truncates or extends the content of f with a hole. It must be
+// called with f locked.
+func (f *FileData) resize(size int64) {
+	f.data.truncate(size)
+}
+
+// readAt copies the content of f from off to b and returns the number of
+// bytes copied. It must be called with f locked.
+func (f *FileData) readAt(b []byte, off int64) int {
+	return f.data.readAt(b, off)
+}
+
+// writeAt copies b to the content of f at off, extending it with a hole if
+// off is past the end. It must be called with f locked.
+func (f *FileData) writeAt(b []byte, off int64) {
+	f.data.writeAt(b, off)
+}
+
+// SameFile reports whether f1 and f2 share the same content, i.e. one is a
+// hard link of the other.
+func SameFile(f1, f2 *FileData) bool {
+	return f1.inode == f2.inode
+}
+
+// IsSymlink reports whether f is a symbolic link node.
+func IsSymlink(f *FileData) bool {
+	return f.mode&os.ModeSymlink != 0
+}
+
+// ReadLink returns the target of the symbolic link node f.
+func ReadLink(f *FileData) string {
+	f.Lock()
+	defer f.Unlock()
+	return string(f.data.bytes())
 }
 
 func ChangeFileName(f *FileData, newname string) {
@@ -79,16 +256,39 @@ func SetMode(f *FileData, mode os.FileMode) {
 	f.mode = mode
 }
 
+// SetOwner sets the user and group owning f.
+func SetOwner(f *FileData, uid, gid int) {
+	f.Lock()
+	f.uid, f.gid = uid, gid
+	f.Unlock()
+}
+
 func SetModTime(f *FileData, mtime time.Time) {
 	f.modtime = mtime
 }
 
+// SetData replaces the content of f with data, which f takes ownership of.
+func SetData(f *FileData, data []byte) {
+	f.Lock()
+	f.data = newChunks(data)
+	f.Unlock()
+}
+
+// WriteData writes the content of f to w.
+func WriteData(w io.Writer, f *FileData) error {
+	f.Lock()
+	defer f.Unlock()
+	return f.data.writeTo(w)
+}
+
 func GetFileInfo(f *FileData) *FileInfo {
 	return &FileInfo{f}
 }
 
 func (f *File) Open() error {
-	atomic.StoreInt64(&f.at, 0)
+	f.mu.Lock()
+	f.at = 0
+	f.mu.Unlock()
 	atomic.StoreInt64(&f.readDirCount, 0)
 	f.fileData.Lock()
 	f.closed = false
@@ -102,10 +302,22 @@ func (f *File) Close() error {
 	if !f.readOnly {
 		SetModTime(f.fileData, time.Now())
 	}
+	written := f.written
+	f.written = false
 	f.fileData.Unlock()
+	f.closeLock()
+	if written && f.onCloseWrite != nil {
+		f.onCloseWrite()
+	}
 	return nil
 }
 
+// NotifyCloseWrite makes Close call fn if the content of the file was
+// modified through f since it was opened.
+func (f *File) NotifyCloseWrite(fn func()) {
+	f.onCloseWrite = fn
+}
+
 func (f *File) Name() string {
 	return f.fileData.name
 }
@@ -122,6 +334,10 @@ func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
 	var outLength int64
 
 	f.fileData.Lock()
+	if !f.fileData.dir {
+		f.fileData.Unlock()
+		return nil, &os.PathError{Op: "readdir", Path: f.fileData.name, Err: syscall.ENOTDIR}
+	}
 	files := f.fileData.memDir.Files()[f.readDirCount:]
 	if count > 0 {
 		if len(files) < count {
@@ -156,93 +372,131 @@ func (f *File) Readdirnames(n int) (names []string, err error) {
 }
 
 func (f *File) Read(b []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err = f.readAt("read", b, f.at)
+	f.at += int64(n)
+	if err == io.EOF && n > 0 {
+		// like os.File, a short read only reports the end on the next call
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from off without moving the offset of f. It
+// returns io.EOF if fewer bytes were read.
+func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, &os.PathError{"readat", f.fileData.name, errors.New("negative offset")}
+	}
+	return f.readAt("readat", b, off)
+}
+
+func (f *File) readAt(op string, b []byte, off int64) (int, error) {
 	f.fileData.Lock()
 	defer f.fileData.Unlock()
-	if f.closed == true {
+	if f.closed {
 		return 0, ErrFileClosed
 	}
-	if len(b) > 0 && int(f.at) == len(f.fileData.data) {
-		return 0, io.EOF
+	if f.writeOnly {
+		return 0, &os.PathError{op, f.fileData.name, errors.New("file handle is write only")}
 	}
-	if len(f.fileData.data)-int(f.at) >= len(b) {
-		n = len(b)
-	} else {
-		n = len(f.fileData.data) - int(f.at)
+	n := f.fileData.readAt(b, off)
+	if n < len(b) {
+		return n, io.EOF
 	}
-	copy(b, f.fileData.data[f.at:f.at+int64(n)])
-	atomic.AddInt64(&f.at, int64(n))
-	return
-}
-
-func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
-	atomic.StoreInt64(&f.at, off)
-	return f.Read(b)
+	return n, nil
 }
 
 func (f *File) Truncate(size int64) error {
-	if f.closed == true {
+	if size < 0 {
+		return ErrOutOfRange
+	}
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	if f.closed {
 		return ErrFileClosed
 	}
 	if f.readOnly {
 		return &os.PathError{"truncate", f.fileData.name, errors.New("file handle is read only")}
 	}
-	if size < 0 {
-		return ErrOutOfRange
-	}
-	if size > int64(len(f.fileData.data)) {
-		diff := size - int64(len(f.fileData.data))
-		f.fileData.data = append(f.fileData.data, bytes.Repeat([]byte{00}, int(diff))...)
-	} else {
-		f.fileData.data = f.fileData.data[0:size]
-	}
+	f.written = true
+	f.fileData.resize(size)
 	SetModTime(f.fileData, time.Now())
 	return nil
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
-	if f.closed == true {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fileData.Lock()
+	closed, size := f.closed, f.fileData.size()
+	f.fileData.Unlock()
+	if closed {
 		return 0, ErrFileClosed
 	}
 	switch whence {
-	case 0:
-		atomic.StoreInt64(&f.at, offset)
-	case 1:
-		atomic.AddInt64(&f.at, int64(offset))
-	case 2:
-		atomic.StoreInt64(&f.at, int64(len(f.fileData.data))+offset)
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.at
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, &os.PathError{"seek", f.fileData.name, syscall.EINVAL}
 	}
-	return f.at, nil
+	if offset < 0 {
+		return 0, &os.PathError{"seek", f.fileData.name, syscall.EINVAL}
+	}
+	f.at = offset
+	return offset, nil
 }
 
+// Write writes b at the offset of f, or at the end of the file if f was
+// opened with O_APPEND, and moves the offset past the written bytes.
 func (f *File) Write(b []byte) (n int, err error) {
-	if f.readOnly {
-		return 0, &os.PathError{"write", f.fileData.name, errors.New("file handle is read only")}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end, err := f.writeAt("write", b, f.at, f.appendOnly)
+	if err != nil {
+		return 0, err
+	}
+	f.at = end
+	return len(b), nil
+}
+
+// WriteAt writes b at off without moving the offset of f.
+func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
+	if f.appendOnly {
+		return 0, &os.PathError{"writeat", f.fileData.name, errors.New("file handle is append only")}
 	}
-	n = len(b)
-	cur := atomic.LoadInt64(&f.at)
+	if off < 0 {
+		return 0, &os.PathError{"writeat", f.fileData.name, errors.New("negative offset")}
+	}
+	if _, err := f.writeAt("writeat", b, off, false); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeAt writes b at off, or at the end of the file if atEnd is set, and
+// returns the offset following the written bytes.
+func (f *File) writeAt(op string, b []byte, off int64, atEnd bool) (int64, error) {
 	f.fileData.Lock()
 	defer f.fileData.Unlock()
-	diff := cur - int64(len(f.fileData.data))
-	var tail []byte
-	if n+int(cur) < len(f.fileData.data) {
-		tail = f.fileData.data[n+int(cur):]
+	if f.closed {
+		return 0, ErrFileClosed
 	}
-	if diff > 0 {
-		f.fileData.data = append(bytes.Repeat([]byte{00}, int(diff)), b...)
-		f.fileData.data = append(f.fileData.data, tail...)
-	} else {
-		f.fileData.data = append(f.fileData.data[:cur], b...)
-		f.fileData.data = append(f.fileData.data, tail...)
+	if f.readOnly {
+		return 0, &os.PathError{op, f.fileData.name, errors.New("file handle is read only")}
+	}
+	if atEnd {
+		// the end is found under the lock, concurrent appends do not overlap
+		off = f.fileData.size()
 	}
+	f.written = true
+	f.fileData.writeAt(b, off)
 	SetModTime(f.fileData, time.Now())
-
-	atomic.StoreInt64(&f.at, int64(len(f.fileData.data)))
-	return
-}
-
-func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
-	atomic.StoreInt64(&f.at, off)
-	return f.Write(b)
+	return off + int64(len(b)), nil
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
@@ -265,12 +519,26 @@ func (s *FileInfo) Name() string {
 func (s *FileInfo) Mode() os.FileMode  { return s.mode }
 func (s *FileInfo) ModTime() time.Time { return s.modtime }
 func (s *FileInfo) IsDir() bool        { return s.dir }
-func (s *FileInfo) Sys() interface{}   { return nil }
+func (s *FileInfo) Sys() interface{} {
+	s.Lock()
+	defer s.Unlock()
+	return &Stat{Ino: s.ino, Nlink: s.nlink, Uid: s.uid, Gid: s.gid}
+}
 func (s *FileInfo) Size() int64 {
 	if s.IsDir() {
 		return int64(42)
 	}
-	return int64(len(s.data))
+	s.Lock()
+	defer s.Unlock()
+	return s.size()
+}
+
+// Stat is the underlying data source of FileInfo, returned by Sys().
+type Stat struct {
+	Ino   uint64 // inode number, stable for the lifetime of the file
+	Nlink uint64 // number of hard links
+	Uid   int    // user owning the file
+	Gid   int    // group owning the file
 }
 
 var (
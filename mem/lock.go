@@ -0,0 +1,142 @@
+package mem
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// The advisory locks of all files. Like with flock(2), a lock is held by a
+// handle, on the content shared by all hard links of the file.
+var locks struct {
+	sync.Mutex
+	cond sync.Cond
+	held map[*inode]*fileLock
+}
+
+func init() {
+	locks.cond.L = &locks.Mutex
+	locks.held = make(map[*inode]*fileLock)
+}
+
+type fileLock struct {
+	exclusive *File
+	shared    map[*File]bool
+}
+
+// Lock places an exclusive lock on the file, waiting for the other handles
+// to release theirs. A lock held by f is converted.
+func (f *File) Lock() error {
+	_, err := f.lock(true, true)
+	return err
+}
+
+// RLock places a shared lock on the file, waiting for an exclusive lock of
+// another handle to be released. A lock held by f is converted.
+func (f *File) RLock() error {
+	_, err := f.lock(false, true)
+	return err
+}
+
+// TryLock is like Lock but reports false instead of waiting. On failure,
+// a lock already held by f is kept.
+func (f *File) TryLock() (bool, error) {
+	return f.lock(true, false)
+}
+
+// TryRLock is like RLock but reports false instead of waiting. On failure,
+// a lock already held by f is kept.
+func (f *File) TryRLock() (bool, error) {
+	return f.lock(false, false)
+}
+
+// Unlock releases the lock held by f, if any. Close releases it as well.
+func (f *File) Unlock() error {
+	if f.isClosed() {
+		return ErrFileClosed
+	}
+	locks.Lock()
+	defer locks.Unlock()
+	f.releaseLock()
+	return nil
+}
+
+func (f *File) isClosed() bool {
+	f.fileData.Lock()
+	defer f.fileData.Unlock()
+	return f.closed
+}
+
+func (f *File) lock(exclusive, wait bool) (bool, error) {
+	atomic.StoreInt32(&f.locking, 1)
+	locks.Lock()
+	defer locks.Unlock()
+
+	in := f.fileData.inode
+	if l := locks.held[in]; l != nil && (exclusive && l.exclusive == f || !exclusive && l.shared[f]) {
+		return true, nil
+	}
+	if wait {
+		// like flock, converting a lock releases it first, so two handles
+		// upgrading their shared locks do not wait for each other
+		f.releaseLock()
+	}
+	for {
+		if f.isClosed() {
+			return false, ErrFileClosed
+		}
+		l := locks.held[in]
+		if l == nil {
+			l = &fileLock{shared: make(map[*File]bool)}
+			locks.held[in] = l
+		}
+		others := len(l.shared)
+		if l.shared[f] {
+			others--
+		}
+		if l.exclusive == nil || l.exclusive == f {
+			if !exclusive || others == 0 {
+				if exclusive {
+					delete(l.shared, f)
+					l.exclusive = f
+				} else {
+					l.exclusive = nil
+					l.shared[f] = true
+				}
+				return true, nil
+			}
+		}
+		if !wait {
+			return false, nil
+		}
+		locks.cond.Wait()
+	}
+}
+
+// releaseLock releases the lock of f, it must be called with locks held.
+func (f *File) releaseLock() {
+	in := f.fileData.inode
+	l := locks.held[in]
+	if l == nil {
+		return
+	}
+	if l.exclusive == f {
+		l.exclusive = nil
+	}
+	delete(l.shared, f)
+	if l.exclusive == nil && len(l.shared) == 0 {
+		delete(locks.held, in)
+	}
+	locks.cond.Broadcast()
+}
+
+// closeLock releases the lock of the closed handle f and wakes up its
+// pending Lock calls.
+func (f *File) closeLock() {
+	if atomic.LoadInt32(&f.locking) == 0 {
+		return
+	}
+	locks.Lock()
+	f.releaseLock()
+	locks.cond.Broadcast()
+	locks.Unlock()
+}
@@ -15,11 +15,11 @@ package afero
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/afero/mem"
@@ -27,8 +27,13 @@ import (
 
 type MemMapFs struct {
 	mu   sync.RWMutex
-	data map[string]*mem.FileData
+	data *memRadix
 	init sync.Once
+	// the credentials permissions are checked against, nil if they are not
+	cred *Credentials
+
+	watchMu sync.RWMutex
+	watches map[*memWatch]struct{}
 }
 
 func NewMemMapFs() Fs {
@@ -37,12 +42,14 @@ func NewMemMapFs() Fs {
 
 var memfsInit sync.Once
 
-func (m *MemMapFs) getData() map[string]*mem.FileData {
+func (m *MemMapFs) getData() *memRadix {
 	m.init.Do(func() {
-		m.data = make(map[string]*mem.FileData)
+		m.data = newMemRadix()
 		// Root should always exist, right?
 		// TODO: what about windows?
-		m.data[FilePathSeparator] = mem.CreateDir(FilePathSeparator)
+		root := mem.CreateDir(FilePathSeparator)
+		mem.SetMode(root, os.ModeDir|0755)
+		m.data.Insert(FilePathSeparator, root)
 	})
 	return m.data
 }
@@ -50,13 +57,7 @@ func (m *MemMapFs) getData() map[string]*mem.FileData {
 func (MemMapFs) Name() string { return "MemMapFS" }
 
 func (m *MemMapFs) Create(name string) (File, error) {
-	name = normalizePath(name)
-	m.mu.Lock()
-	file := mem.CreateFile(name)
-	m.getData()[name] = file
-	m.registerWithParent(file)
-	m.mu.Unlock()
-	return mem.NewFileHandle(file), nil
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 }
 
 func (m *MemMapFs) unRegisterWithParent(fileName string) error {
@@ -66,7 +67,7 @@ func (m *MemMapFs) unRegisterWithParent(fileName string) error {
 	}
 	parent := m.findParent(f)
 	if parent == nil {
-		log.Fatal("parent of ", f.Name(), " is nil")
+		return &os.PathError{Op: "unregister", Path: f.Name(), Err: os.ErrNotExist}
 	}
 	mem.RemoveFromMemDir(parent, f)
 	return nil
@@ -107,7 +108,7 @@ func (m *MemMapFs) registerWithParent(f *mem.FileData) {
 
 func (m *MemMapFs) lockfreeMkdir(name string, perm os.FileMode) error {
 	name = normalizePath(name)
-	x, ok := m.getData()[name]
+	x, ok := m.getData().Get(name)
 	if ok {
 		// Only return ErrFileExists if it's a file, not a directory.
 		i := mem.FileInfo{x}
@@ -116,27 +117,37 @@ func (m *MemMapFs) lockfreeMkdir(name string, perm os.FileMode) error {
 		}
 	} else {
 		item := mem.CreateDir(name)
-		m.getData()[name] = item
+		mem.SetMode(item, os.ModeDir|perm)
+		m.getData().Insert(name, item)
 		m.registerWithParent(item)
+		m.lockfreeSetOwner(item)
+		m.notify(name, WatchCreate)
 	}
 	return nil
 }
 
 func (m *MemMapFs) Mkdir(name string, perm os.FileMode) error {
-	name = normalizePath(name)
-
-	m.mu.RLock()
-	_, ok := m.getData()[name]
-	m.mu.RUnlock()
-	if ok {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, err := m.lockfreeResolve(name, false)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if err := m.lockfreeCheckSearch(name); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if _, ok := m.getData().Get(name); ok {
 		return &os.PathError{"mkdir", name, ErrFileExists}
-	} else {
-		m.mu.Lock()
-		item := mem.CreateDir(name)
-		m.getData()[name] = item
-		m.registerWithParent(item)
-		m.mu.Unlock()
 	}
+	if err := m.lockfreeCheckCreate(name); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	item := mem.CreateDir(name)
+	mem.SetMode(item, os.ModeDir|perm)
+	m.getData().Insert(name, item)
+	m.registerWithParent(item)
+	m.lockfreeSetOwner(item)
+	m.notify(name, WatchCreate)
 	return nil
 }
 
@@ -167,36 +178,75 @@ func normalizePath(path string) string {
 }
 
 func (m *MemMapFs) Open(name string) (File, error) {
-	f, err := m.open(name)
+	f, err := m.open(name, accessRead)
 	if f != nil {
 		return mem.NewReadOnlyFileHandle(f), err
 	}
 	return nil, err
 }
 
-func (m *MemMapFs) openWrite(name string) (File, error) {
-	f, err := m.open(name)
-	if f != nil {
-		return mem.NewFileHandle(f), err
+// open returns the named file after checking the permissions want on it.
+func (m *MemMapFs) open(name string, want os.FileMode) (*mem.FileData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	name, err := m.lockfreeResolve(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
 	}
-	return nil, err
+	if err := m.lockfreeCheckSearch(name); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f, ok := m.getData().Get(name)
+	if !ok {
+		return nil, &os.PathError{"open", name, ErrFileNotFound}
+	}
+	if err := m.lockfreeCheckAccess(f, want); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
 }
 
-func (m *MemMapFs) open(name string) (*mem.FileData, error) {
+// lockfreeResolve returns the name with all symbolic links in its directory
+// components replaced by their targets. The last component is only resolved
+// if followLast is set.
+func (m *MemMapFs) lockfreeResolve(name string, followLast bool) (string, error) {
 	name = normalizePath(name)
+	for hops := 0; hops <= maxSymlinkHops; hops++ {
+		link, rest, ok := m.lockfreeFindSymlink(name, followLast)
+		if !ok {
+			return name, nil
+		}
+		target := mem.ReadLink(link)
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(link.Name()), target)
+		}
+		name = normalizePath(filepath.Join(target, rest))
+	}
+	return name, syscall.ELOOP
+}
 
-	m.mu.RLock()
-	f, ok := m.getData()[name]
-	m.mu.RUnlock()
-	if !ok {
-		return nil, &os.PathError{"open", name, ErrFileNotFound}
+// lockfreeFindSymlink returns the first symbolic link found on the path of
+// name and the remainder of the path below it.
+func (m *MemMapFs) lockfreeFindSymlink(name string, followLast bool) (*mem.FileData, string, bool) {
+	for i := 1; i < len(name); i++ {
+		if name[i] != filepath.Separator {
+			continue
+		}
+		if f, ok := m.getData().Get(name[:i]); ok && mem.IsSymlink(f) {
+			return f, name[i+1:], true
+		}
 	}
-	return f, nil
+	if followLast {
+		if f, ok := m.getData().Get(name); ok && mem.IsSymlink(f) {
+			return f, "", true
+		}
+	}
+	return nil, "", false
 }
 
 func (m *MemMapFs) lockfreeOpen(name string) (*mem.FileData, error) {
 	name = normalizePath(name)
-	f, ok := m.getData()[name]
+	f, ok := m.getData().Get(name)
 	if ok {
 		return f, nil
 	} else {
@@ -204,46 +254,89 @@ func (m *MemMapFs) lockfreeOpen(name string) (*mem.FileData, error) {
 	}
 }
 
+// OpenFile opens the named file with the flags of os.OpenFile. The file is
+// looked up, created and truncated at once, so two calls with O_EXCL never
+// both succeed.
 func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
-	file, err := m.openWrite(name)
-	if os.IsNotExist(err) && (flag&os.O_CREATE > 0) {
-		file, err = m.Create(name)
+	want := accessRead
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:
+		want = accessWrite
+	case os.O_RDWR:
+		want = accessRead | accessWrite
 	}
+	excl := flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// like open(2), O_EXCL does not follow a symbolic link, even a dangling one
+	name, err := m.lockfreeResolve(name, !excl)
 	if err != nil {
-		return nil, err
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
 	}
-	if flag == os.O_RDONLY {
-		file = mem.NewReadOnlyFileHandle(file.(*mem.File).Data())
+	if err := m.lockfreeCheckSearch(name); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
 	}
-	if flag&os.O_APPEND > 0 {
-		_, err = file.Seek(0, os.SEEK_END)
-		if err != nil {
-			file.Close()
-			return nil, err
+	name = normalizePath(name)
+	file, ok := m.getData().Get(name)
+	switch {
+	case ok && excl:
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileExists}
+	case ok:
+		if err := m.lockfreeCheckAccess(file, want); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		if want&accessWrite != 0 && mem.GetFileInfo(file).IsDir() {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
 		}
+	case flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileNotFound}
+	default:
+		if err := m.lockfreeCheckCreate(name); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		file = mem.CreateFile(name)
+		mem.SetMode(file, perm)
+		m.lockfreeSetOwner(file)
+		m.getData().Insert(name, file)
+		m.registerWithParent(file)
+		m.notify(name, WatchCreate)
 	}
-	if flag&os.O_TRUNC > 0 && flag&(os.O_RDWR|os.O_WRONLY) > 0 {
-		err = file.Truncate(0)
-		if err != nil {
-			file.Close()
+
+	h := mem.NewFileHandleFlag(file, flag)
+	if want&accessWrite == 0 {
+		return h, nil
+	}
+	m.watchWrites(h)
+	if flag&os.O_TRUNC != 0 {
+		if err := h.Truncate(0); err != nil {
+			h.Close()
 			return nil, err
 		}
 	}
-	return file, nil
+	return h, nil
 }
 
 func (m *MemMapFs) Remove(name string) error {
-	name = normalizePath(name)
-
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, ok := m.getData()[name]; ok {
+	name, err := m.lockfreeResolve(name, false)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	if f, ok := m.getData().Get(name); ok {
+		if err := m.lockfreeCheckRemove(name, f); err != nil {
+			return &os.PathError{Op: "remove", Path: name, Err: err}
+		}
 		err := m.unRegisterWithParent(name)
 		if err != nil {
 			return &os.PathError{"remove", name, err}
 		}
-		delete(m.getData(), name)
+		m.getData().Delete(name)
+		mem.Unlink(f)
+		m.notify(name, WatchRemove)
 	} else {
 		return &os.PathError{"remove", name, os.ErrNotExist}
 	}
@@ -251,95 +344,291 @@ func (m *MemMapFs) Remove(name string) error {
 }
 
 func (m *MemMapFs) RemoveAll(path string) error {
-	path = normalizePath(path)
 	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, err := m.lockfreeResolve(path, false)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+	root, isRoot := m.getData().Get(path)
+	if isRoot {
+		if err := m.lockfreeCheckRemoveAll(path); err != nil {
+			return &os.PathError{Op: "remove", Path: path, Err: err}
+		}
+	}
+	isRoot = isRoot && path == FilePathSeparator
 	m.unRegisterWithParent(path)
-	m.mu.Unlock()
-
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	for p, _ := range m.getData() {
-		if strings.HasPrefix(p, path) {
-			m.mu.RUnlock()
-			m.mu.Lock()
-			delete(m.getData(), p)
-			m.mu.Unlock()
-			m.mu.RLock()
+	m.getData().DeleteTree(path, func(name string, f *mem.FileData) {
+		mem.Unlink(f)
+		if name != FilePathSeparator {
+			m.notify(name, WatchRemove)
 		}
+	})
+	if isRoot {
+		// the root always exists
+		newRoot := mem.CreateDir(FilePathSeparator)
+		mem.SetMode(newRoot, mem.GetFileInfo(root).Mode())
+		m.getData().Insert(FilePathSeparator, newRoot)
 	}
 	return nil
 }
 
+// Rename moves the entry oldname, with everything below it if it is a
+// directory, to newname. Like on Unix, a directory can only replace an
+// empty directory, and cannot be moved into its own subtree.
 func (m *MemMapFs) Rename(oldname, newname string) error {
-	oldname = normalizePath(oldname)
-	newname = normalizePath(newname)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldname, err := m.lockfreeResolve(oldname, false)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	newname, err = m.lockfreeResolve(newname, false)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: newname, Err: err}
+	}
 
 	if oldname == newname {
 		return nil
 	}
 
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	if _, ok := m.getData()[oldname]; ok {
-		m.mu.RUnlock()
-		m.mu.Lock()
-		m.unRegisterWithParent(oldname)
-		fileData := m.getData()[oldname]
-		delete(m.getData(), oldname)
-		mem.ChangeFileName(fileData, newname)
-		m.getData()[newname] = fileData
-		m.registerWithParent(fileData)
-		m.mu.Unlock()
-		m.mu.RLock()
-	} else {
-		return &os.PathError{"rename", oldname, ErrFileNotFound}
+	fileData, ok := m.getData().Get(oldname)
+	if !ok {
+		return &os.PathError{Op: "rename", Path: oldname, Err: ErrFileNotFound}
+	}
+	isDir := mem.GetFileInfo(fileData).IsDir()
+	if err := m.lockfreeCheckRename(oldname, newname, fileData); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
 	}
+	if target, ok := m.getData().Get(newname); ok {
+		if mem.SameFile(fileData, target) {
+			// both names are hard links to the same file
+			return nil
+		}
+		targetIsDir := mem.GetFileInfo(target).IsDir()
+		switch {
+		case isDir && !targetIsDir:
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.ENOTDIR}
+		case !isDir && targetIsDir:
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EISDIR}
+		case targetIsDir && m.lockfreeHasChildren(newname):
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.ENOTEMPTY}
+		}
+	}
+	if isDir && strings.HasPrefix(newname, treePrefix(oldname)) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EINVAL}
+	}
+
+	m.unRegisterWithParent(oldname)
+	if target, ok := m.getData().Get(newname); ok {
+		mem.Unlink(target)
+		m.getData().Delete(newname)
+	}
+
+	// the names of the entries below a directory change with it, and so
+	// do their keys in the directories containing them
+	var moved []*mem.FileData
+	m.getData().WalkTree(oldname, func(name string, f *mem.FileData) bool {
+		if name != oldname {
+			if parent, ok := m.getData().Get(filepath.Dir(name)); ok {
+				mem.RemoveFromMemDir(parent, f)
+			}
+		}
+		moved = append(moved, f)
+		return true
+	})
+	m.getData().DeleteTree(oldname, func(string, *mem.FileData) {})
+	for _, f := range moved {
+		name := newname + f.Name()[len(oldname):]
+		mem.ChangeFileName(f, name)
+		m.getData().Insert(name, f)
+	}
+	for _, f := range moved[1:] {
+		if parent, ok := m.getData().Get(filepath.Dir(f.Name())); ok {
+			mem.AddToMemDir(parent, f)
+		}
+	}
+	m.registerWithParent(fileData)
+	m.notify(oldname, WatchRename)
+	m.notify(newname, WatchCreate)
 	return nil
 }
 
+// lockfreeHasChildren reports whether there are entries below the
+// directory name.
+func (m *MemMapFs) lockfreeHasChildren(name string) bool {
+	found := false
+	m.getData().WalkPrefix(treePrefix(name), func(string, *mem.FileData) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
 func (m *MemMapFs) Stat(name string) (os.FileInfo, error) {
-	f, err := m.Open(name)
+	f, err := m.open(name, 0)
 	if err != nil {
 		return nil, err
 	}
-	fi := mem.GetFileInfo(f.(*mem.File).Data())
+	fi := mem.GetFileInfo(f)
 	return fi, nil
 }
 
+const chmodBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
 func (m *MemMapFs) Chmod(name string, mode os.FileMode) error {
-	name = normalizePath(name)
-	f, ok := m.getData()[name]
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, err := m.lockfreeResolve(name, true)
+	if err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	if err := m.lockfreeCheckSearch(name); err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	f, ok := m.getData().Get(name)
 	if !ok {
 		return &os.PathError{"chmod", name, ErrFileNotFound}
 	}
+	if err := m.lockfreeCheckOwner(f); err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	// like os.Chmod, only change the permission bits, not the file type
+	mem.SetMode(f, mem.GetFileInfo(f).Mode()&^chmodBits|mode&chmodBits)
+	m.notify(name, WatchChmod)
+	return nil
+}
 
+func (m *MemMapFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	m.mu.Lock()
-	mem.SetMode(f, mode)
-	m.mu.Unlock()
+	defer m.mu.Unlock()
 
+	name, err := m.lockfreeResolve(name, true)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	if err := m.lockfreeCheckSearch(name); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	f, ok := m.getData().Get(name)
+	if !ok {
+		return &os.PathError{"chtimes", name, ErrFileNotFound}
+	}
+	if err := m.lockfreeCheckOwner(f); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	mem.SetModTime(f, mtime)
+	m.notify(name, WatchChmod)
 	return nil
 }
 
-func (m *MemMapFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	name = normalizePath(name)
-	f, ok := m.getData()[name]
+func (m *MemMapFs) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldname, err := m.lockfreeResolve(oldname, false)
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	newname, err = m.lockfreeResolve(newname, false)
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	if err := m.lockfreeCheckSearch(oldname); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	f, ok := m.getData().Get(oldname)
 	if !ok {
-		return &os.PathError{"chtimes", name, ErrFileNotFound}
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: ErrFileNotFound}
+	}
+	if mem.GetFileInfo(f).IsDir() {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	if _, ok := m.getData().Get(newname); ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: ErrFileExists}
+	}
+	if err := m.lockfreeCheckCreate(newname); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	link := mem.Link(f, newname)
+	m.getData().Insert(newname, link)
+	m.registerWithParent(link)
+	m.notify(newname, WatchCreate)
+	return nil
+}
+
+func (m *MemMapFs) Lstat(name string) (os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name, err := m.lockfreeResolve(name, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	if err := m.lockfreeCheckSearch(name); err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
 	}
+	f, ok := m.getData().Get(name)
+	if !ok {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: ErrFileNotFound}
+	}
+	return mem.GetFileInfo(f), nil
+}
 
+func (m *MemMapFs) Symlink(oldname, newname string) error {
 	m.mu.Lock()
-	mem.SetModTime(f, mtime)
-	m.mu.Unlock()
+	defer m.mu.Unlock()
 
+	newname, err := m.lockfreeResolve(newname, false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if err := m.lockfreeCheckSearch(newname); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if _, ok := m.getData().Get(newname); ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrFileExists}
+	}
+	if err := m.lockfreeCheckCreate(newname); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	link := mem.CreateSymlink(newname, oldname)
+	m.getData().Insert(newname, link)
+	m.registerWithParent(link)
+	m.lockfreeSetOwner(link)
+	m.notify(newname, WatchCreate)
 	return nil
 }
 
+func (m *MemMapFs) Readlink(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name, err := m.lockfreeResolve(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if err := m.lockfreeCheckSearch(name); err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	f, ok := m.getData().Get(name)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: ErrFileNotFound}
+	}
+	if !mem.IsSymlink(f) {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return mem.ReadLink(f), nil
+}
+
 func (m *MemMapFs) List() {
-	for _, x := range m.data {
+	m.getData().WalkPrefix("", func(_ string, x *mem.FileData) bool {
 		y := mem.FileInfo{x}
 		fmt.Println(x.Name(), y.Size())
-	}
+		return true
+	})
 }
 
 func debugMemMapList(fs Fs) {
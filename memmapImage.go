@@ -0,0 +1,329 @@
+package afero
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero/mem"
+)
+
+// A MemMapFs image starts with imageMagic and the format version, both
+// followed by records of the form
+//
+//	kind byte, name, mode uvarint, uid uvarint, gid uvarint,
+//	mtime varint (Unix nanoseconds), payload
+//
+// in lexical order of the names, so directories precede their content. The
+// names are slash separated and written, like all strings, as a uvarint
+// length and the bytes. The payload depends on the kind:
+//
+//	imageDir      none
+//	imageFile     size uvarint, content
+//	imageSymlink  target
+//	imageLink     name of the hard linked file, written before
+//
+// A record of kind imageEnd and the big endian CRC-32 (IEEE) of everything
+// before it close the image. Images of other versions are rejected.
+const (
+	imageMagic   = "AFEROMEM"
+	imageVersion = 2
+)
+
+const (
+	imageEnd     = 0
+	imageDir     = 'd'
+	imageFile    = 'f'
+	imageSymlink = 'l'
+	imageLink    = 'h'
+)
+
+var (
+	ErrImageFormat  = errors.New("not a MemMapFs image or corrupted image")
+	ErrImageVersion = errors.New("unsupported MemMapFs image version")
+)
+
+type imageWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (iw *imageWriter) Write(p []byte) (int, error) {
+	iw.crc.Write(p)
+	return iw.w.Write(p)
+}
+
+func (iw *imageWriter) uvarint(x uint64) error {
+	_, err := iw.Write(iw.buf[:binary.PutUvarint(iw.buf[:], x)])
+	return err
+}
+
+func (iw *imageWriter) string(s string) error {
+	if err := iw.uvarint(uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(iw, s)
+	return err
+}
+
+// WriteImage writes the content of the filesystem, including directories,
+// modes, modification times, symbolic and hard links, to w. The content of
+// each file is streamed as is, without copying it. The filesystem cannot be
+// modified while it is written.
+func (m *MemMapFs) WriteImage(w io.Writer) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	iw := &imageWriter{w: bufio.NewWriter(w), crc: crc32.NewIEEE()}
+	if _, err := io.WriteString(iw, imageMagic); err != nil {
+		return err
+	}
+	if err := iw.uvarint(imageVersion); err != nil {
+		return err
+	}
+	// the first name of each file with hard links
+	linked := make(map[uint64]string)
+	var err error
+	m.getData().WalkPrefix("", func(name string, f *mem.FileData) bool {
+		err = writeImageRecord(iw, linked, name, f)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := iw.Write([]byte{imageEnd}); err != nil {
+		return err
+	}
+	if err := binary.Write(iw.w, binary.BigEndian, iw.crc.Sum32()); err != nil {
+		return err
+	}
+	return iw.w.Flush()
+}
+
+// writeImageRecord writes the record of the entry name.
+func writeImageRecord(iw *imageWriter, linked map[uint64]string, name string, f *mem.FileData) error {
+	fi := mem.GetFileInfo(f)
+	ino := fi.Sys().(*mem.Stat).Ino
+
+	var kind byte
+	switch {
+	case fi.IsDir():
+		kind = imageDir
+	case mem.IsSymlink(f):
+		kind = imageSymlink
+	case linked[ino] != "":
+		kind = imageLink
+	default:
+		kind = imageFile
+		linked[ino] = name
+	}
+	if _, err := iw.Write([]byte{kind}); err != nil {
+		return err
+	}
+	if err := iw.string(filepath.ToSlash(name)); err != nil {
+		return err
+	}
+	if err := iw.uvarint(uint64(fi.Mode())); err != nil {
+		return err
+	}
+	st := fi.Sys().(*mem.Stat)
+	if err := iw.uvarint(uint64(st.Uid)); err != nil {
+		return err
+	}
+	if err := iw.uvarint(uint64(st.Gid)); err != nil {
+		return err
+	}
+	if _, err := iw.Write(iw.buf[:binary.PutVarint(iw.buf[:], fi.ModTime().UnixNano())]); err != nil {
+		return err
+	}
+
+	switch kind {
+	case imageFile:
+		if err := iw.uvarint(uint64(fi.Size())); err != nil {
+			return err
+		}
+		return mem.WriteData(iw, f)
+	case imageSymlink:
+		return iw.string(mem.ReadLink(f))
+	case imageLink:
+		return iw.string(filepath.ToSlash(linked[ino]))
+	}
+	return nil
+}
+
+type imageReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (ir *imageReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	ir.crc.Write(p[:n])
+	return n, err
+}
+
+func (ir *imageReader) ReadByte() (byte, error) {
+	b, err := ir.r.ReadByte()
+	if err == nil {
+		ir.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (ir *imageReader) bytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(ir)
+	if err != nil {
+		return nil, err
+	}
+	// don't trust the length for the allocation, the data is read in
+	// chunks and the buffer grows as needed
+	var b []byte
+	for n > 0 {
+		chunk := n
+		if chunk > 1<<20 {
+			chunk = 1 << 20
+		}
+		b = append(b, make([]byte, chunk)...)
+		if _, err := io.ReadFull(ir, b[len(b)-int(chunk):]); err != nil {
+			return nil, err
+		}
+		n -= chunk
+	}
+	return b, nil
+}
+
+func (ir *imageReader) string() (string, error) {
+	b, err := ir.bytes()
+	return string(b), err
+}
+
+// ReadImage replaces the content of the filesystem with the image read from
+// r, as written by WriteImage. The filesystem is left unchanged if the image
+// cannot be read.
+func (m *MemMapFs) ReadImage(r io.Reader) error {
+	data, err := readImage(&imageReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()})
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrImageFormat
+	}
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getData()
+	m.data = data
+	return nil
+}
+
+func readImage(ir *imageReader) (*memRadix, error) {
+	magic := make([]byte, len(imageMagic))
+	if _, err := io.ReadFull(ir, magic); err != nil || string(magic) != imageMagic {
+		return nil, ErrImageFormat
+	}
+	version, err := binary.ReadUvarint(ir)
+	if err != nil {
+		return nil, err
+	}
+	if version != imageVersion {
+		return nil, fmt.Errorf("%w: %d", ErrImageVersion, version)
+	}
+
+	data := newMemRadix()
+	for {
+		kind, err := ir.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if kind == imageEnd {
+			break
+		}
+		name, err := ir.string()
+		if err != nil {
+			return nil, err
+		}
+		name = normalizePath(filepath.FromSlash(name))
+		mode, err := binary.ReadUvarint(ir)
+		if err != nil {
+			return nil, err
+		}
+		uid, err := binary.ReadUvarint(ir)
+		if err != nil {
+			return nil, err
+		}
+		gid, err := binary.ReadUvarint(ir)
+		if err != nil {
+			return nil, err
+		}
+		mtime, err := binary.ReadVarint(ir)
+		if err != nil {
+			return nil, err
+		}
+
+		var f *mem.FileData
+		switch kind {
+		case imageDir:
+			f = mem.CreateDir(name)
+		case imageFile:
+			content, err := ir.bytes()
+			if err != nil {
+				return nil, err
+			}
+			f = mem.CreateFile(name)
+			mem.SetData(f, content)
+		case imageSymlink:
+			target, err := ir.string()
+			if err != nil {
+				return nil, err
+			}
+			f = mem.CreateSymlink(name, target)
+		case imageLink:
+			oldname, err := ir.string()
+			if err != nil {
+				return nil, err
+			}
+			old, ok := data.Get(normalizePath(filepath.FromSlash(oldname)))
+			if !ok || mem.GetFileInfo(old).IsDir() {
+				return nil, ErrImageFormat
+			}
+			// mode and time are those of the linked file
+			data.Insert(name, mem.Link(old, name))
+			continue
+		default:
+			return nil, ErrImageFormat
+		}
+		mem.SetMode(f, os.FileMode(mode))
+		mem.SetOwner(f, int(uid), int(gid))
+		mem.SetModTime(f, time.Unix(0, mtime))
+		data.Insert(name, f)
+	}
+
+	want := ir.crc.Sum32()
+	var got uint32
+	if err := binary.Read(ir.r, binary.BigEndian, &got); err != nil || got != want {
+		return nil, ErrImageFormat
+	}
+
+	if _, ok := data.Get(FilePathSeparator); !ok {
+		return nil, ErrImageFormat
+	}
+	data.WalkPrefix("", func(name string, f *mem.FileData) bool {
+		if name == FilePathSeparator {
+			return true
+		}
+		// like in the MemMapFs, an entry whose parent is missing is
+		// left detached
+		if parent, ok := data.Get(filepath.Dir(name)); ok && mem.GetFileInfo(parent).IsDir() {
+			mem.AddToMemDir(parent, f)
+		}
+		return true
+	})
+	return data, nil
+}
@@ -0,0 +1,268 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/afero/mem"
+)
+
+// Credentials are the identity a MemMapFs checks permissions against, see
+// MemMapFs.SetCredentials.
+type Credentials struct {
+	Uid    int
+	Gid    int
+	Groups []int // supplementary groups
+}
+
+func (c *Credentials) inGroup(gid int) bool {
+	if c.Gid == gid {
+		return true
+	}
+	for _, g := range c.Groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// The permission bits checked by the MemMapFs, for the owner of a file.
+const (
+	accessRead  os.FileMode = 04
+	accessWrite os.FileMode = 02
+	accessExec  os.FileMode = 01
+)
+
+// SetCredentials makes the filesystem enforce the permissions of its files
+// and directories for a process running as c, failing with EACCES or EPERM
+// like a Unix kernel would: reading, writing and listing need the read and
+// write bits, and every directory on a path needs the execute (search)
+// bit. A Uid of 0 is the superuser, which bypasses the checks. A nil c,
+// the default, turns the checks off.
+//
+// The files, directories and symbolic links created later are owned by
+// c.Uid and c.Gid, or by the superuser without credentials. The
+// permissions are only checked when opening a file, open files keep the
+// access they were opened with.
+func (m *MemMapFs) SetCredentials(c *Credentials) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c != nil {
+		c = &Credentials{Uid: c.Uid, Gid: c.Gid, Groups: append([]int(nil), c.Groups...)}
+	}
+	m.cred = c
+}
+
+// Chown changes the user and group owning the named file, following
+// symbolic links. A uid or gid of -1 is left unchanged. With credentials,
+// only the superuser can give a file away, the owner can only change the
+// group to one of its own groups.
+func (m *MemMapFs) Chown(name string, uid, gid int) error {
+	return m.chown("chown", name, true, uid, gid)
+}
+
+// Lchown is like Chown but changes the owner of a symbolic link itself.
+func (m *MemMapFs) Lchown(name string, uid, gid int) error {
+	return m.chown("lchown", name, false, uid, gid)
+}
+
+func (m *MemMapFs) chown(op, name string, followLast bool, uid, gid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, err := m.lockfreeResolve(name, followLast)
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	if err := m.lockfreeCheckSearch(name); err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	f, ok := m.getData().Get(name)
+	if !ok {
+		return &os.PathError{Op: op, Path: name, Err: ErrFileNotFound}
+	}
+	st := mem.GetFileInfo(f).Sys().(*mem.Stat)
+	if uid == -1 {
+		uid = st.Uid
+	}
+	if gid == -1 {
+		gid = st.Gid
+	}
+	if c := m.cred; c != nil && c.Uid != 0 {
+		if c.Uid != st.Uid || uid != st.Uid || (gid != st.Gid && !c.inGroup(gid)) {
+			return &os.PathError{Op: op, Path: name, Err: syscall.EPERM}
+		}
+	}
+	mem.SetOwner(f, uid, gid)
+	m.notify(name, WatchChmod)
+	return nil
+}
+
+// lockfreeSetOwner gives the new entry f to the current user. Like on BSD
+// and in Linux directories with the setgid bit, the group is inherited
+// from the parent directory.
+func (m *MemMapFs) lockfreeSetOwner(f *mem.FileData) {
+	uid, gid := 0, 0
+	if m.cred != nil {
+		uid, gid = m.cred.Uid, m.cred.Gid
+	}
+	if parent, ok := m.getData().Get(filepath.Dir(f.Name())); ok {
+		if fi := mem.GetFileInfo(parent); fi.Mode()&os.ModeSetgid != 0 {
+			gid = fi.Sys().(*mem.Stat).Gid
+		}
+	}
+	mem.SetOwner(f, uid, gid)
+}
+
+// lockfreeMayAccess reports whether the current user has the permissions
+// want, a combination of the access bits, on f.
+func (m *MemMapFs) lockfreeMayAccess(f *mem.FileData, want os.FileMode) bool {
+	c := m.cred
+	if c == nil || c.Uid == 0 {
+		return true
+	}
+	fi := mem.GetFileInfo(f)
+	st := fi.Sys().(*mem.Stat)
+	perm := fi.Mode().Perm()
+	switch {
+	case c.Uid == st.Uid:
+		perm >>= 6
+	case c.inGroup(st.Gid):
+		perm >>= 3
+	}
+	return perm&want == want
+}
+
+// lockfreeCheckAccess checks the permissions want on f.
+func (m *MemMapFs) lockfreeCheckAccess(f *mem.FileData, want os.FileMode) error {
+	if !m.lockfreeMayAccess(f, want) {
+		return syscall.EACCES
+	}
+	return nil
+}
+
+// lockfreeCheckSearch checks the search permission on the directories
+// leading to the resolved name.
+func (m *MemMapFs) lockfreeCheckSearch(name string) error {
+	if m.cred == nil {
+		return nil
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] != filepath.Separator {
+			continue
+		}
+		dir := name[:i]
+		if i == 0 {
+			dir = FilePathSeparator
+		}
+		f, ok := m.getData().Get(dir)
+		if !ok || !mem.GetFileInfo(f).IsDir() {
+			// the lookup fails anyway
+			return nil
+		}
+		if !m.lockfreeMayAccess(f, accessExec) {
+			return syscall.EACCES
+		}
+	}
+	return nil
+}
+
+// lockfreeCheckCreate checks that the current user can add the entry name,
+// which needs the write and search permissions on the nearest existing
+// directory above it, where it or its missing parents are created.
+func (m *MemMapFs) lockfreeCheckCreate(name string) error {
+	if m.cred == nil {
+		return nil
+	}
+	if err := m.lockfreeCheckSearch(name); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		if f, ok := m.getData().Get(dir); ok {
+			return m.lockfreeCheckAccess(f, accessWrite|accessExec)
+		}
+		if dir == FilePathSeparator {
+			return nil
+		}
+	}
+}
+
+// lockfreeCheckRemove checks that the current user can remove the entry f
+// called name from its directory. In a directory with the sticky bit, only
+// the owners of the entry and of the directory can.
+func (m *MemMapFs) lockfreeCheckRemove(name string, f *mem.FileData) error {
+	if err := m.lockfreeCheckCreate(name); err != nil {
+		return err
+	}
+	c := m.cred
+	if c == nil || c.Uid == 0 {
+		return nil
+	}
+	parent, ok := m.getData().Get(filepath.Dir(name))
+	if !ok || mem.GetFileInfo(parent).Mode()&os.ModeSticky == 0 {
+		return nil
+	}
+	if c.Uid != mem.GetFileInfo(f).Sys().(*mem.Stat).Uid &&
+		c.Uid != mem.GetFileInfo(parent).Sys().(*mem.Stat).Uid {
+		return syscall.EPERM
+	}
+	return nil
+}
+
+// lockfreeCheckRemoveAll checks that the current user can remove the entry
+// name and everything below it, which also needs the read permission on
+// the directories to list them.
+func (m *MemMapFs) lockfreeCheckRemoveAll(name string) error {
+	if m.cred == nil {
+		return nil
+	}
+	var err error
+	m.getData().WalkTree(name, func(key string, f *mem.FileData) bool {
+		if key != name {
+			if parent, ok := m.getData().Get(filepath.Dir(key)); ok {
+				err = m.lockfreeCheckAccess(parent, accessRead)
+			}
+		}
+		if err == nil {
+			err = m.lockfreeCheckRemove(key, f)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// lockfreeCheckRename checks that the current user can move the entry f
+// from oldname to newname, replacing what is there.
+func (m *MemMapFs) lockfreeCheckRename(oldname, newname string, f *mem.FileData) error {
+	if m.cred == nil {
+		return nil
+	}
+	if err := m.lockfreeCheckRemove(oldname, f); err != nil {
+		return err
+	}
+	if err := m.lockfreeCheckCreate(newname); err != nil {
+		return err
+	}
+	if target, ok := m.getData().Get(newname); ok {
+		if err := m.lockfreeCheckRemove(newname, target); err != nil {
+			return err
+		}
+	}
+	// moving a directory to another parent rewrites its ".." entry
+	if mem.GetFileInfo(f).IsDir() && filepath.Dir(oldname) != filepath.Dir(newname) {
+		return m.lockfreeCheckAccess(f, accessWrite)
+	}
+	return nil
+}
+
+// lockfreeCheckOwner checks that the current user owns f, as needed to
+// change its mode or times.
+func (m *MemMapFs) lockfreeCheckOwner(f *mem.FileData) error {
+	c := m.cred
+	if c == nil || c.Uid == 0 || c.Uid == mem.GetFileInfo(f).Sys().(*mem.Stat).Uid {
+		return nil
+	}
+	return syscall.EPERM
+}
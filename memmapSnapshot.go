@@ -0,0 +1,63 @@
+package afero
+
+import (
+	"github.com/spf13/afero/mem"
+)
+
+// MemMapSnapshot is a read-only, point-in-time copy of a MemMapFs made by
+// MemMapFs.Snapshot. It can be read like any Fs and passed to
+// MemMapFs.Restore, as often as needed.
+type MemMapSnapshot struct {
+	Fs
+	fs *MemMapFs
+}
+
+// Snapshot returns a read-only copy of the current state of the
+// filesystem. File contents are not copied, they are shared between the
+// filesystem and the snapshot until they are modified. The index of the
+// entries is copied though: a snapshot takes time and memory proportional
+// to the number of files and directories, a few pointers each, regardless
+// of the size of the files, and blocks the filesystem meanwhile. It is not
+// O(1); for that the index and the entries themselves would have to be
+// shared copy-on-write as well.
+//
+// Files open while taking the snapshot keep writing to the filesystem, not
+// to the snapshot.
+func (m *MemMapFs) Snapshot() *MemMapSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fs := &MemMapFs{}
+	fs.init.Do(func() {
+		fs.data = cloneMemData(m.getData())
+	})
+	return &MemMapSnapshot{Fs: NewReadOnlyFs(fs), fs: fs}
+}
+
+// Restore rolls the filesystem back to the state of the snapshot s, which
+// may have been taken from another MemMapFs. Like Snapshot, Restore shares
+// the file contents with s, leaving s unchanged for later restores, and
+// copies the index of its entries, which takes time proportional to their
+// number.
+//
+// Files open before the restore no longer belong to the filesystem, as if
+// they had been removed.
+func (m *MemMapFs) Restore(s *MemMapSnapshot) {
+	// s is read-only, no need to lock it
+	data := cloneMemData(s.fs.getData())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getData()
+	m.data = data
+}
+
+// cloneMemData copies the entries of a MemMapFs, sharing their contents.
+func cloneMemData(data *memRadix) *memRadix {
+	c := mem.NewCloner()
+	clone := newMemRadix()
+	data.WalkPrefix("", func(name string, f *mem.FileData) bool {
+		clone.Insert(name, c.Clone(f))
+		return true
+	})
+	c.CloneDirs()
+	return clone
+}
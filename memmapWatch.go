@@ -0,0 +1,96 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero/mem"
+)
+
+// memWatch is a watch registered with a MemMapFs.
+type memWatch struct {
+	name      string
+	recursive bool
+	events    chan WatchEvent
+	errs      chan error
+}
+
+func (w *memWatch) matches(name string) bool {
+	return name == w.name || filepath.Dir(name) == w.name ||
+		w.recursive && strings.HasPrefix(name, treePrefix(w.name))
+}
+
+// Watch reports the changes of the named file or directory, which must
+// exist. The watch follows the name, not the file: a file created again
+// after being removed is still watched. Writes are reported when the file
+// is closed.
+func (m *MemMapFs) Watch(name string, recursive bool) (*WatchHandle, error) {
+	m.mu.RLock()
+	name, err := m.lockfreeResolve(name, true)
+	if err == nil {
+		err = m.lockfreeCheckSearch(name)
+	}
+	if err == nil {
+		if _, ok := m.getData().Get(name); !ok {
+			err = ErrFileNotFound
+		}
+	}
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+
+	w := &memWatch{
+		name:      name,
+		recursive: recursive,
+		events:    make(chan WatchEvent, watchEventQueue),
+		errs:      make(chan error, watchErrorQueue),
+	}
+	m.watchMu.Lock()
+	if m.watches == nil {
+		m.watches = make(map[*memWatch]struct{})
+	}
+	m.watches[w] = struct{}{}
+	m.watchMu.Unlock()
+
+	return &WatchHandle{
+		Events: w.events,
+		Errors: w.errs,
+		close: func() error {
+			m.watchMu.Lock()
+			defer m.watchMu.Unlock()
+			delete(m.watches, w)
+			close(w.events)
+			close(w.errs)
+			return nil
+		},
+	}, nil
+}
+
+// notify reports the change op of the entry name to the watches.
+func (m *MemMapFs) notify(name string, op WatchOp) {
+	m.watchMu.RLock()
+	defer m.watchMu.RUnlock()
+	for w := range m.watches {
+		if !w.matches(name) {
+			continue
+		}
+		select {
+		case w.events <- WatchEvent{Name: name, Op: op}:
+		default:
+			select {
+			case w.errs <- ErrWatchOverflow:
+			default:
+			}
+		}
+	}
+}
+
+// watchWrites makes the writable handle f report its writes when closed.
+func (m *MemMapFs) watchWrites(f *mem.File) *mem.File {
+	f.NotifyCloseWrite(func() {
+		m.notify(f.Name(), WatchWrite)
+	})
+	return f
+}
@@ -1,11 +1,22 @@
 package afero
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	mrand "math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/spf13/afero/mem"
 )
 
 func TestNormalizePath(t *testing.T) {
@@ -259,3 +270,471 @@ func TestWriteCloseTime(t *testing.T) {
 		}
 	}
 }
+
+func TestMemMapFsRenameDir(t *testing.T) {
+	fs := &MemMapFs{}
+	for _, name := range []string{"/dir/a", "/dir/sub/nested", "/dirx/b", "/other/c"} {
+		if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := fs.Rename("/dir", "/other/moved"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/dir", "/dir/a", "/dir/sub/nested"} {
+		if _, err := fs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s still exists: %v", name, err)
+		}
+	}
+	if data, err := ReadFile(fs, "/other/moved/sub/nested"); err != nil || string(data) != "/dir/sub/nested" {
+		t.Errorf("moved file: got %q, %v", data, err)
+	}
+	if data, err := ReadFile(fs, "/dirx/b"); err != nil || string(data) != "/dirx/b" {
+		t.Errorf("sibling sharing the name as prefix: got %q, %v", data, err)
+	}
+	for dir, want := range map[string]string{
+		"/":                "[dirx other]",
+		"/other":           "[c moved]",
+		"/other/moved":     "[a sub]",
+		"/other/moved/sub": "[nested]",
+	} {
+		if names := readDirNamesSorted(t, fs, dir); fmt.Sprint(names) != want {
+			t.Errorf("Readdir(%s): got %v, want %v", dir, names, want)
+		}
+	}
+	fi, err := fs.Stat("/other/moved/sub/nested")
+	if err != nil || fi.Name() != "nested" {
+		t.Errorf("Stat of a moved file: got %v, %v", fi, err)
+	}
+
+	for _, test := range []struct {
+		oldname, newname string
+		err              error
+	}{
+		{"/other", "/other/moved/sub/x", syscall.EINVAL},
+		{"/other/moved", "/other/c", syscall.ENOTDIR},
+		{"/other/c", "/other/moved", syscall.EISDIR},
+		{"/dirx", "/other", syscall.ENOTEMPTY},
+	} {
+		err := fs.Rename(test.oldname, test.newname)
+		if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != test.err {
+			t.Errorf("Rename(%s, %s): expected %v, got %v", test.oldname, test.newname, test.err, err)
+		}
+	}
+
+	// an empty directory can be replaced
+	fs.Mkdir("/empty", 0755)
+	if err := fs.Rename("/dirx", "/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if names := readDirNamesSorted(t, fs, "/empty"); fmt.Sprint(names) != "[b]" {
+		t.Errorf("Readdir(/empty): got %v", names)
+	}
+}
+
+func TestMemMapFsOpenFile(t *testing.T) {
+	fs := &MemMapFs{}
+	WriteFile(fs, "/dir/file", []byte("content"), 0644)
+	fs.Link("/dir/file", "/dir/link")
+
+	// Create truncates the existing file instead of replacing it
+	f, err := fs.Create("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("new")
+	f.Close()
+	if data, _ := ReadFile(fs, "/dir/link"); string(data) != "new" {
+		t.Errorf("hard link after Create: got %q", data)
+	}
+	if names := readDirNamesSorted(t, fs, "/dir"); fmt.Sprint(names) != "[file link]" {
+		t.Errorf("Readdir(/dir): got %v", names)
+	}
+	if _, err := fs.Create("/dir"); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("Create of a directory: expected EISDIR, got %v", err)
+	}
+
+	// only one of the concurrent exclusive creations succeeds
+	created := 0
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			f, err := fs.OpenFile("/excl", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err == nil {
+				f.Close()
+			} else if !os.IsExist(err) {
+				t.Error(err)
+			}
+			done <- err == nil
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if <-done {
+			created++
+		}
+	}
+	if created != 1 {
+		t.Errorf("O_EXCL: %d creations succeeded", created)
+	}
+	fs.Symlink("/missing", "/dangling")
+	if _, err := fs.OpenFile("/dangling", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); !os.IsExist(err) {
+		t.Errorf("O_EXCL on a dangling symlink: expected ErrExist, got %v", err)
+	}
+
+	// O_TRUNC is ignored without write access
+	f, _ = fs.OpenFile("/dir/file", os.O_RDONLY|os.O_TRUNC, 0)
+	f.Close()
+	if data, _ := ReadFile(fs, "/dir/file"); string(data) != "new" {
+		t.Errorf("O_RDONLY|O_TRUNC: got %q", data)
+	}
+
+	// writes with O_APPEND go to the end, whatever the offset
+	f, _ = fs.OpenFile("/dir/file", os.O_RDWR|os.O_APPEND, 0)
+	other, _ := fs.OpenFile("/dir/file", os.O_WRONLY|os.O_APPEND, 0)
+	f.Seek(0, io.SeekStart)
+	f.WriteString("-f")
+	other.WriteString("-other")
+	f.WriteString("-f")
+	if _, err := f.WriteAt([]byte("x"), 0); err == nil {
+		t.Error("WriteAt with O_APPEND succeeded")
+	}
+	if _, err := other.Read(make([]byte, 1)); err == nil || err == io.EOF {
+		t.Errorf("Read of a write only handle: got %v", err)
+	}
+	f.Close()
+	other.Close()
+	if data, _ := ReadFile(fs, "/dir/file"); string(data) != "new-f-other-f" {
+		t.Errorf("O_APPEND: got %q", data)
+	}
+}
+
+func TestMemMapFsConcurrentReadWriteAt(t *testing.T) {
+	fs := &MemMapFs{}
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString("header")
+
+	// every goroutine owns a block of the file, positional I/O neither
+	// overlaps nor moves the offset shared by the handle
+	const blocks, size = 16, 64
+	done := make(chan struct{})
+	for i := 0; i < blocks; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			want := bytes.Repeat([]byte{byte('a' + i)}, size)
+			off := int64(6 + i*size)
+			for j := 0; j < 50; j++ {
+				if _, err := f.WriteAt(want, off); err != nil {
+					t.Error(err)
+					return
+				}
+				got := make([]byte, size)
+				if _, err := f.ReadAt(got, off); err != nil || !bytes.Equal(got, want) {
+					t.Errorf("block %d: got %q, %v", i, got, err)
+					return
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < blocks; i++ {
+		<-done
+	}
+
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 6 {
+		t.Errorf("offset after positional I/O: got %d, want 6", pos)
+	}
+	buf := make([]byte, 4)
+	if n, err := f.ReadAt(buf, 6+blocks*size-2); n != 2 || err != io.EOF {
+		t.Errorf("short ReadAt: got %d, %v", n, err)
+	}
+	if n, err := f.ReadAt(buf, 1<<20); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt past the end: got %d, %v", n, err)
+	}
+}
+
+func TestMemMapFsLargeFile(t *testing.T) {
+	fs := &MemMapFs{}
+	f, err := fs.Create("/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// random writes and truncations across block boundaries, checked
+	// against a plain slice
+	rnd := mrand.New(mrand.NewSource(1))
+	var want []byte
+	for i := 0; i < 500; i++ {
+		if rnd.Intn(10) == 0 {
+			size := rnd.Intn(1 << 20)
+			if err := f.Truncate(int64(size)); err != nil {
+				t.Fatal(err)
+			}
+			if size < len(want) {
+				want = want[:size]
+			} else {
+				want = append(want, make([]byte, size-len(want))...)
+			}
+			continue
+		}
+		off := rnd.Intn(1 << 20)
+		b := make([]byte, rnd.Intn(200<<10))
+		rnd.Read(b)
+		if _, err := f.WriteAt(b, int64(off)); err != nil {
+			t.Fatal(err)
+		}
+		if end := off + len(b); end > len(want) {
+			want = append(want, make([]byte, end-len(want))...)
+		}
+		copy(want[off:], b)
+	}
+	if fi, _ := f.Stat(); fi.Size() != int64(len(want)) {
+		t.Fatalf("size: got %d, want %d", fi.Size(), len(want))
+	}
+	if got, _ := ReadFile(fs, "/large"); !bytes.Equal(got, want) {
+		t.Fatal("content differs from the written data")
+	}
+
+	// a sparse file is only allocated where it is written to
+	const huge = 1 << 40
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := f.Truncate(huge); err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("end"), huge-3)
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("extending a file to 1 TiB allocated %d bytes", n)
+	}
+	buf := make([]byte, 6)
+	if n, err := f.ReadAt(buf, huge-6); n != 6 || err != nil || string(buf) != "\x00\x00\x00end" {
+		t.Errorf("end of a sparse file: got %q, %v", buf[:n], err)
+	}
+	f.Truncate(int64(len(want)))
+
+	// a snapshot keeps the blocks modified after it was taken
+	s := fs.Snapshot()
+	f.WriteAt([]byte("changed"), 100)
+	fs.Restore(s)
+	if got, _ := ReadFile(fs, "/large"); !bytes.Equal(got, want) {
+		t.Error("restored content differs from the snapshot")
+	}
+}
+
+func TestMemMapFsPermissions(t *testing.T) {
+	fs := &MemMapFs{}
+	WriteFile(fs, "/home/user/file", []byte("content"), 0644)
+	WriteFile(fs, "/home/user/secret", []byte("secret"), 0600)
+	WriteFile(fs, "/etc/config", []byte("config"), 0644)
+	fs.Chmod("/etc", 0755)
+	fs.Mkdir("/tmp", os.ModeSticky|0777)
+	WriteFile(fs, "/tmp/other", nil, 0666)
+	fs.Chown("/tmp/other", 2000, 2000)
+	fs.Chown("/home/user", 1000, 1000)
+	fs.Chown("/home/user/file", 1000, 1000)
+	fs.Chmod("/home/user/secret", 0640)
+	fs.Chown("/home/user/secret", 0, 100)
+
+	fs.SetCredentials(&Credentials{Uid: 1000, Gid: 1000, Groups: []int{100}})
+
+	isAccess := func(err error) bool { return errors.Is(err, syscall.EACCES) }
+	isPerm := func(err error) bool { return errors.Is(err, syscall.EPERM) }
+	for _, test := range []struct {
+		name  string
+		err   error
+		check func(error) bool
+	}{
+		{"read own file", readErr(fs, "/home/user/file"), nil},
+		{"read group file", readErr(fs, "/home/user/secret"), nil},
+		{"write group file", WriteFile(fs, "/home/user/secret", nil, 0644), isAccess},
+		{"write others file", WriteFile(fs, "/etc/config", nil, 0644), isAccess},
+		{"create in own dir", WriteFile(fs, "/home/user/new", nil, 0644), nil},
+		{"create in others dir", WriteFile(fs, "/etc/new", nil, 0644), isAccess},
+		{"mkdir in others dir", fs.MkdirAll("/etc/a/b", 0755), isAccess},
+		{"remove from others dir", fs.Remove("/etc/config"), isAccess},
+		{"remove all from others dir", fs.RemoveAll("/etc"), isAccess},
+		{"rename out of others dir", fs.Rename("/etc/config", "/home/user/config"), isAccess},
+		{"remove others file in sticky dir", fs.Remove("/tmp/other"), isPerm},
+		{"chmod others file", fs.Chmod("/etc/config", 0777), isPerm},
+		{"chown to other user", fs.Chown("/home/user/file", 0, -1), isPerm},
+		{"chown to own group", fs.Chown("/home/user/file", -1, 100), nil},
+		{"chown to other group", fs.Chown("/home/user/file", -1, 0), isPerm},
+	} {
+		if test.check == nil && test.err != nil || test.check != nil && !test.check(test.err) {
+			t.Errorf("%s: unexpected error %v", test.name, test.err)
+		}
+	}
+	if _, err := fs.Stat("/etc/config"); err != nil {
+		t.Error("the removal of a protected file did not fail atomically:", err)
+	}
+
+	fi, err := fs.Stat("/home/user/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fi.Sys().(*mem.Stat); st.Uid != 1000 || st.Gid != 1000 {
+		t.Errorf("new file owned by %d:%d", st.Uid, st.Gid)
+	}
+
+	// searching a directory needs the execute bit, listing it the read bit
+	fs.Chmod("/home/user", 0600)
+	if _, err := fs.Stat("/home/user/file"); !isAccess(err) {
+		t.Errorf("Stat in an unsearchable directory: expected EACCES, got %v", err)
+	}
+	fs.Chmod("/home/user", 0300)
+	if _, err := ReadDir(fs, "/home/user"); !isAccess(err) {
+		t.Errorf("ReadDir of an unreadable directory: expected EACCES, got %v", err)
+	}
+	if _, err := fs.Stat("/home/user/file"); err != nil {
+		t.Error(err)
+	}
+
+	// the superuser bypasses the checks, and so does a filesystem without
+	// credentials
+	for _, cred := range []*Credentials{{}, nil} {
+		fs.SetCredentials(cred)
+		if err := WriteFile(fs, "/etc/config", []byte("new"), 0644); err != nil {
+			t.Errorf("credentials %v: %v", cred, err)
+		}
+	}
+}
+
+func readErr(fs Fs, name string) error {
+	_, err := ReadFile(fs, name)
+	return err
+}
+
+func TestMemMapFsSnapshot(t *testing.T) {
+	m := &MemMapFs{}
+	m.MkdirAll("/dir/sub", 0755)
+	WriteFile(m, "/dir/a", []byte("aaaa"), 0644)
+	WriteFile(m, "/dir/sub/b", []byte("bbbb"), 0644)
+	m.Link("/dir/a", "/dir/hard")
+	f, err := m.OpenFile("/dir/sub/b", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	s := m.Snapshot()
+
+	// modify everything in the filesystem
+	f.WriteAt([]byte("XX"), 1)
+	WriteFile(m, "/dir/a", []byte("changed"), 0644)
+	f2, _ := m.OpenFile("/dir/hard", os.O_WRONLY|os.O_APPEND, 0)
+	f2.Write([]byte("+"))
+	f2.Close()
+	m.Chmod("/dir/sub", 0700)
+	m.Remove("/dir/sub/b")
+	m.Rename("/dir/sub", "/moved")
+	WriteFile(m, "/new", []byte("new"), 0644)
+
+	check := func(fs Fs, when string) {
+		for name, want := range map[string]string{"/dir/a": "aaaa", "/dir/hard": "aaaa", "/dir/sub/b": "bbbb"} {
+			if data, err := ReadFile(fs, name); err != nil || string(data) != want {
+				t.Errorf("%s: %s: got %q, %v", when, name, data, err)
+			}
+		}
+		if fi, err := fs.Stat("/dir/sub"); err != nil || fi.Mode() != os.ModeDir|0755 {
+			t.Errorf("%s: /dir/sub: got %v, %v", when, fi, err)
+		}
+		for _, name := range []string{"/new", "/moved"} {
+			if _, err := fs.Stat(name); !os.IsNotExist(err) {
+				t.Errorf("%s: %s should not exist, got %v", when, name, err)
+			}
+		}
+		if names, err := ReadDir(fs, "/dir"); err != nil || len(names) != 3 {
+			t.Errorf("%s: unexpected listing of /dir: %v, %v", when, names, err)
+		}
+	}
+	check(s, "snapshot")
+	if err := s.Remove("/dir/a"); err == nil {
+		t.Error("the snapshot should be read-only")
+	}
+
+	m.Restore(s)
+	check(m, "first restore")
+	// hard links survive the snapshot
+	WriteFile(m, "/dir/a", []byte("linked"), 0644)
+	if data, _ := ReadFile(m, "/dir/hard"); string(data) != "linked" {
+		t.Errorf("hard link lost in the snapshot, got %q", data)
+	}
+	// the file opened before the restore is detached from the filesystem
+	f.WriteAt([]byte("YY"), 1)
+
+	m.Restore(s)
+	check(m, "second restore")
+	check(s, "snapshot after restores")
+}
+
+func TestMemMapFsImage(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	m := &MemMapFs{}
+	m.MkdirAll("/dir/empty", 0700)
+	WriteFile(m, "/dir/a", []byte("aaaa"), 0640)
+	WriteFile(m, "/dir/big", make([]byte, 3<<20), 0644)
+	m.Link("/dir/a", "/dir/hard")
+	m.Symlink("a", "/dir/link")
+	m.Chtimes("/dir/a", mtime, mtime)
+	m.Chtimes("/dir/empty", mtime, mtime)
+	m.Chown("/dir/a", 1000, 100)
+
+	var buf bytes.Buffer
+	if err := m.WriteImage(&buf); err != nil {
+		t.Fatal(err)
+	}
+	image := buf.Bytes()
+
+	loaded := &MemMapFs{}
+	WriteFile(loaded, "/replaced", []byte("x"), 0644)
+	if err := loaded.ReadImage(bytes.NewReader(image)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.Stat("/replaced"); !os.IsNotExist(err) {
+		t.Errorf("ReadImage should replace the content, got %v", err)
+	}
+	want, got := tarTree(t, m, "/"), tarTree(t, loaded, "/")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded image differs:\ngot  %q\nwant %q", got, want)
+	}
+	for _, name := range []string{"/dir/a", "/dir/empty"} {
+		if fi, err := loaded.Stat(name); err != nil || !fi.ModTime().Equal(mtime) {
+			t.Errorf("%s: unexpected mtime %v, %v", name, fi, err)
+		}
+	}
+	if fi, err := loaded.Stat("/dir/hard"); err != nil || fi.Sys().(*mem.Stat).Uid != 1000 || fi.Sys().(*mem.Stat).Gid != 100 {
+		t.Errorf("ownership lost in the image: %v, %v", fi, err)
+	}
+	WriteFile(loaded, "/dir/hard", []byte("linked"), 0644)
+	if data, _ := ReadFile(loaded, "/dir/a"); string(data) != "linked" {
+		t.Errorf("hard link lost in the image, got %q", data)
+	}
+	if names, _ := ReadDir(loaded, "/dir"); len(names) != 5 {
+		t.Errorf("unexpected listing of /dir: %v", names)
+	}
+
+	corrupted := append([]byte(nil), image...)
+	corrupted[len(corrupted)/2] ^= 1
+	for _, bad := range [][]byte{corrupted, image[:len(image)-1], []byte("AFEROMEM\x03"), []byte("not an image")} {
+		fs := &MemMapFs{}
+		WriteFile(fs, "/kept", nil, 0644)
+		if err := fs.ReadImage(bytes.NewReader(bad)); err == nil {
+			t.Errorf("reading a bad image (%d bytes) should fail", len(bad))
+		}
+		if _, err := fs.Stat("/kept"); err != nil {
+			t.Errorf("a failed ReadImage should keep the content: %v", err)
+		}
+	}
+
+	// images of other versions are rejected
+	v1 := []byte("AFEROMEM\x01d\x01/\xed\x83\x80\x80\x08\x00\x00")
+	v1 = binary.BigEndian.AppendUint32(v1, crc32.ChecksumIEEE(v1))
+	if err := loaded.ReadImage(bytes.NewReader(v1)); !errors.Is(err, ErrImageVersion) {
+		t.Errorf("reading a version 1 image: expected ErrImageVersion, got %v", err)
+	}
+}
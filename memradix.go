@@ -12,3 +12,277 @@
 // limitations under the License.
 
 package afero
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero/mem"
+)
+
+// memRadix is the path index of a MemMapFs, a radix tree mapping the names
+// of all entries to their data. Walking or removing the entries below a
+// directory costs time proportional to the size of that subtree, not to
+// the size of the filesystem.
+type memRadix struct {
+	root radixNode
+	size int
+}
+
+// radixNode is a node of a memRadix. The key of a node is the
+// concatenation of the prefixes from the root to it; only nodes with a
+// value are entries of the index.
+type radixNode struct {
+	prefix string
+	key    string
+	value  *mem.FileData
+	// the children, sorted by the first byte of their prefix, which is
+	// distinct among siblings
+	edges []*radixNode
+}
+
+func newMemRadix() *memRadix {
+	return &memRadix{}
+}
+
+func (n *radixNode) edge(label byte) (int, *radixNode) {
+	i := sort.Search(len(n.edges), func(i int) bool { return n.edges[i].prefix[0] >= label })
+	if i < len(n.edges) && n.edges[i].prefix[0] == label {
+		return i, n.edges[i]
+	}
+	return i, nil
+}
+
+func (n *radixNode) addEdge(child *radixNode) {
+	i, _ := n.edge(child.prefix[0])
+	n.edges = append(n.edges, nil)
+	copy(n.edges[i+1:], n.edges[i:])
+	n.edges[i] = child
+}
+
+func (n *radixNode) removeEdge(label byte) {
+	if i, child := n.edge(label); child != nil {
+		n.edges = append(n.edges[:i], n.edges[i+1:]...)
+	}
+}
+
+// compact merges n with its only child if n has no value.
+func (n *radixNode) compact() {
+	if n.value != nil || len(n.edges) != 1 {
+		return
+	}
+	child := n.edges[0]
+	n.prefix += child.prefix
+	n.key, n.value, n.edges = child.key, child.value, child.edges
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func (t *memRadix) Len() int {
+	return t.size
+}
+
+func (t *memRadix) Get(key string) (*mem.FileData, bool) {
+	n, search := &t.root, key
+	for len(search) > 0 {
+		_, child := n.edge(search[0])
+		if child == nil || len(search) < len(child.prefix) || search[:len(child.prefix)] != child.prefix {
+			return nil, false
+		}
+		n, search = child, search[len(child.prefix):]
+	}
+	return n.value, n.value != nil
+}
+
+// Insert adds or replaces the entry key.
+func (t *memRadix) Insert(key string, value *mem.FileData) {
+	n, search := &t.root, key
+	for {
+		if len(search) == 0 {
+			if n.value == nil {
+				t.size++
+			}
+			n.key, n.value = key, value
+			return
+		}
+		_, child := n.edge(search[0])
+		if child == nil {
+			n.addEdge(&radixNode{prefix: search, key: key, value: value})
+			t.size++
+			return
+		}
+		common := commonPrefix(search, child.prefix)
+		if common == len(child.prefix) {
+			n, search = child, search[common:]
+			continue
+		}
+		// split the edge to child at the end of the common prefix
+		split := &radixNode{prefix: search[:common]}
+		n.removeEdge(search[0])
+		n.addEdge(split)
+		child.prefix = child.prefix[common:]
+		split.addEdge(child)
+		if search = search[common:]; len(search) == 0 {
+			split.key, split.value = key, value
+		} else {
+			split.addEdge(&radixNode{prefix: search, key: key, value: value})
+		}
+		t.size++
+		return
+	}
+}
+
+// Delete removes the entry key and reports whether it existed.
+func (t *memRadix) Delete(key string) bool {
+	var parent *radixNode
+	n, search := &t.root, key
+	for len(search) > 0 {
+		_, child := n.edge(search[0])
+		if child == nil || len(search) < len(child.prefix) || search[:len(child.prefix)] != child.prefix {
+			return false
+		}
+		parent, n, search = n, child, search[len(child.prefix):]
+	}
+	if n.value == nil {
+		return false
+	}
+	n.key, n.value = "", nil
+	t.size--
+	if parent == nil {
+		return true
+	}
+	if len(n.edges) == 0 {
+		parent.removeEdge(n.prefix[0])
+		if parent != &t.root {
+			parent.compact()
+		}
+	} else {
+		n.compact()
+	}
+	return true
+}
+
+// WalkPrefix calls fn for all entries whose key starts with prefix, in
+// lexical order, until fn returns false.
+func (t *memRadix) WalkPrefix(prefix string, fn func(key string, value *mem.FileData) bool) {
+	if n := t.find(prefix); n != nil {
+		n.walk(fn)
+	}
+}
+
+// find returns the topmost node whose key starts with prefix.
+func (t *memRadix) find(prefix string) *radixNode {
+	n, search := &t.root, prefix
+	for len(search) > 0 {
+		_, child := n.edge(search[0])
+		if child == nil {
+			return nil
+		}
+		if len(search) <= len(child.prefix) {
+			if child.prefix[:len(search)] != search {
+				return nil
+			}
+			return child
+		}
+		if search[:len(child.prefix)] != child.prefix {
+			return nil
+		}
+		n, search = child, search[len(child.prefix):]
+	}
+	return n
+}
+
+func (n *radixNode) walk(fn func(key string, value *mem.FileData) bool) bool {
+	if n.value != nil && !fn(n.key, n.value) {
+		return false
+	}
+	for _, child := range n.edges {
+		if !child.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// DeletePrefix removes all entries whose key starts with prefix, calling
+// fn for each of them first, and returns their number.
+func (t *memRadix) DeletePrefix(prefix string, fn func(key string, value *mem.FileData)) int {
+	if prefix == "" {
+		removed := t.size
+		t.root.walk(func(key string, value *mem.FileData) bool {
+			fn(key, value)
+			return true
+		})
+		t.root, t.size = radixNode{}, 0
+		return removed
+	}
+	// find the parent of the topmost node below prefix
+	n, search := &t.root, prefix
+	for {
+		_, child := n.edge(search[0])
+		if child == nil {
+			return 0
+		}
+		if len(search) <= len(child.prefix) {
+			if child.prefix[:len(search)] != search {
+				return 0
+			}
+			removed := 0
+			child.walk(func(key string, value *mem.FileData) bool {
+				fn(key, value)
+				removed++
+				return true
+			})
+			n.removeEdge(child.prefix[0])
+			if n != &t.root {
+				n.compact()
+			}
+			t.size -= removed
+			return removed
+		}
+		if search[:len(child.prefix)] != child.prefix {
+			return 0
+		}
+		n, search = child, search[len(child.prefix):]
+	}
+}
+
+// treePrefix returns the common prefix of the names below the directory
+// name.
+func treePrefix(name string) string {
+	if strings.HasSuffix(name, FilePathSeparator) {
+		return name
+	}
+	return name + FilePathSeparator
+}
+
+// WalkTree calls fn for the entry name and all entries below it, in
+// lexical order, until fn returns false.
+func (t *memRadix) WalkTree(name string, fn func(key string, value *mem.FileData) bool) {
+	if value, ok := t.Get(name); ok && !strings.HasSuffix(name, FilePathSeparator) {
+		if !fn(name, value) {
+			return
+		}
+	}
+	t.WalkPrefix(treePrefix(name), fn)
+}
+
+// DeleteTree removes the entry name and all entries below it, calling fn
+// for each of them first, and returns their number.
+func (t *memRadix) DeleteTree(name string, fn func(key string, value *mem.FileData)) int {
+	removed := 0
+	if !strings.HasSuffix(name, FilePathSeparator) {
+		if value, ok := t.Get(name); ok {
+			fn(name, value)
+			t.Delete(name)
+			removed++
+		}
+	}
+	return removed + t.DeletePrefix(treePrefix(name), fn)
+}
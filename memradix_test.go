@@ -0,0 +1,91 @@
+package afero
+
+import (
+	mrand "math/rand"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero/mem"
+)
+
+func radixKeys(t *memRadix, prefix string) []string {
+	var keys []string
+	t.WalkPrefix(prefix, func(key string, value *mem.FileData) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func TestMemRadix(t *testing.T) {
+	tree := newMemRadix()
+	model := make(map[string]*mem.FileData)
+	rnd := mrand.New(mrand.NewSource(1))
+	names := []string{"/", "/a", "/ab", "/a/b", "/a/bc", "/a/b/c", "/abc", "/b", "/a-b", "/a/b/cd"}
+	for i := 0; i < 2000; i++ {
+		name := names[rnd.Intn(len(names))]
+		if rnd.Intn(3) == 0 {
+			_, ok := model[name]
+			if got := tree.Delete(name); got != ok {
+				t.Fatalf("Delete(%q): got %v, want %v", name, got, ok)
+			}
+			delete(model, name)
+		} else {
+			f := mem.CreateFile(name)
+			tree.Insert(name, f)
+			model[name] = f
+		}
+
+		if tree.Len() != len(model) {
+			t.Fatalf("Len: got %d, want %d", tree.Len(), len(model))
+		}
+		var want []string
+		for _, name := range names {
+			f, ok := model[name]
+			if got, gotOk := tree.Get(name); got != f || gotOk != ok {
+				t.Fatalf("Get(%q): got %v, %v", name, got, gotOk)
+			}
+			if ok && strings.HasPrefix(name, "/a") {
+				want = append(want, name)
+			}
+		}
+		sort.Strings(want)
+		if got := radixKeys(tree, "/a"); !reflect.DeepEqual(got, want) {
+			t.Fatalf("WalkPrefix: got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMemRadixDeleteTree(t *testing.T) {
+	tree := newMemRadix()
+	for _, name := range []string{"/", "/a", "/a/b", "/a/b/c", "/a/bc", "/ab", "/a-b", "/b"} {
+		tree.Insert(filepath.FromSlash(name), mem.CreateFile(name))
+	}
+
+	var walked []string
+	tree.WalkTree(filepath.FromSlash("/a/b"), func(key string, value *mem.FileData) bool {
+		walked = append(walked, filepath.ToSlash(key))
+		return true
+	})
+	if want := []string{"/a/b", "/a/b/c"}; !reflect.DeepEqual(walked, want) {
+		t.Errorf("WalkTree: got %v, want %v", walked, want)
+	}
+
+	var removed []string
+	n := tree.DeleteTree(filepath.FromSlash("/a"), func(key string, value *mem.FileData) {
+		removed = append(removed, filepath.ToSlash(key))
+	})
+	if want := []string{"/a", "/a/b", "/a/b/c", "/a/bc"}; n != len(want) || !reflect.DeepEqual(removed, want) {
+		t.Errorf("DeleteTree: removed %d %v, want %v", n, removed, want)
+	}
+	var left []string
+	for _, key := range radixKeys(tree, "") {
+		left = append(left, filepath.ToSlash(key))
+	}
+	if want := []string{"/", "/a-b", "/ab", "/b"}; !reflect.DeepEqual(left, want) || tree.Len() != len(want) {
+		t.Errorf("after DeleteTree: got %v (%d), want %v", left, tree.Len(), want)
+	}
+}
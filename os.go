@@ -92,3 +92,27 @@ func (OsFs) Chmod(name string, mode os.FileMode) error {
 func (OsFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return os.Chtimes(name, atime, mtime)
 }
+
+func (OsFs) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (OsFs) Lchown(name string, uid, gid int) error {
+	return os.Lchown(name, uid, gid)
+}
+
+func (OsFs) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (OsFs) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (OsFs) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (OsFs) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
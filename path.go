@@ -16,9 +16,13 @@
 package afero
 
 import (
+	"archive/tar"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"time"
 )
 
 // readDirNames reads the directory named by dirname and returns
@@ -60,7 +64,7 @@ func walk(fs Fs, path string, info os.FileInfo, walkFn filepath.WalkFunc) error
 
 	for _, name := range names {
 		filename := filepath.Join(path, name)
-		fileInfo, err := lstatIfOs(fs, filename)
+		fileInfo, err := Lstat(fs, filename)
 		if err != nil {
 			if err := walkFn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
 				return err
@@ -77,17 +81,6 @@ func walk(fs Fs, path string, info os.FileInfo, walkFn filepath.WalkFunc) error
 	return nil
 }
 
-// if the filesystem is OsFs use Lstat, else use fs.Stat
-func lstatIfOs(fs Fs, path string) (info os.FileInfo, err error) {
-	_, ok := fs.(*OsFs)
-	if ok {
-		info, err = os.Lstat(path)
-	} else {
-		info, err = fs.Stat(path)
-	}
-	return
-}
-
 // Walk walks the file tree rooted at root, calling walkFn for each file or
 // directory in the tree, including root. All errors that arise visiting files
 // and directories are filtered by walkFn. The files are walked in lexical
@@ -100,9 +93,244 @@ func (a Afero) Walk(root string, walkFn filepath.WalkFunc) error {
 }
 
 func Walk(fs Fs, root string, walkFn filepath.WalkFunc) error {
-	info, err := lstatIfOs(fs, root)
+	info, err := Lstat(fs, root)
 	if err != nil {
 		return walkFn(root, nil, err)
 	}
 	return walk(fs, root, info, walkFn)
 }
+
+// ExportTar writes the file tree rooted at root as a tar archive to w. The
+// names in the archive are relative to root. Modes, modification times,
+// directories and, on filesystems supporting them, symbolic links are
+// preserved. Sockets are skipped.
+func (a Afero) ExportTar(root string, w io.Writer) error {
+	return ExportTar(a.Fs, root, w)
+}
+
+func ExportTar(fs Fs, root string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			if info.IsDir() {
+				return nil
+			}
+			// a single file
+			name = filepath.Base(path)
+		}
+		if info.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = Readlink(fs, path); err != nil {
+				return err
+			}
+			link = filepath.ToSlash(link)
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := fs.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ImportTar extracts the tar archive read from r below root, creating root
+// if necessary. Existing files are replaced. Names trying to escape root
+// are extracted below it. Symbolic links are skipped if the filesystem does
+// not support them, hard links to regular files are copied if it does not
+// support those.
+func (a Afero) ImportTar(root string, r io.Reader) error {
+	return ImportTar(a.Fs, root, r)
+}
+
+func ImportTar(fs Fs, root string, r io.Reader) error {
+	if err := fs.MkdirAll(root, 0777); err != nil {
+		return err
+	}
+	// the modification times of directories are set at the end, after
+	// their content has been written
+	var dirs []*tar.Header
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(root, tarPath(hdr.Name))
+		if name == filepath.Clean(root) && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		if err := checkImportParents(fs, root, name); err != nil {
+			return err
+		}
+		if err := fs.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fi, err := Lstat(fs, name); err == nil && !fi.IsDir() {
+				if err := fs.Remove(name); err != nil {
+					return err
+				}
+			}
+			if err := fs.MkdirAll(name, 0777); err != nil {
+				return err
+			}
+			if err := fs.Chmod(name, mode); err != nil {
+				return err
+			}
+			dirs = append(dirs, hdr)
+			continue
+		case tar.TypeSymlink:
+			if _, ok := fs.(Symlinker); !ok {
+				continue
+			}
+			if err := removeForImport(fs, name); err != nil {
+				return err
+			}
+			if err := Symlink(fs, filepath.FromSlash(hdr.Linkname), name); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeLink:
+			if err := removeForImport(fs, name); err != nil {
+				return err
+			}
+			oldname := filepath.Join(root, tarPath(hdr.Linkname))
+			if _, ok := fs.(Linker); ok {
+				if err := Link(fs, oldname, name); err != nil {
+					return err
+				}
+				continue
+			}
+			// copy the file the link refers to, but not anything reached
+			// through a symbolic link, which could be outside of root
+			if err := checkImportParents(fs, root, oldname); err != nil {
+				return err
+			}
+			fi, err := Lstat(fs, oldname)
+			if err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return &os.PathError{Op: "import", Path: name, Err: errors.New("hard link to a file which is not regular")}
+			}
+			src, err := fs.Open(oldname)
+			if err != nil {
+				return err
+			}
+			err = WriteReader(fs, name, src)
+			src.Close()
+			if err != nil {
+				return err
+			}
+			if err := fs.Chmod(name, fi.Mode()); err != nil {
+				return err
+			}
+			if err := fs.Chtimes(name, fi.ModTime(), fi.ModTime()); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+			if err := removeForImport(fs, name); err != nil {
+				return err
+			}
+			f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			// devices, fifos and the like cannot be created on an Fs
+			continue
+		}
+		if err := fs.Chmod(name, mode); err != nil {
+			return err
+		}
+		if err := fs.Chtimes(name, tarAccessTime(hdr), hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		hdr := dirs[i]
+		if err := fs.Chtimes(filepath.Join(root, tarPath(hdr.Name)), tarAccessTime(hdr), hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkImportParents refuses to extract name through a symbolic link
+// below root, which could point outside of it.
+func checkImportParents(fs Fs, root, name string) error {
+	root = filepath.Clean(root)
+	for dir := filepath.Dir(name); len(dir) > len(root); dir = filepath.Dir(dir) {
+		fi, err := Lstat(fs, dir)
+		if err != nil {
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return &os.PathError{Op: "import", Path: name, Err: errors.New("parent directory is a symbolic link")}
+		}
+	}
+	return nil
+}
+
+// removeForImport removes an existing file or symlink to be replaced by an
+// archive entry.
+func removeForImport(fs Fs, name string) error {
+	fi, err := Lstat(fs, name)
+	if err != nil {
+		return nil
+	}
+	if fi.IsDir() {
+		return fs.RemoveAll(name)
+	}
+	return fs.Remove(name)
+}
+
+func tarAccessTime(hdr *tar.Header) time.Time {
+	if hdr.AccessTime.IsZero() {
+		return hdr.ModTime
+	}
+	return hdr.AccessTime
+}
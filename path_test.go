@@ -15,9 +15,15 @@
 package afero
 
 import (
+	"archive/tar"
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func TestWalk(t *testing.T) {
@@ -67,3 +73,125 @@ func TestWalk(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// tarTree describes the files below root for comparing archive round trips
+func tarTree(t *testing.T, fs Fs, root string) []string {
+	var tree []string
+	err := Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		line := fmt.Sprintf("%s %v", filepath.ToSlash(rel), info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := Readlink(fs, path)
+			if err != nil {
+				return err
+			}
+			line += " -> " + link
+		case info.Mode().IsRegular():
+			data, err := ReadFile(fs, path)
+			if err != nil {
+				return err
+			}
+			line += fmt.Sprintf(" %q %v", data, info.ModTime().Unix())
+		}
+		tree = append(tree, line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestExportImportTar(t *testing.T) {
+	defer removeAllTestFiles(t)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, fs := range Fss {
+		src, dst := testDir(fs), testDir(fs)
+		if err := fs.MkdirAll(filepath.Join(src, "dir", "sub"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Chmod(filepath.Join(src, "dir"), os.ModeDir|0750); err != nil {
+			t.Fatal(err)
+		}
+		for name, mode := range map[string]os.FileMode{"a.txt": 0640, "dir/b.txt": 0600, "dir/sub/c": 0755} {
+			path := filepath.Join(src, filepath.FromSlash(name))
+			if err := WriteFile(fs, path, []byte(name), mode); err != nil {
+				t.Fatal(err)
+			}
+			fs.Chmod(path, mode)
+			fs.Chtimes(path, mtime, mtime)
+		}
+		if runtime.GOOS != "windows" {
+			if err := Symlink(fs, "../a.txt", filepath.Join(src, "dir", "link")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := ExportTar(fs, src, &buf); err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+		if err := ImportTar(fs, dst, &buf); err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+		want, got := tarTree(t, fs, src), tarTree(t, fs, dst)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip:\ngot  %q\nwant %q", fs.Name(), got, want)
+		}
+	}
+}
+
+func TestImportTar(t *testing.T) {
+	fs := NewMemMapFs()
+	WriteFile(fs, "/root/usr/lib/libc.so", []byte("replaced"), 0644)
+	if err := ImportTar(fs, "/root", bytes.NewReader(newTestTar(t))); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"/root/usr/lib/libc.so": "libc",
+		"/root/lib/libc.so":     "libc",
+		"/root/usr/bin/hard":    "read me",
+		"/root/escape":          "escape",
+	} {
+		if data, err := ReadFile(fs, name); err != nil || string(data) != content {
+			t.Errorf("%s: got %q, %v", name, data, err)
+		}
+	}
+	if fi, err := fs.Stat("/root/usr"); err != nil || fi.Mode() != os.ModeDir|0750 || !fi.ModTime().Equal(tarTestTime) {
+		t.Errorf("unexpected directory %v, %v", fi, err)
+	}
+
+	// a symlink pointing outside of the root, followed by a file below it
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	w.WriteHeader(&tar.Header{Name: "etc", Typeflag: tar.TypeSymlink, Linkname: "/etc"})
+	w.WriteHeader(&tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+	w.Write([]byte("x"))
+	w.Close()
+	if err := ImportTar(fs, "/evil", &buf); err == nil {
+		t.Error("extracting through a symlink should fail")
+	}
+	if _, err := fs.Stat("/etc/passwd"); err == nil {
+		t.Error("file written outside of the root")
+	}
+
+	// without hard links, a link to a symlink pointing outside of the root
+	// is not copied
+	cfs := NewCopyOnWriteFs(NewMemMapFs(), NewMemMapFs())
+	WriteFile(cfs, "/secret", []byte("secret"), 0600)
+	buf.Reset()
+	w = tar.NewWriter(&buf)
+	w.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/secret"})
+	w.WriteHeader(&tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "link"})
+	w.Close()
+	if err := ImportTar(cfs, "/evil", &buf); err == nil {
+		t.Error("copying a hard link to a symlink should fail")
+	}
+	if _, err := cfs.Stat("/evil/hard"); err == nil {
+		t.Error("hard link to a symlink copied")
+	}
+}
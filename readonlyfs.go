@@ -26,6 +26,14 @@ func (r *ReadOnlyFs) Chmod(n string, m os.FileMode) error {
 	return syscall.EPERM
 }
 
+func (r *ReadOnlyFs) Chown(n string, uid, gid int) error {
+	return syscall.EPERM
+}
+
+func (r *ReadOnlyFs) Lchown(n string, uid, gid int) error {
+	return syscall.EPERM
+}
+
 func (r *ReadOnlyFs) Name() string {
 	return "ReadOnlyFilter"
 }
@@ -68,3 +76,19 @@ func (r *ReadOnlyFs) MkdirAll(n string, p os.FileMode) error {
 func (r *ReadOnlyFs) Create(n string) (File, error) {
 	return nil, syscall.EPERM
 }
+
+func (r *ReadOnlyFs) Lstat(name string) (os.FileInfo, error) {
+	return Lstat(r.source, name)
+}
+
+func (r *ReadOnlyFs) Symlink(o, n string) error {
+	return syscall.EPERM
+}
+
+func (r *ReadOnlyFs) Readlink(name string) (string, error) {
+	return Readlink(r.source, name)
+}
+
+func (r *ReadOnlyFs) Link(o, n string) error {
+	return syscall.EPERM
+}
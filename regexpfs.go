@@ -37,6 +37,10 @@ func (r *RegexpFs) matchesName(name string) error {
 
 func (r *RegexpFs) dirOrMatches(name string) error {
 	dir, err := IsDir(r.source, name)
+	if os.IsNotExist(err) {
+		// e.g. a file about to be created
+		return r.matchesName(name)
+	}
 	if err != nil {
 		return err
 	}
@@ -60,6 +64,20 @@ func (r *RegexpFs) Chmod(name string, mode os.FileMode) error {
 	return r.source.Chmod(name, mode)
 }
 
+func (r *RegexpFs) Chown(name string, uid, gid int) error {
+	if err := r.dirOrMatches(name); err != nil {
+		return err
+	}
+	return Chown(r.source, name, uid, gid)
+}
+
+func (r *RegexpFs) Lchown(name string, uid, gid int) error {
+	if err := r.dirOrMatches(name); err != nil {
+		return err
+	}
+	return Lchown(r.source, name, uid, gid)
+}
+
 func (r *RegexpFs) Name() string {
 	return "RegexpFs"
 }
@@ -89,15 +107,9 @@ func (r *RegexpFs) Rename(oldname, newname string) error {
 }
 
 func (r *RegexpFs) RemoveAll(p string) error {
-	dir, err := IsDir(r.source, p)
-	if err != nil {
+	if err := r.dirOrMatches(p); err != nil {
 		return err
 	}
-	if !dir {
-		if err := r.matchesName(p); err != nil {
-			return err
-		}
-	}
 	return r.source.RemoveAll(p)
 }
 
@@ -15,6 +15,7 @@ package afero
 
 import (
 	"os"
+	"syscall"
 	"time"
 
 	"github.com/spf13/afero/sftp"
@@ -34,15 +35,23 @@ func (s SftpFs) Name() string { return "SftpFs" }
 
 func (s SftpFs) Create(name string) (File, error) {
 	f, err := sftpfs.FileCreate(s.SftpClient, name)
-	return f, err
+	if err != nil {
+		return nil, sftpPathError("open", name, err)
+	}
+	return f, nil
 }
 
+// Mkdir creates the named directory. The sftp protocol does not tell why
+// creating it failed, an existing entry is reported with ErrExist.
 func (s SftpFs) Mkdir(name string, perm os.FileMode) error {
 	err := s.SftpClient.Mkdir(name)
 	if err != nil {
-		return err
+		if _, serr := s.SftpClient.Lstat(name); serr == nil {
+			err = os.ErrExist
+		}
+		return sftpPathError("mkdir", name, err)
 	}
-	return s.SftpClient.Chmod(name, perm)
+	return sftpPathError("chmod", name, s.SftpClient.Chmod(name, perm))
 }
 
 func (s SftpFs) MkdirAll(path string, perm os.FileMode) error {
@@ -52,7 +61,7 @@ func (s SftpFs) MkdirAll(path string, perm os.FileMode) error {
 		if dir.IsDir() {
 			return nil
 		}
-		return err
+		return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
 	}
 
 	// Slow path: make sure parent exists and then call Mkdir for path.
@@ -90,39 +99,115 @@ func (s SftpFs) MkdirAll(path string, perm os.FileMode) error {
 
 func (s SftpFs) Open(name string) (File, error) {
 	f, err := sftpfs.FileOpen(s.SftpClient, name)
-	return f, err
+	if err != nil {
+		return nil, sftpPathError("open", name, err)
+	}
+	return f, nil
 }
 
+// OpenFile opens the named file with the flags of os.OpenFile. The sftp
+// protocol creates files with the default mode of the server, a file
+// created by OpenFile is changed to perm afterwards.
 func (s SftpFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
-	return nil,nil
+	_, statErr := s.SftpClient.Stat(name)
+	f, err := sftpfs.FileOpenFlag(s.SftpClient, name, flag)
+	if err != nil {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			if _, serr := s.SftpClient.Lstat(name); serr == nil {
+				err = os.ErrExist
+			}
+		}
+		return nil, sftpPathError("open", name, err)
+	}
+	if flag&os.O_CREATE != 0 && os.IsNotExist(statErr) {
+		if err := s.SftpClient.Chmod(name, perm); err != nil {
+			f.Close()
+			return nil, sftpPathError("chmod", name, err)
+		}
+	}
+	return f, nil
 }
 
 func (s SftpFs) Remove(name string) error {
-	return s.SftpClient.Remove(name)
+	return sftpPathError("remove", name, s.SftpClient.Remove(name))
 }
 
+// RemoveAll removes path and everything below it, like os.RemoveAll it
+// returns nil if path does not exist.
 func (s SftpFs) RemoveAll(path string) error {
-	// TODO have a look at os.RemoveAll
-	// https://github.com/golang/go/blob/master/src/os/path.go#L66
-	return nil
+	fi, err := s.SftpClient.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return sftpPathError("lstat", path, err)
+	}
+	if !fi.IsDir() {
+		return s.SftpClient.Remove(path)
+	}
+	fis, err := s.SftpClient.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if err := s.RemoveAll(path + "/" + fi.Name()); err != nil {
+			return err
+		}
+	}
+	return s.SftpClient.RemoveDirectory(path)
 }
 
 func (s SftpFs) Rename(oldname, newname string) error {
-	return s.SftpClient.Rename(oldname, newname)
+	if err := s.SftpClient.Rename(oldname, newname); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
 }
 
 func (s SftpFs) Stat(name string) (os.FileInfo, error) {
-	return s.SftpClient.Stat(name)
+	fi, err := s.SftpClient.Stat(name)
+	return fi, sftpPathError("stat", name, err)
 }
 
 func (s SftpFs) Lstat(p string) (os.FileInfo, error) {
-	return s.SftpClient.Lstat(p)
+	fi, err := s.SftpClient.Lstat(p)
+	return fi, sftpPathError("lstat", p, err)
 }
 
 func (s SftpFs) Chmod(name string, mode os.FileMode) error {
-	return s.SftpClient.Chmod(name, mode)
+	return sftpPathError("chmod", name, s.SftpClient.Chmod(name, mode))
+}
+
+func (s SftpFs) Chown(name string, uid, gid int) error {
+	return sftpPathError("chown", name, s.SftpClient.Chown(name, uid, gid))
+}
+
+// Lchown changes the owner of the named file. The sftp protocol has no way
+// to change the owner of a symbolic link itself, Lchown of a link fails
+// with ErrNoChown.
+func (s SftpFs) Lchown(name string, uid, gid int) error {
+	fi, err := s.SftpClient.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+	}
+	return s.SftpClient.Chown(name, uid, gid)
 }
 
 func (s SftpFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	return s.SftpClient.Chtimes(name, atime, mtime)
+	return sftpPathError("chtimes", name, s.SftpClient.Chtimes(name, atime, mtime))
+}
+
+// sftpPathError wraps an error of the sftp client in an os.PathError, like
+// the errors of the os package.
+func sftpPathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*os.PathError); ok {
+		return err
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
 }
@@ -14,28 +14,50 @@
 package sftpfs
 
 import (
+	"io"
 	"os"
 	"github.com/pkg/sftp"
 )
 
 type File struct {
-	fd *sftp.File
+	client *sftp.Client
+	fd     *sftp.File
+	// the directory entries, read by the first call of Readdir
+	entries []os.FileInfo
+	readdir int
 }
 
 func FileOpen(s *sftp.Client, name string) (*File, error) {
 	fd, err := s.Open(name)
 	if err != nil {
-		return &File{}, err
+		return nil, err
 	}
-	return &File{fd: fd}, nil
+	return &File{client: s, fd: fd}, nil
 }
 
 func FileCreate(s *sftp.Client, name string) (*File, error) {
 	fd, err := s.Create(name)
 	if err != nil {
-		return &File{}, err
+		return nil, err
 	}
-	return &File{fd: fd}, nil
+	return &File{client: s, fd: fd}, nil
+}
+
+// FileOpenFlag opens the file with the flags of os.OpenFile. With O_APPEND
+// the offset starts at the end of the file, the protocol has no atomic
+// appends.
+func FileOpenFlag(s *sftp.Client, name string, flag int) (*File, error) {
+	fd, err := s.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_APPEND != 0 {
+		if _, err := fd.Seek(0, io.SeekEnd); err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+	return &File{client: s, fd: fd}, nil
 }
 
 func (f *File) Close() error {
@@ -62,19 +84,42 @@ func (f *File) Read(b []byte) (n int, err error) {
 	return f.fd.Read(b)
 }
 
-// TODO
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
-	return 0,nil
+	return f.fd.ReadAt(b, off)
 }
 
-// TODO
+// Readdir lists the directory with a single request when it is first
+// called, the following calls return the remaining entries.
 func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
-	return nil,nil
+	if f.entries == nil {
+		entries, err := f.client.ReadDir(f.fd.Name())
+		if err != nil {
+			return nil, err
+		}
+		f.entries = append([]os.FileInfo{}, entries...)
+	}
+	rest := f.entries[f.readdir:]
+	if count <= 0 {
+		f.readdir = len(f.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.readdir += count
+	return rest[:count], nil
 }
 
-// TODO
 func (f *File) Readdirnames(n int) (names []string, err error) {
-	return nil,nil
+	fis, err := f.Readdir(n)
+	names = make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	return names, err
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
@@ -85,9 +130,8 @@ func (f *File) Write(b []byte) (n int, err error) {
 	return f.fd.Write(b)
 }
 
-// TODO
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
-	return 0,nil
+	return f.fd.WriteAt(b, off)
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
@@ -0,0 +1,71 @@
+package afero
+
+import (
+	"errors"
+	"os"
+)
+
+// Lstater is an optional interface in Afero. It is only implemented by the
+// filesystems which can tell a symbolic link apart from the file it points to.
+type Lstater interface {
+	// Lstat returns a FileInfo describing the named file. If the file is a
+	// symbolic link, the returned FileInfo describes the link itself.
+	Lstat(name string) (os.FileInfo, error)
+}
+
+// Symlinker is an optional interface in Afero. It is only implemented by the
+// filesystems supporting symbolic links.
+type Symlinker interface {
+	Lstater
+
+	// Symlink creates newname as a symbolic link to oldname.
+	Symlink(oldname, newname string) error
+
+	// Readlink returns the destination of the named symbolic link.
+	Readlink(name string) (string, error)
+}
+
+// The maximum number of symbolic links followed while resolving a single
+// path, same as the Linux kernel.
+const maxSymlinkHops = 40
+
+var ErrNoSymlink = errors.New("symlinks not supported by filesystem")
+
+// Lstat calls Lstat on the filesystem if it is a Lstater, else it falls back
+// to Stat.
+func (a Afero) Lstat(name string) (os.FileInfo, error) {
+	return Lstat(a.Fs, name)
+}
+
+func Lstat(fs Fs, name string) (os.FileInfo, error) {
+	if l, ok := fs.(Lstater); ok {
+		return l.Lstat(name)
+	}
+	return fs.Stat(name)
+}
+
+// Symlink creates newname as a symbolic link to oldname. It returns
+// ErrNoSymlink if the filesystem is not a Symlinker.
+func (a Afero) Symlink(oldname, newname string) error {
+	return Symlink(a.Fs, oldname, newname)
+}
+
+func Symlink(fs Fs, oldname, newname string) error {
+	if s, ok := fs.(Symlinker); ok {
+		return s.Symlink(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+// Readlink returns the destination of the named symbolic link. It returns
+// ErrNoSymlink if the filesystem is not a Symlinker.
+func (a Afero) Readlink(name string) (string, error) {
+	return Readlink(a.Fs, name)
+}
+
+func Readlink(fs Fs, name string) (string, error) {
+	if s, ok := fs.(Symlinker); ok {
+		return s.Readlink(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoSymlink}
+}
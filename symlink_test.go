@@ -0,0 +1,211 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping symlink test on windows")
+	}
+	defer removeAllTestFiles(t)
+	for _, fs := range Fss {
+		tmp := testDir(fs)
+		target := filepath.Join(tmp, "target")
+		link := filepath.Join(tmp, "link")
+
+		if err := WriteFile(fs, target, []byte("content"), 0644); err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+		if err := Symlink(fs, target, link); err != nil {
+			t.Fatal(fs.Name(), "Symlink failed:", err)
+		}
+		if err := Symlink(fs, target, link); !os.IsExist(err) {
+			t.Errorf("%v: Symlink over existing file: expected ErrExist, got %v", fs.Name(), err)
+		}
+
+		dest, err := Readlink(fs, link)
+		if err != nil {
+			t.Fatal(fs.Name(), "Readlink failed:", err)
+		}
+		if dest != target {
+			t.Errorf("%v: Readlink: expected %q, got %q", fs.Name(), target, dest)
+		}
+		if _, err := Readlink(fs, target); err == nil {
+			t.Errorf("%v: Readlink on a regular file should fail", fs.Name())
+		}
+
+		fi, err := Lstat(fs, link)
+		if err != nil {
+			t.Fatal(fs.Name(), "Lstat failed:", err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%v: Lstat: expected symlink mode, got %v", fs.Name(), fi.Mode())
+		}
+
+		fi, err = fs.Stat(link)
+		if err != nil {
+			t.Fatal(fs.Name(), "Stat failed:", err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 || fi.Size() != int64(len("content")) {
+			t.Errorf("%v: Stat should follow the link, got mode %v size %d", fs.Name(), fi.Mode(), fi.Size())
+		}
+
+		data, err := ReadFile(fs, link)
+		if err != nil || string(data) != "content" {
+			t.Errorf("%v: reading through link: got %q, %v", fs.Name(), data, err)
+		}
+
+		if err := fs.Remove(link); err != nil {
+			t.Fatal(fs.Name(), "Remove link failed:", err)
+		}
+		if _, err := fs.Stat(target); err != nil {
+			t.Errorf("%v: removing the link removed the target: %v", fs.Name(), err)
+		}
+	}
+}
+
+func TestSymlinkDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping symlink test on windows")
+	}
+	defer removeAllTestFiles(t)
+	for _, fs := range Fss {
+		tmp := testDir(fs)
+		if err := fs.MkdirAll(filepath.Join(tmp, "real", "sub"), 0777); err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+		if err := WriteFile(fs, filepath.Join(tmp, "real", "sub", "file"), []byte("x"), 0644); err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+		// relative link target, resolved against the directory of the link
+		if err := Symlink(fs, "real", filepath.Join(tmp, "alias")); err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+
+		if _, err := fs.Stat(filepath.Join(tmp, "alias", "sub", "file")); err != nil {
+			t.Errorf("%v: Stat through linked directory failed: %v", fs.Name(), err)
+		}
+
+		var visited []string
+		Walk(fs, tmp, func(path string, info os.FileInfo, err error) error {
+			visited = append(visited, path)
+			return err
+		})
+		for _, p := range visited {
+			if p == filepath.Join(tmp, "alias", "sub") {
+				t.Errorf("%v: Walk followed a symbolic link", fs.Name())
+			}
+		}
+	}
+}
+
+func TestSymlinkLoop(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.Mkdir("/dir", 0777)
+	Symlink(fs, "/dir/b", "/dir/a")
+	Symlink(fs, "/dir/a", "/dir/b")
+
+	if _, err := fs.Stat("/dir/a"); err == nil {
+		t.Error("expected an error statting a symlink loop")
+	}
+	if _, err := fs.Lstat("/dir/a"); err != nil {
+		t.Error("Lstat of a looping link failed:", err)
+	}
+}
+
+func TestBasePathSymlink(t *testing.T) {
+	baseFs := &MemMapFs{}
+	baseFs.MkdirAll("/base/path/dir", 0777)
+	WriteFile(baseFs, "/base/path/dir/file", []byte("content"), 0644)
+	bp := NewBasePathFs(baseFs, "/base/path")
+
+	if err := Symlink(bp, "/dir/file", "/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	dest, err := baseFs.Readlink("/base/path/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest != filepath.Clean("/base/path/dir/file") {
+		t.Errorf("expected link target to be rewritten, got %q", dest)
+	}
+
+	dest, err = Readlink(bp, "/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest != filepath.Clean("/dir/file") {
+		t.Errorf("expected link target relative to the base path, got %q", dest)
+	}
+
+	if data, err := ReadFile(bp, "/link"); err != nil || string(data) != "content" {
+		t.Errorf("reading through link: got %q, %v", data, err)
+	}
+
+	// relative targets are resolved from the directory of the link
+	if err := Symlink(bp, "file", "/dir/rel"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFile(bp, "/dir/rel"); err != nil || string(data) != "content" {
+		t.Errorf("reading through relative link: got %q, %v", data, err)
+	}
+	if err := Symlink(bp, "../dir/file", "/dir/up"); err != nil {
+		t.Error(err)
+	}
+	for _, target := range []string{"../../etc/passwd", "../../path2/file", "../.."} {
+		err := Symlink(bp, target, "/dir/escape")
+		if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != os.ErrPermission {
+			t.Errorf("Symlink(%s): expected ErrPermission, got %v", target, err)
+		}
+	}
+	if _, err := baseFs.Lstat("/base/path/dir/escape"); !os.IsNotExist(err) {
+		t.Errorf("link leaving the base path was created: %v", err)
+	}
+}
+
+func TestCopyOnWriteSymlink(t *testing.T) {
+	base := &MemMapFs{}
+	base.MkdirAll("/home/test", 0777)
+	WriteFile(base, "/home/test/file", []byte("content"), 0644)
+	base.Symlink("/home/test/file", "/home/test/baselink")
+
+	ufs := NewCopyOnWriteFs(NewReadOnlyFs(base), &MemMapFs{})
+
+	if err := Symlink(ufs, "/home/test/file", "/home/test/link"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := base.Lstat("/home/test/link"); err == nil {
+		t.Error("symlink was created in the read only base")
+	}
+	for _, name := range []string{"/home/test/link", "/home/test/baselink"} {
+		fi, err := Lstat(ufs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s: expected symlink mode, got %v", name, fi.Mode())
+		}
+		if dest, err := Readlink(ufs, name); err != nil || dest != "/home/test/file" {
+			t.Errorf("%s: Readlink: got %q, %v", name, dest, err)
+		}
+	}
+}
+
+func TestReadOnlySymlink(t *testing.T) {
+	ro := NewReadOnlyFs(&MemMapFs{})
+	if err := Symlink(ro, "/a", "/b"); err == nil {
+		t.Error("ReadOnlyFs allowed creating a symlink")
+	}
+}
+
+func TestSymlinkUnsupported(t *testing.T) {
+	fs := NewRegexpFs(&MemMapFs{}, nil)
+	if err := Symlink(fs, "/a", "/b"); err == nil {
+		t.Error("expected an error from a filesystem without symlink support")
+	}
+}
@@ -0,0 +1,600 @@
+package afero
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TarFs is a read-only Fs serving the content of a tar archive, for example
+// a release tarball or a container image layer. The archive is indexed once
+// by NewTarFs. Modes, modification times, symbolic links and hard links are
+// taken from the tar headers; directories missing from the archive are
+// synthesized. All writes fail with EPERM; wrap it in a CopyOnWriteFs for a
+// writable overlay.
+//
+// If the archive is neither compressed nor readable with ReadAt, or if it is
+// compressed, the content of the files is kept in memory.
+type TarFs struct {
+	files map[string]*tarEntry
+}
+
+// tarNode is the content and the header of a file, shared between all hard
+// links to it.
+type tarNode struct {
+	hdr  *tar.Header
+	data io.ReaderAt
+	size int64
+}
+
+type tarEntry struct {
+	name     string
+	node     *tarNode // nil for synthesized directories
+	dir      bool
+	children map[string]bool
+	sorted   []string
+}
+
+// TarDecompressor returns a reader decompressing r.
+type TarDecompressor func(r io.Reader) (io.Reader, error)
+
+type tarDecompressor struct {
+	magic []byte
+	d     TarDecompressor
+}
+
+// gzip is supported out of the box
+var gzipDecompressor = tarDecompressor{
+	magic: []byte{0x1f, 0x8b},
+	d:     func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+}
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// TarOption configures the TarFs returned by NewTarFs.
+type TarOption func(*tarOptions)
+
+type tarOptions struct {
+	decompressors []tarDecompressor
+}
+
+// WithTarDecompressor makes NewTarFs decompress archives starting with
+// magic. gzip is supported out of the box. zstd is recognized but needs a
+// decompressor, for example with github.com/klauspost/compress/zstd:
+//
+//	fs, err := afero.NewTarFs(f, afero.WithTarDecompressor([]byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.Reader, error) {
+//		return zstd.NewReader(r)
+//	}))
+func WithTarDecompressor(magic []byte, d TarDecompressor) TarOption {
+	return func(o *tarOptions) {
+		o.decompressors = append(o.decompressors, tarDecompressor{magic: magic, d: d})
+	}
+}
+
+func (o *tarOptions) decompressorFor(header []byte) TarDecompressor {
+	// the last option wins
+	for i := len(o.decompressors) - 1; i >= 0; i-- {
+		if bytes.HasPrefix(header, o.decompressors[i].magic) {
+			return o.decompressors[i].d
+		}
+	}
+	return nil
+}
+
+var ErrTarZstd = errors.New("zstd compressed tar archive, see WithTarDecompressor")
+
+// countingReader counts the bytes read, the offset of the file content in
+// an uncompressed archive.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingSeeker lets the tar.Reader seek over file content it skips.
+type countingSeeker struct {
+	countingReader
+}
+
+func (c *countingSeeker) Seek(offset int64, whence int) (int64, error) {
+	n, err := c.r.(io.Seeker).Seek(offset, whence)
+	if err == nil {
+		c.n = n
+	}
+	return n, err
+}
+
+// NewTarFs indexes the tar archive read from r, which may be gzip
+// compressed or use any compression given with WithTarDecompressor. If r is
+// an uncompressed archive implementing io.ReaderAt, such as an *os.File, the
+// files are read in place and r must stay open while the TarFs is in use.
+func NewTarFs(r io.Reader, opts ...TarOption) (Fs, error) {
+	o := tarOptions{decompressors: []tarDecompressor{gzipDecompressor}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var header []byte
+	ra, inPlace := r.(io.ReaderAt)
+	if inPlace {
+		header = make([]byte, 8)
+		n, err := ra.ReadAt(header, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		header = header[:n]
+		r = io.NewSectionReader(ra, 0, math.MaxInt64)
+	} else {
+		br := bufio.NewReader(r)
+		header, _ = br.Peek(8)
+		r = br
+	}
+	if d := o.decompressorFor(header); d != nil {
+		dr, err := d(r)
+		if err != nil {
+			return nil, err
+		}
+		r, inPlace = dr, false
+	} else if bytes.HasPrefix(header, zstdMagic) {
+		return nil, ErrTarZstd
+	}
+
+	fs := &TarFs{files: make(map[string]*tarEntry)}
+	fs.files[FilePathSeparator] = &tarEntry{name: FilePathSeparator, dir: true, children: make(map[string]bool)}
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+	if inPlace {
+		cs := &countingSeeker{*cr}
+		cr, tr = &cs.countingReader, tar.NewReader(cs)
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := fs.index(hdr, tr, cr.n, inPlace, ra); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range fs.files {
+		if e.dir {
+			e.sorted = make([]string, 0, len(e.children))
+			for name := range e.children {
+				e.sorted = append(e.sorted, name)
+			}
+			sort.Strings(e.sorted)
+		}
+	}
+	return fs, nil
+}
+
+// index adds the entry described by hdr, whose content starts at offset.
+func (fs *TarFs) index(hdr *tar.Header, tr *tar.Reader, offset int64, inPlace bool, ra io.ReaderAt) error {
+	name := tarPath(hdr.Name)
+	node := &tarNode{hdr: hdr}
+	switch hdr.Typeflag {
+	case tar.TypeLink:
+		target, ok := fs.files[tarPath(hdr.Linkname)]
+		if !ok || target.node == nil || target.dir {
+			return &os.LinkError{Op: "link", Old: hdr.Linkname, New: hdr.Name, Err: os.ErrNotExist}
+		}
+		node = target.node
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+		node.size = hdr.Size
+		if inPlace && !tarSparse(hdr) {
+			node.data = io.NewSectionReader(ra, offset, hdr.Size)
+			break
+		}
+		// the size of sparse files in the archive is not their real size
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		node.data, node.size = bytes.NewReader(data), int64(len(data))
+	}
+
+	if name == FilePathSeparator {
+		fs.files[name].node = node
+		return nil
+	}
+	e := &tarEntry{name: name, node: node, dir: hdr.Typeflag == tar.TypeDir}
+	if old, ok := fs.files[name]; ok && old.dir && e.dir {
+		// a directory entry following its content
+		e.children = old.children
+	} else if ok && old.dir {
+		fs.removeTree(name)
+	}
+	if e.dir && e.children == nil {
+		e.children = make(map[string]bool)
+	}
+	fs.add(e)
+	return nil
+}
+
+func tarSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds e to the index, synthesizing its parent directories. A file
+// in the way of a parent directory is replaced, as when extracting.
+func (fs *TarFs) add(e *tarEntry) {
+	fs.files[e.name] = e
+	parentName := filepath.Dir(e.name)
+	parent, ok := fs.files[parentName]
+	if !ok || !parent.dir {
+		parent = &tarEntry{name: parentName, dir: true, children: make(map[string]bool)}
+		fs.add(parent)
+	}
+	parent.children[filepath.Base(e.name)] = true
+}
+
+// removeTree removes a directory replaced by a later entry.
+func (fs *TarFs) removeTree(name string) {
+	prefix := name + FilePathSeparator
+	for p := range fs.files {
+		if strings.HasPrefix(p, prefix) {
+			delete(fs.files, p)
+		}
+	}
+}
+
+// tarPath turns the slash separated name of a tar entry into an absolute
+// path. Names trying to escape the root with ".." end up below it.
+func tarPath(name string) string {
+	return filepath.FromSlash(path.Clean("/" + name))
+}
+
+// resolve returns the entry of name, following symbolic links in all path
+// elements, and in the last one if followLast is set.
+func (fs *TarFs) resolve(op, name string, followLast bool) (*tarEntry, error) {
+	rest := strings.Split(tarPath(filepath.ToSlash(name)), FilePathSeparator)[1:]
+	cur := fs.files[FilePathSeparator]
+	hops := 0
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+		if elem == "" {
+			continue
+		}
+		if !cur.dir {
+			return nil, &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+		next, ok := fs.files[filepath.Join(cur.name, elem)]
+		if !ok {
+			return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+		}
+		if next.node != nil && next.node.hdr.Typeflag == tar.TypeSymlink && (len(rest) > 0 || followLast) {
+			if hops++; hops > maxSymlinkHops {
+				return nil, &os.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+			}
+			target := filepath.FromSlash(next.node.hdr.Linkname)
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(cur.name, target)
+			}
+			// restart from the root with the target and the remaining path
+			rest = append(strings.Split(tarPath(filepath.ToSlash(target)), FilePathSeparator)[1:], rest...)
+			cur = fs.files[FilePathSeparator]
+			continue
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+func (fs *TarFs) Name() string {
+	return "TarFs"
+}
+
+func (fs *TarFs) Open(name string) (File, error) {
+	e, err := fs.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &tarFile{fs: fs, entry: e, name: name}, nil
+}
+
+func (fs *TarFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+	}
+	return fs.Open(name)
+}
+
+func (fs *TarFs) Stat(name string) (os.FileInfo, error) {
+	e, err := fs.resolve("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(), nil
+}
+
+func (fs *TarFs) Lstat(name string) (os.FileInfo, error) {
+	e, err := fs.resolve("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(), nil
+}
+
+func (fs *TarFs) Readlink(name string) (string, error) {
+	e, err := fs.resolve("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if e.node == nil || e.node.hdr.Typeflag != tar.TypeSymlink {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return filepath.FromSlash(e.node.hdr.Linkname), nil
+}
+
+func (fs *TarFs) Symlink(oldname, newname string) error {
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EPERM}
+}
+
+func (fs *TarFs) Link(oldname, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+}
+
+func (fs *TarFs) Create(name string) (File, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *TarFs) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *TarFs) MkdirAll(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *TarFs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *TarFs) RemoveAll(name string) error {
+	return &os.PathError{Op: "removeall", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *TarFs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EPERM}
+}
+
+func (fs *TarFs) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *TarFs) Chtimes(name string, atime, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EPERM}
+}
+
+func (e *tarEntry) info() os.FileInfo {
+	return &tarFileInfo{e}
+}
+
+// tarFileInfo is the FileInfo of a tar entry, with the mode, size and times
+// of its header.
+type tarFileInfo struct {
+	*tarEntry
+}
+
+func (fi *tarFileInfo) Name() string {
+	return filepath.Base(fi.name)
+}
+
+func (fi *tarFileInfo) Size() int64 {
+	if fi.node == nil {
+		return 0
+	}
+	if fi.node.hdr.Typeflag == tar.TypeSymlink {
+		return int64(len(fi.node.hdr.Linkname))
+	}
+	return fi.node.size
+}
+
+func (fi *tarFileInfo) Mode() os.FileMode {
+	if fi.node == nil {
+		return os.ModeDir | 0555
+	}
+	mode := fi.node.hdr.FileInfo().Mode()
+	if fi.dir {
+		// the root, if it is stored in the archive
+		mode |= os.ModeDir
+	}
+	return mode
+}
+
+func (fi *tarFileInfo) ModTime() time.Time {
+	if fi.node == nil {
+		return time.Time{}
+	}
+	return fi.node.hdr.ModTime
+}
+
+func (fi *tarFileInfo) IsDir() bool {
+	return fi.dir
+}
+
+// Sys returns the *tar.Header of the file, nil for synthesized directories.
+func (fi *tarFileInfo) Sys() interface{} {
+	if fi.node == nil {
+		return nil
+	}
+	return fi.node.hdr
+}
+
+type tarFile struct {
+	fs    *TarFs
+	entry *tarEntry
+	name  string
+
+	mu     sync.Mutex
+	closed bool
+	offset int64
+	// next entry returned by Readdir
+	readdirPos int
+}
+
+func (f *tarFile) Name() string {
+	return f.name
+}
+
+func (f *tarFile) Stat() (os.FileInfo, error) {
+	return f.entry.info(), nil
+}
+
+func (f *tarFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrFileClosed
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *tarFile) check(op string) error {
+	if f.closed {
+		return ErrFileClosed
+	}
+	if f.entry.dir {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	}
+	return nil
+}
+
+func (f *tarFile) readAt(p []byte, off int64) (int, error) {
+	node := f.entry.node
+	if node.data == nil || off >= node.size {
+		// devices, fifos and the like have no content
+		return 0, io.EOF
+	}
+	return node.data.ReadAt(p, off)
+}
+
+func (f *tarFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *tarFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: syscall.EINVAL}
+	}
+	return f.readAt(p, off)
+}
+
+func (f *tarFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.entry.info().Size()
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *tarFile) Readdir(count int) ([]os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, ErrFileClosed
+	}
+	if !f.entry.dir {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	names := f.entry.sorted[f.readdirPos:]
+	if count > 0 {
+		if len(names) == 0 {
+			return []os.FileInfo{}, io.EOF
+		}
+		if len(names) > count {
+			names = names[:count]
+		}
+	}
+	f.readdirPos += len(names)
+	list := make([]os.FileInfo, len(names))
+	for i, name := range names {
+		list[i] = f.fs.files[filepath.Join(f.entry.name, name)].info()
+	}
+	return list, nil
+}
+
+func (f *tarFile) Readdirnames(n int) ([]string, error) {
+	list, err := f.Readdir(n)
+	names := make([]string, len(list))
+	for i, fi := range list {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
+
+func (f *tarFile) Sync() error {
+	return nil
+}
+
+func (f *tarFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *tarFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *tarFile) WriteString(s string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EPERM}
+}
+
+func (f *tarFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EPERM}
+}
@@ -0,0 +1,307 @@
+package afero
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var tarTestTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newTestTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		hdr     tar.Header
+		content string
+	}{
+		{tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0750}, ""},
+		{tar.Header{Name: "usr/lib/libc.so", Typeflag: tar.TypeReg, Mode: 0755}, "libc"},
+		{tar.Header{Name: "usr/share/doc/README", Typeflag: tar.TypeReg, Mode: 0644}, "read me"},
+		{tar.Header{Name: "lib", Typeflag: tar.TypeSymlink, Linkname: "usr/lib"}, ""},
+		{tar.Header{Name: "usr/share/doc/abs", Typeflag: tar.TypeSymlink, Linkname: "/usr/lib/libc.so"}, ""},
+		{tar.Header{Name: "usr/share/doc/rel", Typeflag: tar.TypeSymlink, Linkname: "../../lib/libc.so"}, ""},
+		{tar.Header{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "loop"}, ""},
+		{tar.Header{Name: "usr/bin/hard", Typeflag: tar.TypeLink, Linkname: "usr/share/doc/README"}, ""},
+		{tar.Header{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0600}, "escape"},
+	} {
+		hdr := e.hdr
+		hdr.ModTime = tarTestTime
+		hdr.Size = int64(len(e.content))
+		if err := w.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, e.content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkTarFs(t *testing.T, fs Fs) {
+	for _, test := range []struct {
+		name    string
+		mode    os.FileMode
+		content string
+	}{
+		{"/usr", os.ModeDir | 0750, ""},
+		{"/usr/share", os.ModeDir | 0555, ""},
+		{"/usr/lib/libc.so", 0755, "libc"},
+		{"/lib/libc.so", 0755, "libc"},
+		{"/usr/share/doc/abs", 0755, "libc"},
+		{"/usr/share/doc/rel", 0755, "libc"},
+		{"/usr/bin/hard", 0644, "read me"},
+		{"/escape", 0600, "escape"},
+	} {
+		fi, err := fs.Stat(test.name)
+		if err != nil {
+			t.Errorf("Stat(%q): %v", test.name, err)
+			continue
+		}
+		if fi.Mode() != test.mode {
+			t.Errorf("Stat(%q): expected mode %v, got %v", test.name, test.mode, fi.Mode())
+		}
+		if fi.IsDir() {
+			continue
+		}
+		if !fi.ModTime().Equal(tarTestTime) {
+			t.Errorf("Stat(%q): unexpected mtime %v", test.name, fi.ModTime())
+		}
+		if data, err := ReadFile(fs, test.name); err != nil || string(data) != test.content {
+			t.Errorf("ReadFile(%q): got %q, %v", test.name, data, err)
+		}
+	}
+
+	fi, err := Lstat(fs, "/lib")
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Lstat(/lib): got %v, %v", fi, err)
+	}
+	if target, err := Readlink(fs, "/usr/share/doc/rel"); err != nil || target != "../../lib/libc.so" {
+		t.Errorf("Readlink: got %q, %v", target, err)
+	}
+	if _, err := fs.Stat("/loop"); err == nil {
+		t.Error("Stat of a symlink loop should fail")
+	}
+	if _, err := fs.Stat("/usr/lib/libc.so/x"); err == nil {
+		t.Error("Stat below a file should fail")
+	}
+	if _, err := fs.Stat("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a missing file: expected ErrNotExist, got %v", err)
+	}
+
+	var walked []string
+	Walk(fs, "/", func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	want := []string{"/", "/escape", "/lib", "/loop", "/usr", "/usr/bin", "/usr/bin/hard",
+		"/usr/lib", "/usr/lib/libc.so", "/usr/share", "/usr/share/doc",
+		"/usr/share/doc/README", "/usr/share/doc/abs", "/usr/share/doc/rel"}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("Walk: got %v, want %v", walked, want)
+	}
+
+	f, err := fs.Open("/usr/share/doc/README")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 10)
+	if n, err := f.ReadAt(buf, 5); n != 2 || err != io.EOF || string(buf[:n]) != "me" {
+		t.Errorf("short ReadAt: got %d, %q, %v", n, buf[:n], err)
+	}
+	if _, err := f.Seek(-2, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if rest, err := io.ReadAll(f); err != nil || string(rest) != "me" {
+		t.Errorf("Read after Seek: got %q, %v", rest, err)
+	}
+	if _, err := f.Write([]byte("x")); !os.IsPermission(err) {
+		t.Errorf("Write: expected EPERM, got %v", err)
+	}
+	if err := fs.Mkdir("/new", 0755); !os.IsPermission(err) {
+		t.Errorf("Mkdir: expected EPERM, got %v", err)
+	}
+}
+
+func TestTarFs(t *testing.T) {
+	archive := newTestTar(t)
+
+	// read in place
+	fs, err := NewTarFs(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTarFs(t, fs)
+
+	// buffered from a stream
+	fs, err = NewTarFs(bytes.NewBuffer(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTarFs(t, fs)
+}
+
+func TestTarFsCompressed(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(newTestTar(t))
+	zw.Close()
+
+	fs, err := NewTarFs(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTarFs(t, fs)
+
+	zstd := append([]byte{0x28, 0xb5, 0x2f, 0xfd}, "not really zstd"...)
+	if _, err := NewTarFs(bytes.NewReader(zstd)); err != ErrTarZstd {
+		t.Errorf("expected ErrTarZstd, got %v", err)
+	}
+
+	// a toy compression prefixing the archive with a magic
+	toy := WithTarDecompressor([]byte("TOY!"), func(r io.Reader) (io.Reader, error) {
+		_, err := io.ReadFull(r, make([]byte, 4))
+		return r, err
+	})
+	fs, err = NewTarFs(bytes.NewReader(append([]byte("TOY!"), newTestTar(t)...)), toy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTarFs(t, fs)
+	// the decompressor is not registered globally
+	if _, err := NewTarFs(bytes.NewReader(append([]byte("TOY!"), newTestTar(t)...))); err == nil {
+		t.Error("expected an error without the toy decompressor")
+	}
+}
+
+// zstdStored returns data as a zstd frame of uncompressed blocks.
+func zstdStored(data []byte) []byte {
+	const blockSize = 128 << 10
+	// no checksum and no content size, a window of 128 KiB
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38}
+	for last := false; !last; {
+		n := len(data)
+		if n > blockSize {
+			n = blockSize
+		}
+		last = n == len(data)
+		h := n << 3
+		if last {
+			h |= 1
+		}
+		frame = append(frame, byte(h), byte(h>>8), byte(h>>16))
+		frame = append(frame, data[:n]...)
+		data = data[n:]
+	}
+	return frame
+}
+
+// unzstdStored decompresses a zstd frame of uncompressed and RLE blocks,
+// as written by zstd for data it cannot compress.
+func unzstdStored(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	fhd := header[4]
+	skip := []int{0, 1, 2, 4}[fhd&3] + []int{0, 2, 4, 8}[fhd>>6]
+	if fhd&0x20 == 0 {
+		skip++ // window descriptor
+	} else if fhd>>6 == 0 {
+		skip++ // single segment content size
+	}
+	if _, err := br.Discard(skip); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	for last := false; !last; {
+		bh := make([]byte, 3)
+		if _, err := io.ReadFull(br, bh); err != nil {
+			return nil, err
+		}
+		h := int(bh[0]) | int(bh[1])<<8 | int(bh[2])<<16
+		last = h&1 == 1
+		size := h >> 3
+		switch (h >> 1) & 3 {
+		case 0:
+			if _, err := io.CopyN(&out, br, int64(size)); err != nil {
+				return nil, err
+			}
+		case 1:
+			b, err := br.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			out.Write(bytes.Repeat([]byte{b}, size))
+		default:
+			return nil, errors.New("compressed zstd blocks are not supported")
+		}
+	}
+	if fhd&4 != 0 {
+		if _, err := br.Discard(4); err != nil {
+			return nil, err
+		}
+	}
+	return &out, nil
+}
+
+func TestTarFsZstd(t *testing.T) {
+	archive := zstdStored(newTestTar(t))
+	if _, err := NewTarFs(bytes.NewReader(archive)); err != ErrTarZstd {
+		t.Errorf("expected ErrTarZstd, got %v", err)
+	}
+
+	zstd := WithTarDecompressor([]byte{0x28, 0xb5, 0x2f, 0xfd}, unzstdStored)
+	fs, err := NewTarFs(bytes.NewReader(archive), zstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTarFs(t, fs)
+	fs, err = NewTarFs(bytes.NewBuffer(archive), zstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTarFs(t, fs)
+}
+
+func TestTarFsReplacedEntries(t *testing.T) {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, hdr := range []tar.Header{
+		{Name: "a/b", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "a", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "c", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "c/d", Typeflag: tar.TypeReg, Mode: 0644},
+	} {
+		hdr := hdr
+		w.WriteHeader(&hdr)
+	}
+	w.Close()
+
+	fs, err := NewTarFs(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat("/a"); err != nil || fi.IsDir() {
+		t.Errorf("a directory replaced by a file: got %v, %v", fi, err)
+	}
+	if _, err := fs.Stat("/a/b"); err == nil {
+		t.Error("the content of a replaced directory should be gone")
+	}
+	if fi, err := fs.Stat("/c"); err != nil || !fi.IsDir() {
+		t.Errorf("a file replaced by a directory: got %v, %v", fi, err)
+	}
+	if _, err := fs.Stat("/c/d"); err != nil {
+		t.Error(err)
+	}
+}
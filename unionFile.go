@@ -4,6 +4,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 )
 
@@ -20,11 +22,15 @@ import (
 // the operations will be done in both layers, starting with the overlay. A
 // successful read in the overlay will move the cursor position in the base layer
 // by the number of bytes read.
+//
+// With whiteouts set, the whiteouts of a CopyOnWriteFs are hidden from the
+// overlay's directory listing together with the base entries they delete.
 type UnionFile struct {
-	base  File
-	layer File
-	off   int
-	files []os.FileInfo
+	base      File
+	layer     File
+	whiteouts bool
+	off       int
+	files     []os.FileInfo
 }
 
 func (f *UnionFile) Close() error {
@@ -40,6 +46,35 @@ func (f *UnionFile) Close() error {
 	return BADFD
 }
 
+// lockTarget is the file holding the advisory locks of the union, the
+// overlay if the file is open there.
+func (f *UnionFile) lockTarget() File {
+	if f.layer != nil {
+		return f.layer
+	}
+	return f.base
+}
+
+func (f *UnionFile) Lock() error {
+	return LockFile(f.lockTarget())
+}
+
+func (f *UnionFile) RLock() error {
+	return RLockFile(f.lockTarget())
+}
+
+func (f *UnionFile) TryLock() (bool, error) {
+	return TryLockFile(f.lockTarget())
+}
+
+func (f *UnionFile) TryRLock() (bool, error) {
+	return TryRLockFile(f.lockTarget())
+}
+
+func (f *UnionFile) Unlock() error {
+	return UnlockFile(f.lockTarget())
+}
+
 func (f *UnionFile) Read(s []byte) (int, error) {
 	if f.layer != nil {
 		n, err := f.layer.Read(s)
@@ -126,8 +161,10 @@ func (f *UnionFile) Name() string {
 // Readdir will weave the two directories together and
 // return a single view of the overlayed directories
 func (f *UnionFile) Readdir(c int) (ofi []os.FileInfo, err error) {
-	if f.off == 0 {
+	if f.files == nil {
 		var files = make(map[string]os.FileInfo)
+		var hidden = make(map[string]bool)
+		var opaque bool
 		var rfi []os.FileInfo
 		if f.layer != nil {
 			rfi, err = f.layer.Readdir(-1)
@@ -135,30 +172,50 @@ func (f *UnionFile) Readdir(c int) (ofi []os.FileInfo, err error) {
 				return nil, err
 			}
 			for _, fi := range rfi {
+				if f.whiteouts && strings.HasPrefix(fi.Name(), whiteoutPrefix) {
+					if fi.Name() == whiteoutOpaqueDir {
+						opaque = true
+					} else {
+						hidden[strings.TrimPrefix(fi.Name(), whiteoutPrefix)] = true
+					}
+					continue
+				}
 				files[fi.Name()] = fi
 			}
 		}
 
-		if f.base != nil {
+		if f.base != nil && !opaque {
 			rfi, err = f.base.Readdir(-1)
 			if err != nil {
 				return nil, err
 			}
 			for _, fi := range rfi {
-				if _, exists := files[fi.Name()]; !exists {
+				if _, exists := files[fi.Name()]; !exists && !hidden[fi.Name()] {
 					files[fi.Name()] = fi
 				}
 			}
 		}
+		f.files = make([]os.FileInfo, 0, len(files))
 		for _, fi := range files {
 			f.files = append(f.files, fi)
 		}
+		sort.Sort(byName(f.files))
+	}
+	if c <= 0 {
+		ofi = f.files[f.off:]
+		f.off = len(f.files)
+		return ofi, nil
+	}
+	if f.off >= len(f.files) {
+		return nil, io.EOF
 	}
-	if c == -1 {
-		return f.files[f.off:], nil
+	end := f.off + c
+	if end > len(f.files) {
+		end = len(f.files)
 	}
-	defer func() { f.off += c }()
-	return f.files[f.off:c], nil
+	ofi = f.files[f.off:end]
+	f.off = end
+	return ofi, nil
 }
 
 func (f *UnionFile) Readdirnames(c int) ([]string, error) {
@@ -270,5 +327,8 @@ func copyToLayer(base Fs, layer Fs, name string) error {
 		lfh.Close()
 		return err
 	}
+	if err = layer.Chmod(name, bfi.Mode()); err != nil {
+		return err
+	}
 	return layer.Chtimes(name, bfi.ModTime(), bfi.ModTime())
 }
@@ -0,0 +1,156 @@
+package afero
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// WatchOp is a set of changes reported by a watch.
+type WatchOp uint32
+
+const (
+	// WatchCreate reports a new file, directory or link.
+	WatchCreate WatchOp = 1 << iota
+	// WatchWrite reports a file written to, when it is closed.
+	WatchWrite
+	// WatchRemove reports a removed entry.
+	WatchRemove
+	// WatchRename reports the old name of a renamed entry, the new name
+	// is reported by a WatchCreate.
+	WatchRename
+	// WatchChmod reports a change of the mode, owner or times.
+	WatchChmod
+)
+
+func (op WatchOp) String() string {
+	var names []string
+	for _, o := range []struct {
+		op   WatchOp
+		name string
+	}{
+		{WatchCreate, "CREATE"},
+		{WatchWrite, "WRITE"},
+		{WatchRemove, "REMOVE"},
+		{WatchRename, "RENAME"},
+		{WatchChmod, "CHMOD"},
+	} {
+		if op&o.op != 0 {
+			names = append(names, o.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// WatchEvent is a change of the named entry.
+type WatchEvent struct {
+	Name string
+	Op   WatchOp
+}
+
+func (e WatchEvent) String() string {
+	return e.Op.String() + " " + e.Name
+}
+
+// Watcher is an optional interface in Afero. It is only implemented by the
+// filesystems which can report the changes of their files.
+type Watcher interface {
+	// Watch reports the changes of the named file or directory and of the
+	// entries in the directory. If recursive is set, the changes of all
+	// entries below the directory are reported as well.
+	Watch(name string, recursive bool) (*WatchHandle, error)
+}
+
+var (
+	ErrNoWatch = errors.New("watching not supported by filesystem")
+	// ErrWatchOverflow is reported when events were dropped because they
+	// were not received fast enough.
+	ErrWatchOverflow = errors.New("watch event queue overflow")
+)
+
+// The number of events and errors queued for a watch before they are
+// dropped, writing to a watched filesystem never blocks on its watches.
+const (
+	watchEventQueue = 256
+	watchErrorQueue = 16
+)
+
+// WatchHandle is a running watch returned by Watch.
+type WatchHandle struct {
+	// Events receives the changes, in the order they happened. It is
+	// closed by Close.
+	Events <-chan WatchEvent
+	// Errors receives the errors of the watch, like ErrWatchOverflow. It
+	// is closed by Close.
+	Errors <-chan error
+
+	close func() error
+	once  sync.Once
+	err   error
+}
+
+// Close stops the watch and closes the channels.
+func (w *WatchHandle) Close() error {
+	w.once.Do(func() {
+		w.err = w.close()
+	})
+	return w.err
+}
+
+// Watch reports the changes of the named file or directory. It returns
+// ErrNoWatch if the filesystem is not a Watcher.
+func (a Afero) Watch(name string, recursive bool) (*WatchHandle, error) {
+	return Watch(a.Fs, name, recursive)
+}
+
+func Watch(fs Fs, name string, recursive bool) (*WatchHandle, error) {
+	if w, ok := fs.(Watcher); ok {
+		return w.Watch(name, recursive)
+	}
+	return nil, &os.PathError{Op: "watch", Path: name, Err: ErrNoWatch}
+}
+
+// mapWatch returns a watch forwarding the events of w rewritten by fn,
+// events for which fn returns false are dropped.
+func mapWatch(w *WatchHandle, fn func(WatchEvent) (WatchEvent, bool)) *WatchHandle {
+	events := make(chan WatchEvent, watchEventQueue)
+	errs := make(chan error, watchErrorQueue)
+	// closed by Close, what the consumer no longer receives is dropped
+	stop := make(chan struct{})
+	eventsDone := make(chan struct{})
+	errsDone := make(chan struct{})
+	go func() {
+		defer close(eventsDone)
+		defer close(events)
+		for ev := range w.Events {
+			if ev, ok := fn(ev); ok {
+				select {
+				case events <- ev:
+				case <-stop:
+				}
+			}
+		}
+	}()
+	go func() {
+		defer close(errsDone)
+		defer close(errs)
+		for err := range w.Errors {
+			select {
+			case errs <- err:
+			case <-stop:
+			}
+		}
+	}()
+	return &WatchHandle{
+		Events: events,
+		Errors: errs,
+		close: func() error {
+			close(stop)
+			err := w.Close()
+			<-eventsDone
+			<-errsDone
+			return err
+		},
+	}
+}
@@ -0,0 +1,243 @@
+package afero
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_MOVE_SELF | syscall.IN_ATTRIB
+
+// The IN_MOVED_TO event of a directory moved within the watched tree need
+// not be read together with its IN_MOVED_FROM. A directory moved away is
+// only taken for moved out of the tree after moveTimeout, or when more than
+// maxPendingMoves directories are waiting.
+const (
+	moveTimeout     = time.Second
+	maxPendingMoves = 256
+)
+
+// Watch reports the changes of the named file or directory with inotify.
+// A recursive watch adds the directories created below name as they show
+// up, entries created in such a directory before it is watched are not
+// reported.
+func (OsFs) Watch(name string, recursive bool) (*WatchHandle, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	w := &inotifyWatch{
+		// a non-blocking file is read through the runtime poller, so
+		// closing it stops a pending read
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		fd:        fd,
+		root:      filepath.Clean(name),
+		recursive: recursive,
+		paths:     make(map[int32]string),
+		moves:     make(map[uint32]pendingMove),
+		events:    make(chan WatchEvent, watchEventQueue),
+		errs:      make(chan error, watchErrorQueue),
+		done:      make(chan struct{}),
+	}
+	if err := w.add(w.root); err != nil {
+		w.file.Close()
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	if recursive {
+		w.addTree(w.root)
+	}
+	go w.run()
+	return &WatchHandle{Events: w.events, Errors: w.errs, close: w.close}, nil
+}
+
+type inotifyWatch struct {
+	file      *os.File
+	fd        int
+	root      string
+	recursive bool
+	// the paths of the watch descriptors, only used by run once started
+	paths map[int32]string
+	// the directories moved away, by cookie, until they are moved in
+	moves  map[uint32]pendingMove
+	events chan WatchEvent
+	errs   chan error
+	done   chan struct{}
+}
+
+// pendingMove is a directory moved away at time when.
+type pendingMove struct {
+	dir  string
+	when time.Time
+}
+
+func (w *inotifyWatch) add(name string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, name, inotifyMask)
+	if err != nil {
+		return err
+	}
+	w.paths[int32(wd)] = name
+	return nil
+}
+
+// addTree watches the directories below dir. Directories removed in the
+// meantime are skipped.
+func (w *inotifyWatch) addTree(dir string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || path == dir {
+			return nil
+		}
+		if err := w.add(path); err != nil && err != syscall.ENOENT {
+			w.error(&os.PathError{Op: "watch", Path: path, Err: err})
+		}
+		return nil
+	})
+}
+
+// unwatch stops watching dir and the directories below it.
+func (w *inotifyWatch) unwatch(dir string) {
+	for wd, path := range w.paths {
+		if path == dir || strings.HasPrefix(path, dir+FilePathSeparator) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.paths, wd)
+		}
+	}
+}
+
+// rename updates the paths of the watches below a moved directory.
+func (w *inotifyWatch) rename(oldname, newname string) {
+	for wd, path := range w.paths {
+		if path == oldname || strings.HasPrefix(path, oldname+FilePathSeparator) {
+			w.paths[wd] = newname + path[len(oldname):]
+		}
+	}
+}
+
+func (w *inotifyWatch) run() {
+	defer close(w.done)
+	defer close(w.errs)
+	defer close(w.events)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.error(err)
+			}
+			return
+		}
+		// stop watching the directories moved out of the tree before
+		// their events are taken for changes within it
+		w.expireMoves(time.Now())
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			off += syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[off:off+int(raw.Len)]), "\x00")
+			off += int(raw.Len)
+			w.handle(raw.Wd, raw.Mask, raw.Cookie, name)
+		}
+	}
+}
+
+// expireMoves stops watching the directories moved out of the watched tree.
+func (w *inotifyWatch) expireMoves(now time.Time) {
+	for cookie, m := range w.moves {
+		if now.Sub(m.when) >= moveTimeout {
+			w.unwatch(m.dir)
+			delete(w.moves, cookie)
+		}
+	}
+	for len(w.moves) > maxPendingMoves {
+		var oldest uint32
+		first := true
+		for cookie, m := range w.moves {
+			if first || m.when.Before(w.moves[oldest].when) {
+				oldest, first = cookie, false
+			}
+		}
+		w.unwatch(w.moves[oldest].dir)
+		delete(w.moves, oldest)
+	}
+}
+
+func (w *inotifyWatch) handle(wd int32, mask, cookie uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.error(ErrWatchOverflow)
+		return
+	}
+	dir, ok := w.paths[wd]
+	if !ok {
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		return
+	}
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	} else if path != w.root {
+		// the changes of a directory itself are reported by its parent
+		return
+	}
+
+	var op WatchOp
+	switch {
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		op = WatchCreate
+	case mask&syscall.IN_CLOSE_WRITE != 0:
+		op = WatchWrite
+	case mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF) != 0:
+		op = WatchRemove
+	case mask&(syscall.IN_MOVED_FROM|syscall.IN_MOVE_SELF) != 0:
+		op = WatchRename
+	case mask&syscall.IN_ATTRIB != 0:
+		op = WatchChmod
+	default:
+		return
+	}
+
+	if w.recursive && mask&syscall.IN_ISDIR != 0 && name != "" {
+		switch {
+		case mask&syscall.IN_MOVED_FROM != 0:
+			w.moves[cookie] = pendingMove{dir: path, when: time.Now()}
+		case mask&syscall.IN_MOVED_TO != 0 && w.moves[cookie].dir != "":
+			w.rename(w.moves[cookie].dir, path)
+			delete(w.moves, cookie)
+		case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			if err := w.add(path); err == nil {
+				w.addTree(path)
+			} else if err != syscall.ENOENT {
+				w.error(&os.PathError{Op: "watch", Path: path, Err: err})
+			}
+		}
+	}
+	w.send(WatchEvent{Name: path, Op: op})
+}
+
+func (w *inotifyWatch) send(ev WatchEvent) {
+	select {
+	case w.events <- ev:
+	default:
+		w.error(ErrWatchOverflow)
+	}
+}
+
+func (w *inotifyWatch) error(err error) {
+	select {
+	case w.errs <- err:
+	default:
+	}
+}
+
+func (w *inotifyWatch) close() error {
+	err := w.file.Close()
+	<-w.done
+	return err
+}
@@ -0,0 +1,35 @@
+package afero
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInotifyWatchPendingMoves(t *testing.T) {
+	w := &inotifyWatch{
+		fd:        -1,
+		recursive: true,
+		paths:     map[int32]string{1: "/dir", 2: "/dir/a", 3: "/dir/b", 4: "/dir/a/sub"},
+		moves:     make(map[uint32]pendingMove),
+		events:    make(chan WatchEvent, watchEventQueue),
+		errs:      make(chan error, watchErrorQueue),
+	}
+	w.handle(1, syscall.IN_MOVED_FROM|syscall.IN_ISDIR, 7, "a")
+	w.handle(1, syscall.IN_MOVED_FROM|syscall.IN_ISDIR, 8, "b")
+
+	// the IN_MOVED_TO of a is read later, b is not moved within the tree
+	w.expireMoves(time.Now())
+	w.handle(1, syscall.IN_MOVED_TO|syscall.IN_ISDIR, 7, "c")
+	if w.paths[2] != "/dir/c" || w.paths[4] != "/dir/c/sub" {
+		t.Errorf("moved directory not renamed: %v", w.paths)
+	}
+	if w.paths[3] != "/dir/b" {
+		t.Errorf("pending move expired early: %v", w.paths)
+	}
+
+	w.expireMoves(time.Now().Add(moveTimeout))
+	if _, ok := w.paths[3]; ok || len(w.moves) != 0 {
+		t.Errorf("directory moved away still watched: %v, %v", w.paths, w.moves)
+	}
+}
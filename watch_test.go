@@ -0,0 +1,151 @@
+package afero
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func expectWatchEvents(t *testing.T, fs Fs, w *WatchHandle, want ...WatchEvent) {
+	t.Helper()
+	for _, ev := range want {
+		ev.Name = filepath.FromSlash(ev.Name)
+		select {
+		case got := <-w.Events:
+			if got != ev {
+				t.Errorf("%v: got event %v, want %v", fs.Name(), got, ev)
+			}
+		case err := <-w.Errors:
+			t.Fatalf("%v: watch error %v", fs.Name(), err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: timeout waiting for %v", fs.Name(), ev)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	base := &MemMapFs{}
+	base.MkdirAll("/base/dir", 0755)
+	fss := []Fs{&MemMapFs{}, NewBasePathFs(base, "/base")}
+	if runtime.GOOS == "linux" {
+		fss = append(fss, NewBasePathFs(NewOsFs(), t.TempDir()))
+	}
+	for _, fs := range fss {
+		fs.MkdirAll("/dir/old", 0755)
+		w, err := Watch(fs, "/dir", true)
+		if err != nil {
+			t.Fatal(fs.Name(), err)
+		}
+
+		WriteFile(fs, "/dir/file", []byte("content"), 0644)
+		expectWatchEvents(t, fs, w,
+			WatchEvent{"/dir/file", WatchCreate},
+			WatchEvent{"/dir/file", WatchWrite})
+		fs.Chmod("/dir/file", 0600)
+		expectWatchEvents(t, fs, w, WatchEvent{"/dir/file", WatchChmod})
+
+		fs.Mkdir("/dir/sub", 0755)
+		expectWatchEvents(t, fs, w, WatchEvent{"/dir/sub", WatchCreate})
+		WriteFile(fs, "/dir/sub/nested", nil, 0644)
+		expectWatchEvents(t, fs, w,
+			WatchEvent{"/dir/sub/nested", WatchCreate},
+			WatchEvent{"/dir/sub/nested", WatchWrite})
+
+		fs.Rename("/dir/file", "/dir/sub/renamed")
+		expectWatchEvents(t, fs, w,
+			WatchEvent{"/dir/file", WatchRename},
+			WatchEvent{"/dir/sub/renamed", WatchCreate})
+		fs.Remove("/dir/sub/renamed")
+		expectWatchEvents(t, fs, w, WatchEvent{"/dir/sub/renamed", WatchRemove})
+
+		// a renamed directory is still watched under its new name
+		fs.Rename("/dir/old", "/dir/new")
+		expectWatchEvents(t, fs, w,
+			WatchEvent{"/dir/old", WatchRename},
+			WatchEvent{"/dir/new", WatchCreate})
+		WriteFile(fs, "/dir/new/file", nil, 0644)
+		expectWatchEvents(t, fs, w,
+			WatchEvent{"/dir/new/file", WatchCreate},
+			WatchEvent{"/dir/new/file", WatchWrite})
+
+		if err := w.Close(); err != nil {
+			t.Error(fs.Name(), err)
+		}
+		for range w.Events {
+		}
+		if _, err := Watch(fs, "/missing", false); !os.IsNotExist(err) {
+			t.Errorf("%v: watching a missing file: expected ErrNotExist, got %v", fs.Name(), err)
+		}
+	}
+}
+
+func TestMemMapFsWatch(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.MkdirAll("/dir/sub", 0755)
+	w, err := fs.Watch("/dir", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// only the direct entries of a directory without recursion
+	WriteFile(fs, "/dir/sub/deep", nil, 0644)
+	WriteFile(fs, "/other", nil, 0644)
+	fs.Chtimes("/dir", time.Now(), time.Now())
+	expectWatchEvents(t, fs, w, WatchEvent{"/dir", WatchChmod})
+
+	f, _ := fs.OpenFile("/dir/sub", os.O_RDONLY, 0)
+	f.Close()
+	fs.RemoveAll("/dir/sub")
+	expectWatchEvents(t, fs, w, WatchEvent{"/dir/sub", WatchRemove})
+
+	// events are dropped rather than blocking the filesystem
+	for i := 0; i < 2*watchEventQueue; i++ {
+		fs.Chmod("/dir", 0755)
+	}
+	if err := <-w.Errors; err != ErrWatchOverflow {
+		t.Errorf("expected ErrWatchOverflow, got %v", err)
+	}
+
+	if _, err := Watch(NewReadOnlyFs(fs), "/dir", false); !errors.Is(err, ErrNoWatch) {
+		t.Errorf("expected ErrNoWatch, got %v", err)
+	}
+}
+
+func TestMapWatchClose(t *testing.T) {
+	src := make(chan WatchEvent, 2*watchEventQueue)
+	srcErrs := make(chan error, 2*watchErrorQueue)
+	for i := 0; i < cap(src); i++ {
+		src <- WatchEvent{"/file", WatchWrite}
+	}
+	for i := 0; i < cap(srcErrs); i++ {
+		srcErrs <- ErrWatchOverflow
+	}
+	w := mapWatch(&WatchHandle{
+		Events: src,
+		Errors: srcErrs,
+		close: func() error {
+			close(src)
+			close(srcErrs)
+			return nil
+		},
+	}, func(ev WatchEvent) (WatchEvent, bool) { return ev, true })
+
+	// the forwarding blocks on the full queues until the watch is closed
+	for len(w.Events) < watchEventQueue || len(w.Errors) < watchErrorQueue {
+		time.Sleep(time.Millisecond)
+	}
+	closed := make(chan error)
+	go func() { closed <- w.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on the events not received")
+	}
+}
@@ -0,0 +1,423 @@
+package afero
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ZipFs is a read-only Fs serving the content of a zip archive. Directories
+// missing from the archive are synthesized from the file names, so every
+// parent of a file can be opened and listed. All writes fail with EPERM;
+// wrap it in a CopyOnWriteFs for a writable overlay:
+//
+//	r, _ := zip.OpenReader("bundle.zip")
+//	fs := afero.NewCopyOnWriteFs(afero.NewZipFs(&r.Reader), afero.NewMemMapFs())
+//
+// Stored (uncompressed) entries are read in place. Compressed entries are
+// streamed as long as they are read sequentially; the first Seek or ReadAt
+// decompresses the entry into memory.
+type ZipFs struct {
+	r     *zip.Reader
+	files map[string]*zipEntry
+}
+
+type zipEntry struct {
+	name     string
+	file     *zip.File // nil for synthesized directories
+	dir      bool
+	children []*zipEntry
+}
+
+func NewZipFs(r *zip.Reader) Fs {
+	fs := &ZipFs{r: r, files: make(map[string]*zipEntry)}
+	fs.files[FilePathSeparator] = &zipEntry{name: FilePathSeparator, dir: true}
+	for _, f := range r.File {
+		name := zipPath(f.Name)
+		dir := strings.HasSuffix(f.Name, "/") || f.FileInfo().IsDir()
+		if e, ok := fs.files[name]; ok {
+			// a directory entry following one of its files, or a duplicate
+			// name: the last one wins, as with unzip
+			if e.dir && !dir {
+				fs.removeTree(name)
+				e.children = nil
+			}
+			e.file, e.dir = f, dir
+			continue
+		}
+		fs.add(&zipEntry{name: name, file: f, dir: dir})
+	}
+	for _, e := range fs.files {
+		sort.Sort(zipEntriesByName(e.children))
+	}
+	return fs
+}
+
+// add adds e to the index, synthesizing its parent directories. A file
+// in the way of a parent directory is replaced, as when extracting.
+func (fs *ZipFs) add(e *zipEntry) {
+	fs.files[e.name] = e
+	parentName := filepath.Dir(e.name)
+	parent, ok := fs.files[parentName]
+	if !ok {
+		parent = &zipEntry{name: parentName, dir: true}
+		fs.add(parent)
+	} else if !parent.dir {
+		parent.file, parent.dir = nil, true
+	}
+	parent.children = append(parent.children, e)
+}
+
+// removeTree removes a directory replaced by a later entry.
+func (fs *ZipFs) removeTree(name string) {
+	prefix := name + FilePathSeparator
+	for p := range fs.files {
+		if strings.HasPrefix(p, prefix) {
+			delete(fs.files, p)
+		}
+	}
+}
+
+// zipPath turns the slash separated name of a zip entry into an absolute
+// path. Names trying to escape the root with ".." end up below it.
+func zipPath(name string) string {
+	return filepath.FromSlash(path.Clean("/" + name))
+}
+
+type zipEntriesByName []*zipEntry
+
+func (z zipEntriesByName) Len() int           { return len(z) }
+func (z zipEntriesByName) Less(i, j int) bool { return z[i].name < z[j].name }
+func (z zipEntriesByName) Swap(i, j int)      { z[i], z[j] = z[j], z[i] }
+
+func (fs *ZipFs) entry(op, name string) (*zipEntry, error) {
+	e, ok := fs.files[zipPath(filepath.ToSlash(name))]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (fs *ZipFs) Name() string {
+	return "ZipFs"
+}
+
+func (fs *ZipFs) Open(name string) (File, error) {
+	e, err := fs.entry("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &zipFile{entry: e}, nil
+}
+
+func (fs *ZipFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+	}
+	return fs.Open(name)
+}
+
+func (fs *ZipFs) Stat(name string) (os.FileInfo, error) {
+	e, err := fs.entry("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.info(), nil
+}
+
+func (fs *ZipFs) Create(name string) (File, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *ZipFs) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *ZipFs) MkdirAll(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *ZipFs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *ZipFs) RemoveAll(name string) error {
+	return &os.PathError{Op: "removeall", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *ZipFs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EPERM}
+}
+
+func (fs *ZipFs) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *ZipFs) Chtimes(name string, atime, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EPERM}
+}
+
+func (e *zipEntry) info() os.FileInfo {
+	return &zipFileInfo{e}
+}
+
+// zipFileInfo is the FileInfo of a zip entry. The zip.FileHeader knows most
+// of it, but reports the name in the archive.
+type zipFileInfo struct {
+	*zipEntry
+}
+
+func (fi *zipFileInfo) Name() string {
+	return filepath.Base(fi.name)
+}
+
+func (fi *zipFileInfo) Size() int64 {
+	if fi.dir || fi.file == nil {
+		return 0
+	}
+	return int64(fi.file.UncompressedSize64)
+}
+
+func (fi *zipFileInfo) Mode() os.FileMode {
+	if fi.file == nil {
+		return os.ModeDir | 0555
+	}
+	mode := fi.file.Mode()
+	if fi.dir {
+		mode |= os.ModeDir
+	}
+	return mode
+}
+
+func (fi *zipFileInfo) ModTime() time.Time {
+	if fi.file == nil {
+		return time.Time{}
+	}
+	return fi.file.Modified
+}
+
+func (fi *zipFileInfo) IsDir() bool {
+	return fi.dir
+}
+
+func (fi *zipFileInfo) Sys() interface{} {
+	if fi.file == nil {
+		return nil
+	}
+	return &fi.file.FileHeader
+}
+
+// zipFile is an open zip entry. Reads come from ra if the content can be
+// read at random offsets, else from the decompressing stream rc, which is
+// always at offset.
+type zipFile struct {
+	entry *zipEntry
+
+	mu     sync.Mutex
+	closed bool
+	offset int64
+	ra     io.ReaderAt
+	rc     io.ReadCloser
+	// next entry returned by Readdir
+	readdirPos int
+}
+
+func (f *zipFile) Name() string {
+	return f.entry.name
+}
+
+func (f *zipFile) Stat() (os.FileInfo, error) {
+	return f.entry.info(), nil
+}
+
+func (f *zipFile) size() int64 {
+	return f.entry.info().Size()
+}
+
+func (f *zipFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrFileClosed
+	}
+	f.closed = true
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
+
+// readerAt makes the content readable at any offset, reading stored
+// entries in place and decompressing others into memory.
+func (f *zipFile) readerAt() (io.ReaderAt, error) {
+	if f.ra != nil {
+		return f.ra, nil
+	}
+	if f.entry.file.Method == zip.Store {
+		if raw, err := f.entry.file.OpenRaw(); err == nil {
+			if ra, ok := raw.(io.ReaderAt); ok {
+				f.ra = ra
+				return ra, nil
+			}
+		}
+	}
+	rc, err := f.entry.file.Open()
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.entry.name, Err: err}
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: f.entry.name, Err: err}
+	}
+	if f.rc != nil {
+		f.rc.Close()
+		f.rc = nil
+	}
+	f.ra = bytes.NewReader(data)
+	return f.ra, nil
+}
+
+func (f *zipFile) check(op string) error {
+	if f.closed {
+		return ErrFileClosed
+	}
+	if f.entry.dir {
+		return &os.PathError{Op: op, Path: f.entry.name, Err: syscall.EISDIR}
+	}
+	return nil
+}
+
+func (f *zipFile) Read(p []byte) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	if f.ra == nil && f.offset == 0 && f.rc == nil {
+		if f.entry.file.Method == zip.Store {
+			if _, err := f.readerAt(); err != nil {
+				return 0, err
+			}
+		} else if f.rc, err = f.entry.file.Open(); err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.entry.name, Err: err}
+		}
+	}
+	if f.ra != nil {
+		if f.offset >= f.size() {
+			return 0, io.EOF
+		}
+		n, err = f.ra.ReadAt(p, f.offset)
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+	} else {
+		n, err = f.rc.Read(p)
+	}
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *zipFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.entry.name, Err: syscall.EINVAL}
+	}
+	ra, err := f.readerAt()
+	if err != nil {
+		return 0, err
+	}
+	return ra.ReadAt(p, off)
+}
+
+func (f *zipFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size()
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.entry.name, Err: syscall.EINVAL}
+	}
+	if offset != f.offset && !f.entry.dir {
+		if _, err := f.readerAt(); err != nil {
+			return 0, err
+		}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *zipFile) Readdir(count int) ([]os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, ErrFileClosed
+	}
+	if !f.entry.dir {
+		return nil, &os.PathError{Op: "readdir", Path: f.entry.name, Err: syscall.ENOTDIR}
+	}
+	children := f.entry.children[f.readdirPos:]
+	if count > 0 {
+		if len(children) == 0 {
+			return []os.FileInfo{}, io.EOF
+		}
+		if len(children) > count {
+			children = children[:count]
+		}
+	}
+	f.readdirPos += len(children)
+	list := make([]os.FileInfo, len(children))
+	for i, e := range children {
+		list[i] = e.info()
+	}
+	return list, nil
+}
+
+func (f *zipFile) Readdirnames(n int) ([]string, error) {
+	list, err := f.Readdir(n)
+	names := make([]string, len(list))
+	for i, fi := range list {
+		names[i] = fi.Name()
+	}
+	return names, err
+}
+
+func (f *zipFile) Sync() error {
+	return nil
+}
+
+func (f *zipFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.entry.name, Err: syscall.EPERM}
+}
+
+func (f *zipFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.entry.name, Err: syscall.EPERM}
+}
+
+func (f *zipFile) WriteString(s string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.entry.name, Err: syscall.EPERM}
+}
+
+func (f *zipFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.entry.name, Err: syscall.EPERM}
+}
@@ -0,0 +1,231 @@
+package afero
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newZipTestFs(t *testing.T) Fs {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	mtime := time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	for _, e := range []struct {
+		name    string
+		method  uint16
+		content string
+	}{
+		{"a.txt", zip.Deflate, strings.Repeat("deflated ", 100)},
+		{"dir/", zip.Store, ""},
+		{"dir/stored.txt", zip.Store, "0123456789"},
+		{"dir/sub/deep.txt", zip.Deflate, "deep"},
+		{"../escape.txt", zip.Store, "escape"},
+	} {
+		h := &zip.FileHeader{Name: e.name, Method: e.method, Modified: mtime}
+		if strings.HasSuffix(e.name, "/") {
+			h.SetMode(os.ModeDir | 0750)
+		} else {
+			h.SetMode(0640)
+		}
+		fw, err := w.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, e.content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewZipFs(r)
+}
+
+func TestZipFsStat(t *testing.T) {
+	fs := newZipTestFs(t)
+
+	for _, test := range []struct {
+		name string
+		size int64
+		mode os.FileMode
+	}{
+		{"/", 0, os.ModeDir | 0555},
+		{"/a.txt", 900, 0640},
+		{"/dir", 0, os.ModeDir | 0750},
+		{"dir/stored.txt", 10, 0640},
+		{"/dir/sub", 0, os.ModeDir | 0555},
+		{"/dir/sub/deep.txt", 4, 0640},
+		{"/escape.txt", 6, 0640},
+	} {
+		fi, err := fs.Stat(test.name)
+		if err != nil {
+			t.Errorf("Stat(%q): %v", test.name, err)
+			continue
+		}
+		if fi.Size() != test.size || fi.Mode() != test.mode || fi.IsDir() != test.mode.IsDir() {
+			t.Errorf("Stat(%q): got size %d mode %v", test.name, fi.Size(), fi.Mode())
+		}
+	}
+	fi, _ := fs.Stat("/dir/stored.txt")
+	if fi.Name() != "stored.txt" || !fi.ModTime().Equal(time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)) {
+		t.Errorf("unexpected name %q or mtime %v", fi.Name(), fi.ModTime())
+	}
+	if _, err := fs.Stat("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat of a missing file: expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestZipFsReaddir(t *testing.T) {
+	fs := newZipTestFs(t)
+
+	names := func(dir string) []string {
+		f, err := fs.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+	if got := names("/"); !reflect.DeepEqual(got, []string{"a.txt", "dir", "escape.txt"}) {
+		t.Errorf("Readdirnames(/): got %v", got)
+	}
+	if got := names("/dir"); !reflect.DeepEqual(got, []string{"stored.txt", "sub"}) {
+		t.Errorf("Readdirnames(/dir): got %v", got)
+	}
+
+	f, _ := fs.Open("/dir")
+	defer f.Close()
+	if list, err := f.Readdir(1); err != nil || len(list) != 1 || list[0].Name() != "stored.txt" {
+		t.Errorf("Readdir(1): got %v, %v", list, err)
+	}
+	if list, err := f.Readdir(5); err != nil || len(list) != 1 || !list[0].IsDir() {
+		t.Errorf("Readdir(5): got %v, %v", list, err)
+	}
+	if _, err := f.Readdir(1); err != io.EOF {
+		t.Errorf("Readdir at the end: expected io.EOF, got %v", err)
+	}
+
+	var walked []string
+	Walk(fs, "/", func(path string, info os.FileInfo, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	want := []string{"/", "/a.txt", "/dir", "/dir/stored.txt", "/dir/sub", "/dir/sub/deep.txt", "/escape.txt"}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("Walk: got %v, want %v", walked, want)
+	}
+}
+
+func TestZipFsRead(t *testing.T) {
+	fs := newZipTestFs(t)
+
+	for _, name := range []string{"/a.txt", "/dir/stored.txt"} {
+		want, err := ReadFile(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := f.Read(buf); err != nil || string(buf) != string(want[:4]) {
+			t.Errorf("%s: Read: got %q, %v", name, buf, err)
+		}
+		if _, err := f.Seek(-3, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		rest, err := io.ReadAll(f)
+		if err != nil || string(rest) != string(want[len(want)-3:]) {
+			t.Errorf("%s: Read after Seek: got %q, %v", name, rest, err)
+		}
+		n, err := f.ReadAt(buf, int64(len(want)-2))
+		if n != 2 || err != io.EOF || string(buf[:n]) != string(want[len(want)-2:]) {
+			t.Errorf("%s: short ReadAt: got %d, %q, %v", name, n, buf[:n], err)
+		}
+		if _, err := f.Write([]byte("x")); !os.IsPermission(err) {
+			t.Errorf("%s: Write: expected EPERM, got %v", name, err)
+		}
+		f.Close()
+	}
+
+	if data, err := ReadFile(fs, "/a.txt"); err != nil || string(data) != strings.Repeat("deflated ", 100) {
+		t.Errorf("ReadFile: got %d bytes, %v", len(data), err)
+	}
+	if _, err := fs.Create("/new"); !os.IsPermission(err) {
+		t.Errorf("Create: expected EPERM, got %v", err)
+	}
+	if err := fs.Remove("/a.txt"); !os.IsPermission(err) {
+		t.Errorf("Remove: expected EPERM, got %v", err)
+	}
+}
+
+func TestZipFsCopyOnWrite(t *testing.T) {
+	layer := NewMemMapFs()
+	fs := NewCopyOnWriteFs(newZipTestFs(t), layer)
+
+	if err := WriteFile(fs, "/dir/stored.txt", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/dir/sub/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ReadFile(fs, "/dir/stored.txt"); string(data) != "changed" {
+		t.Errorf("got %q from the overlay", data)
+	}
+	if data, _ := ReadFile(fs, "/dir/sub/deep.txt"); string(data) != "deep" {
+		t.Errorf("got %q from the zip", data)
+	}
+	names, err := ReadDir(fs, "/dir/sub")
+	if err != nil || len(names) != 2 {
+		t.Errorf("ReadDir of the merged directory: got %v, %v", names, err)
+	}
+}
+
+func TestZipFsReplacedEntries(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"a", "a/b", "c/d", "c"} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, name)
+	}
+	w.Close()
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewZipFs(r)
+
+	if fi, err := fs.Stat("/a"); err != nil || !fi.IsDir() {
+		t.Errorf("a file replaced by a directory: got %v, %v", fi, err)
+	}
+	if names, err := ReadDir(fs, "/a"); err != nil || len(names) != 1 || names[0].Name() != "b" {
+		t.Errorf("Readdir(/a): got %v, %v", names, err)
+	}
+	if data, err := ReadFile(fs, "/a/b"); err != nil || string(data) != "a/b" {
+		t.Errorf("/a/b: got %q, %v", data, err)
+	}
+	if fi, err := fs.Stat("/c"); err != nil || fi.IsDir() {
+		t.Errorf("a directory replaced by a file: got %v, %v", fi, err)
+	}
+	if _, err := fs.Stat("/c/d"); err == nil {
+		t.Error("the content of a replaced directory should be gone")
+	}
+	if _, err := ReadDir(fs, "/c"); err == nil {
+		t.Error("Readdir of a file succeeded")
+	}
+}